@@ -0,0 +1,105 @@
+//go:build !test
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/mohsenabedy91/Sikabiz/cmd/setup"
+	"github.com/mohsenabedy91/Sikabiz/internal/adaper/storage/postgres"
+	"github.com/mohsenabedy91/Sikabiz/internal/adaper/storage/postgres/userrepository"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/config"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/service/purgeservice"
+	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"github.com/spf13/cobra"
+	"log"
+	"time"
+)
+
+var (
+	days      int
+	mode      string
+	batchSize int
+	dryRun    bool
+)
+
+var purgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Purge the users and addresses soft deleted longer ago than the retention period",
+	Long: `Purge the users and addresses soft deleted longer ago than the retention period, they are deleted or
+anonymized batch by batch and the audit log records every purged row, --dry-run lists them and changes nothing.
+The flags override the RETENTION_* settings.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		configProvider := &config.Config{}
+		conf := configProvider.GetConfig()
+		purgeLog := logger.NewLogger("purge", conf.Log)
+
+		if cmd.Flags().Changed("days") {
+			conf.Retention.Days = days
+		}
+		if cmd.Flags().Changed("mode") {
+			conf.Retention.Mode = mode
+		}
+		if cmd.Flags().Changed("batch-size") {
+			conf.Retention.BatchSize = batchSize
+		}
+		if conf.Retention.Days < 0 {
+			return fmt.Errorf("the retention days can not be negative, got %d", conf.Retention.Days)
+		}
+
+		ctx := cmd.Context()
+		shutdownTracing := setup.InitializeTracing(ctx, purgeLog, conf, "purge")
+		defer shutdownTracing()
+
+		db, err := setup.InitializeDatabase(ctx, purgeLog, conf)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = postgres.Close()
+		}()
+
+		options := setup.PurgeOptions(conf.Retention)
+		options.DryRun = dryRun
+
+		report, err := purgeservice.New(purgeLog).Purge(ctx, func() port.UserUnitOfWork {
+			return userrepository.NewUnitOfWork(purgeLog, db)
+		}, options)
+		if report != nil {
+			printReport(report, options)
+		}
+		return err
+	},
+}
+
+func init() {
+	purgeCmd.Flags().IntVar(&days, "days", 30, "Days the soft deleted rows are kept for")
+	purgeCmd.Flags().StringVar(&mode, "mode", domain.PurgeDelete, "delete or anonymize")
+	purgeCmd.Flags().IntVar(&batchSize, "batch-size", domain.DefaultPurgeBatchSize, "Rows purged in one transaction")
+	purgeCmd.Flags().BoolVar(&dryRun, "dry-run", false, "List the rows which would be purged and change nothing")
+}
+
+func main() {
+	if err := purgeCmd.ExecuteContext(context.Background()); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+func printReport(report *domain.PurgeReport, options domain.PurgeOptions) {
+	verb := "purged"
+	if report.DryRun {
+		verb = "would purge"
+	}
+
+	for _, candidates := range [][]domain.PurgeCandidate{report.Users, report.Addresses} {
+		for _, candidate := range candidates {
+			fmt.Printf("%s\t%s\tuser=%s\tdeleted_at=%s\n",
+				candidate.Entity, candidate.UUID, candidate.UserUUID, candidate.DeletedAt.Format(time.RFC3339))
+		}
+	}
+	fmt.Printf("%s %d users and %d addresses deleted before %s (mode %s)\n",
+		verb, len(report.Users), len(report.Addresses), options.DeletedBefore.Format(time.RFC3339), report.Mode)
+}
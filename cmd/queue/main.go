@@ -0,0 +1,360 @@
+//go:build !test
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/mohsenabedy91/Sikabiz/cmd/setup"
+	"github.com/mohsenabedy91/Sikabiz/internal/adaper/meesagebroker"
+	"github.com/mohsenabedy91/Sikabiz/internal/adaper/storage/postgres"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/config"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
+	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+	"time"
+)
+
+var queueCmd = &cobra.Command{
+	Use:   "queue",
+	Short: "Inspect and repair the message queues",
+}
+
+func main() {
+	if err := queueCmd.Execute(); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+// statsCmd represents the queue stats command
+var statsCmd = &cobra.Command{
+	Use:   "stats <queue>",
+	Short: "Show the depth of a queue",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		admin, closeDriver, err := connect()
+		if err != nil {
+			return err
+		}
+		defer closeDriver()
+
+		stats, err := admin.Stats(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("queue:     %s\n", stats.Name)
+		fmt.Printf("messages:  %d\n", stats.Messages)
+		fmt.Printf("consumers: %d\n", stats.Consumers)
+		return nil
+	},
+}
+
+// peekCmd represents the queue peek command
+var peekCmd = &cobra.Command{
+	Use:   "peek <queue>",
+	Short: "Show messages without consuming them",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		admin, closeDriver, err := connect()
+		if err != nil {
+			return err
+		}
+		defer closeDriver()
+
+		messages, err := admin.Peek(cmd.Context(), args[0], peekLimit, domain.QueueFilter{})
+		if err != nil {
+			return err
+		}
+
+		for _, message := range messages {
+			fmt.Printf("%s\t%s\t%s\tattempts=%d\t%s\n",
+				message.ID, message.Type, message.Timestamp.Format(time.RFC3339), message.Attempts, message.Body)
+		}
+		return nil
+	},
+}
+
+// purgeCmd represents the queue purge command
+var purgeCmd = &cobra.Command{
+	Use:   "purge <queue>",
+	Short: "Delete every message of a queue",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !force {
+			return errors.New("purge deletes the messages for good, pass --force to confirm")
+		}
+
+		admin, closeDriver, err := connect()
+		if err != nil {
+			return err
+		}
+		defer closeDriver()
+
+		purged, err := admin.Purge(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("purged %d messages from %s\n", purged, args[0])
+		return nil
+	},
+}
+
+// moveCmd represents the queue move command
+var moveCmd = &cobra.Command{
+	Use:   "move <from> <to>",
+	Short: "Move messages between queues, e.g. from the dead-letter queue back to the main queue",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		admin, closeDriver, err := connect()
+		if err != nil {
+			return err
+		}
+		defer closeDriver()
+
+		moved, err := admin.Move(cmd.Context(), args[0], args[1], moveLimit, filter())
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("moved %d messages from %s to %s\n", moved, args[0], args[1])
+		return nil
+	},
+}
+
+// exportCmd represents the queue export command
+var exportCmd = &cobra.Command{
+	Use:   "export <queue> <file>",
+	Short: "Write messages to an NDJSON file without consuming them",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		admin, closeDriver, err := connect()
+		if err != nil {
+			return err
+		}
+		defer closeDriver()
+
+		// the limit counts the matching messages, so the filter is applied by the driver
+		messages, err := admin.Peek(cmd.Context(), args[0], exportLimit, filter())
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Create(args[1])
+		if err != nil {
+			return err
+		}
+		defer func(file *os.File) {
+			_ = file.Close()
+		}(file)
+
+		writer := bufio.NewWriter(file)
+		encoder := json.NewEncoder(writer)
+		for _, message := range messages {
+			if err = encoder.Encode(toExportRecord(message)); err != nil {
+				return err
+			}
+		}
+		if err = writer.Flush(); err != nil {
+			return err
+		}
+
+		fmt.Printf("exported %d messages from %s to %s\n", len(messages), args[0], args[1])
+		return nil
+	},
+}
+
+// scheduledCmd represents the queue scheduled command
+var scheduledCmd = &cobra.Command{
+	Use:   "scheduled <id>",
+	Short: "Show a scheduled message",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := uuid.Parse(args[0])
+		if err != nil {
+			return err
+		}
+
+		scheduler, closeScheduler, err := connectScheduler()
+		if err != nil {
+			return err
+		}
+		defer closeScheduler()
+
+		message, err := scheduler.Get(cmd.Context(), id)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("id:         %s\n", message.ID)
+		fmt.Printf("queue:      %s\n", message.Queue)
+		fmt.Printf("type:       %s\n", message.Envelope.Type)
+		fmt.Printf("deliver at: %s\n", message.DeliverAt.Format(time.RFC3339))
+		fmt.Printf("status:     %s\n", message.Status)
+		fmt.Printf("attempts:   %d\n", message.Attempts)
+		if message.LastError != "" {
+			fmt.Printf("last error: %s\n", message.LastError)
+		}
+		return nil
+	},
+}
+
+// cancelCmd represents the queue cancel command
+var cancelCmd = &cobra.Command{
+	Use:   "cancel <id>",
+	Short: "Cancel a scheduled message which is not dispatched yet",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := uuid.Parse(args[0])
+		if err != nil {
+			return err
+		}
+
+		scheduler, closeScheduler, err := connectScheduler()
+		if err != nil {
+			return err
+		}
+		defer closeScheduler()
+
+		if err = scheduler.Cancel(cmd.Context(), id); err != nil {
+			return err
+		}
+
+		fmt.Printf("cancelled %s\n", id)
+		return nil
+	},
+}
+
+// rescheduleCmd represents the queue reschedule command
+var rescheduleCmd = &cobra.Command{
+	Use:   "reschedule <id> <time>",
+	Short: "Move the delivery time of a scheduled message, the time is RFC 3339",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := uuid.Parse(args[0])
+		if err != nil {
+			return err
+		}
+		deliverAt, err := time.Parse(time.RFC3339, args[1])
+		if err != nil {
+			return err
+		}
+
+		scheduler, closeScheduler, err := connectScheduler()
+		if err != nil {
+			return err
+		}
+		defer closeScheduler()
+
+		if err = scheduler.Reschedule(cmd.Context(), id, deliverAt); err != nil {
+			return err
+		}
+
+		fmt.Printf("rescheduled %s to %s\n", id, deliverAt.Format(time.RFC3339))
+		return nil
+	},
+}
+
+type exportRecord struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Timestamp time.Time       `json:"timestamp"`
+	Attempts  int             `json:"attempts"`
+	Body      json.RawMessage `json:"body"`
+}
+
+func toExportRecord(message domain.QueueMessage) exportRecord {
+	body := message.Body
+	if !json.Valid(body) {
+		body, _ = json.Marshal(string(message.Body))
+	}
+
+	return exportRecord{
+		ID:        message.ID,
+		Type:      message.Type,
+		Timestamp: message.Timestamp,
+		Attempts:  message.Attempts,
+		Body:      body,
+	}
+}
+
+func filter() domain.QueueFilter {
+	return domain.QueueFilter{
+		Type:     messageType,
+		Contains: contains,
+	}
+}
+
+func connect() (port.QueueAdmin, func(), error) {
+	configProvider := &config.Config{}
+	conf := configProvider.GetConfig()
+	log := logger.NewLogger("queue", conf.Log)
+
+	queue, err := setup.InitializeQueue(log, conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	admin, ok := queue.Driver.(port.QueueAdmin)
+	if !ok {
+		queue.Driver.Close()
+		return nil, nil, fmt.Errorf("queue driver %q does not support administration", conf.Queue.Driver)
+	}
+
+	return admin, queue.Driver.Close, nil
+}
+
+// connectScheduler the scheduled messages live in the database whichever driver is configured
+func connectScheduler() (port.Scheduler, func(), error) {
+	configProvider := &config.Config{}
+	conf := configProvider.GetConfig()
+	log := logger.NewLogger("queue", conf.Log)
+
+	queue, err := setup.InitializeQueue(log, conf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db, err := setup.InitializeDatabase(context.Background(), log, conf)
+	if err != nil {
+		queue.Driver.Close()
+		return nil, nil, err
+	}
+
+	return setup.InitializeScheduler(log, conf, queue, db), func() {
+		queue.Driver.Close()
+		_ = postgres.Close()
+	}, nil
+}
+
+var (
+	peekLimit   int
+	exportLimit int
+	moveLimit   int
+	force       bool
+	messageType string
+	contains    string
+)
+
+func init() {
+	peekCmd.Flags().IntVarP(&peekLimit, "limit", "n", 10, "Number of messages to show")
+	exportCmd.Flags().IntVarP(&exportLimit, "limit", "n", 1000, "Number of messages to export")
+	moveCmd.Flags().IntVarP(&moveLimit, "limit", "n", 0, "Number of messages to move, 0 moves all of them")
+	purgeCmd.Flags().BoolVar(&force, "force", false, "Confirm deleting the messages")
+
+	for _, cmd := range []*cobra.Command{moveCmd, exportCmd} {
+		cmd.Flags().StringVar(&messageType, "type", "", "Only messages of this envelope type")
+		cmd.Flags().StringVar(&contains, "contains", "", "Only messages whose body contains this text")
+	}
+
+	queueCmd.Example = fmt.Sprintf("  queue move save_user_queue%s save_user_queue --type user.save", messagebroker.DeadLetterSuffix)
+	queueCmd.AddCommand(statsCmd, peekCmd, purgeCmd, moveCmd, exportCmd, scheduledCmd, cancelCmd, rescheduleCmd)
+}
@@ -9,10 +9,17 @@ import (
 	"github.com/mohsenabedy91/Sikabiz/internal/adaper/meesagebroker"
 	"github.com/mohsenabedy91/Sikabiz/internal/adaper/storage/postgres"
 	"github.com/mohsenabedy91/Sikabiz/internal/core/config"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
 	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"github.com/mohsenabedy91/Sikabiz/pkg/tracing"
+	"time"
 )
 
+// InitializeDatabase returns the existing client when the queue driver already opened it
 func InitializeDatabase(ctx context.Context, log logger.Logger, conf config.Config) (*sql.DB, error) {
+	if db := postgres.Get(); db != nil {
+		return db, nil
+	}
 	if err := postgres.InitClient(ctx, log, conf); err != nil {
 		return nil, err
 	}
@@ -22,13 +29,115 @@ func InitializeDatabase(ctx context.Context, log logger.Logger, conf config.Conf
 func InitializeQueue(log logger.Logger, conf config.Config) (*messagebroker.Queue, error) {
 	queue := messagebroker.NewQueue(log, conf)
 
-	driver, err := messagebroker.NewRabbitMQ(conf.RabbitMQ.URL, log)
+	codecs, err := messagebroker.NewCodecs(conf.Queue)
+	if err != nil {
+		log.Fatal(logger.Queue, logger.Startup, fmt.Sprintf("Failed to setup codecs, error: %v", err), nil)
+		return nil, err
+	}
+
+	switch conf.Queue.Driver {
+	case "memory":
+		queue.Driver = messagebroker.NewMemory(conf.Queue, codecs, log).WithRoutes(domain.UserEventTypes...)
+		return queue, nil
+	case "postgres":
+		db, err := InitializeDatabase(context.Background(), log, conf)
+		if err != nil {
+			log.Fatal(logger.Queue, logger.Startup, fmt.Sprintf("Failed to setup queue, error: %v", err), nil)
+			return nil, err
+		}
+
+		driver, err := messagebroker.NewPostgres(conf.Queue, codecs, postgres.DSN(conf), db, log)
+		if err != nil {
+			log.Fatal(logger.Queue, logger.Startup, fmt.Sprintf("Failed to setup queue, error: %v", err), nil)
+			return nil, err
+		}
+		queue.Driver = driver.WithRoutes(domain.UserEventTypes...)
+		return queue, nil
+	}
+
+	topology, err := InitializeTopology(conf)
+	if err != nil {
+		log.Fatal(logger.Queue, logger.Startup, fmt.Sprintf("Failed to load topology, error: %v", err), nil)
+		return nil, err
+	}
+
+	// the events which are not in the topology file go to the user events exchange, no queue is declared for them
+	routed := topology.WithRoutes(messagebroker.TopologyExchange{
+		Name:    domain.UserEventsExchange,
+		Kind:    "topic",
+		Durable: true,
+	}, domain.UserEventTypes...)
+
+	driver, err := messagebroker.NewRabbitMQ(conf, routed, codecs, log)
 	if err != nil {
 		log.Fatal(logger.Queue, logger.Startup, fmt.Sprintf("Failed to setup queue, error: %v", err), nil)
 		return nil, err
 	}
 
+	if topology != nil {
+		if conf.RabbitMQ.TopologyAutoApply {
+			if err = driver.ApplyTopology(topology); err != nil {
+				log.Fatal(logger.Queue, logger.Startup, fmt.Sprintf("Failed to apply topology, error: %v", err), nil)
+				return nil, err
+			}
+		}
+
+		if err = driver.VerifyTopology(topology); err != nil {
+			log.Fatal(logger.Queue, logger.Startup, fmt.Sprintf("Live topology does not match the config, error: %v", err), nil)
+			return nil, err
+		}
+	}
+
 	queue.Driver = driver
 
 	return queue, nil
 }
+
+// InitializeScheduler attaches the scheduler to the queue, only the binaries which run it dispatch the due messages
+func InitializeScheduler(log logger.Logger, conf config.Config, queue *messagebroker.Queue, db *sql.DB) *messagebroker.Scheduler {
+	scheduler := messagebroker.NewScheduler(conf.Queue, db, queue.Driver, log)
+	queue.Scheduler = scheduler
+
+	// the scheduler dispatches with no delay, so the delayed messages do not come back to it
+	if driver, ok := queue.Driver.(*messagebroker.RabbitMQ); ok {
+		driver.WithScheduler(scheduler)
+	}
+
+	return scheduler
+}
+
+// InitializeTopology returns nil when no topology file is configured
+func InitializeTopology(conf config.Config) (*messagebroker.Topology, error) {
+	if conf.RabbitMQ.TopologyPath == "" {
+		return nil, nil
+	}
+
+	return messagebroker.LoadTopology(conf.RabbitMQ.TopologyPath)
+}
+
+// InitializeTracing the returned shutdown exports the buffered spans, it is called at exit
+func InitializeTracing(ctx context.Context, log logger.Logger, conf config.Config, serviceName string) func() {
+	shutdown, err := tracing.Init(ctx, serviceName, conf.Tracing)
+	if err != nil {
+		log.Fatal(logger.Internal, logger.Tracing, fmt.Sprintf("Failed to setup tracing, error: %v", err), nil)
+		return func() {}
+	}
+
+	return func() {
+		ctxWithTimeout, cancel := context.WithTimeout(ctx, conf.App.GracefullyShutdown*time.Second)
+		defer cancel()
+
+		if err = shutdown(ctxWithTimeout); err != nil {
+			log.Error(logger.Internal, logger.Tracing, fmt.Sprintf("Failed to flush the spans, error: %v", err), nil)
+		}
+	}
+}
+
+// PurgeOptions the rows soft deleted more than the retention days ago are purged
+func PurgeOptions(conf config.Retention) domain.PurgeOptions {
+	return domain.PurgeOptions{
+		DeletedBefore: time.Now().AddDate(0, 0, -conf.Days),
+		Mode:          conf.Mode,
+		BatchSize:     conf.BatchSize,
+	}
+}
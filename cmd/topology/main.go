@@ -0,0 +1,88 @@
+//go:build !test
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mohsenabedy91/Sikabiz/cmd/setup"
+	"github.com/mohsenabedy91/Sikabiz/internal/adaper/meesagebroker"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/config"
+	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"github.com/spf13/cobra"
+	"log"
+)
+
+var topologyCmd = &cobra.Command{Use: "topology"}
+
+func main() {
+	if err := topologyCmd.Execute(); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
+
+// applyCmd represents the topology apply command
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Declare the broker topology",
+	Long:  `Declare the exchanges, queues and bindings from the topology file on the broker.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		conf, log, driver, topology := connect()
+		defer driver.Close()
+
+		if err := driver.ApplyTopology(topology); err != nil {
+			log.Fatal(logger.Queue, logger.Startup, fmt.Sprintf("Apply topology failed: %v", err), nil)
+			return
+		}
+
+		log.Info(logger.Queue, logger.Startup, "Topology applied successfully", map[logger.ExtraKey]interface{}{
+			logger.Path: conf.RabbitMQ.TopologyPath,
+		})
+	},
+}
+
+// verifyCmd represents the topology verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the broker topology",
+	Long:  `Verify the exchanges and queues on the broker match the topology file.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		conf, log, driver, topology := connect()
+		defer driver.Close()
+
+		if err := driver.VerifyTopology(topology); err != nil {
+			log.Fatal(logger.Queue, logger.Startup, fmt.Sprintf("Topology does not match: %v", err), nil)
+			return
+		}
+
+		log.Info(logger.Queue, logger.Startup, "Topology matches the config", map[logger.ExtraKey]interface{}{
+			logger.Path: conf.RabbitMQ.TopologyPath,
+		})
+	},
+}
+
+func connect() (config.Config, logger.Logger, *messagebroker.RabbitMQ, *messagebroker.Topology) {
+	configProvider := &config.Config{}
+	conf := configProvider.GetConfig()
+	log := logger.NewLogger("topology", conf.Log)
+
+	topology, err := setup.InitializeTopology(conf)
+	if err == nil && topology == nil {
+		err = errors.New("RABBITMQ_TOPOLOGY_PATH is not set")
+	}
+	if err != nil {
+		log.Fatal(logger.Queue, logger.Startup, fmt.Sprintf("Load topology failed: %v", err), nil)
+	}
+
+	// the topology commands never produce, so they need no codecs
+	driver, err := messagebroker.NewRabbitMQ(conf, topology, nil, log)
+	if err != nil {
+		log.Fatal(logger.Queue, logger.Startup, fmt.Sprintf("Connect to RabbitMQ failed: %v", err), nil)
+	}
+
+	return conf, log, driver, topology
+}
+
+func init() {
+	topologyCmd.AddCommand(applyCmd, verifyCmd)
+}
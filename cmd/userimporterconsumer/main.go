@@ -4,47 +4,170 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
 	"github.com/mohsenabedy91/Sikabiz/cmd/setup"
 	"github.com/mohsenabedy91/Sikabiz/internal/adaper/meesagebroker"
+	"github.com/mohsenabedy91/Sikabiz/internal/adaper/storage/postgres"
+	"github.com/mohsenabedy91/Sikabiz/internal/adaper/storage/postgres/userrepository"
 	"github.com/mohsenabedy91/Sikabiz/internal/core/config"
 	"github.com/mohsenabedy91/Sikabiz/internal/core/event"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/service/purgeservice"
 	"github.com/mohsenabedy91/Sikabiz/internal/core/service/userservice"
 	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"github.com/spf13/cobra"
+	stdlog "log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
+var consumerCmd = &cobra.Command{
+	Use:   "userimporterconsumer",
+	Short: "Consume the queues of the registered events",
+	Long:  `Consume the queues of the registered events, --events limits the consumer to a subset of them.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		run()
+	},
+}
+
+var events []string
+
+func init() {
+	consumerCmd.Flags().StringSliceVarP(&events, "events", "e", nil, "Names of the events to consume, all of them when empty")
+}
+
 func main() {
+	if err := consumerCmd.Execute(); err != nil {
+		stdlog.Fatalf("Error: %v", err)
+	}
+}
+
+func run() {
 	configProvider := &config.Config{}
 	conf := configProvider.GetConfig()
 	log := logger.NewLogger("User Importer Consumer", conf.Log)
 
+	ctx := context.Background()
+	shutdownTracing := setup.InitializeTracing(ctx, log, conf, "user-importer-consumer")
+	defer shutdownTracing()
+
 	queue, err := setup.InitializeQueue(log, conf)
 	if err != nil {
 		return
 	}
-	defer queue.Driver.Close()
 
-	ctx := context.Background()
 	postgresDB, err := setup.InitializeDatabase(ctx, log, conf)
 	if err != nil {
 		log.Fatal(logger.Database, logger.Startup, err.Error(), nil)
 		return
 	}
 
-	userService := userservice.New(log)
+	userService := userservice.New(log, event.NewUserEvents(queue, log))
 	log.Info(logger.Queue, logger.Startup, "Setup queue successfully", nil)
 
-	messagebroker.RegisterEvents(
-		event.NewSaveUser(queue, log, postgresDB, userService),
-		// add new queues here
-		// ...
-	)
+	scheduler := setup.InitializeScheduler(log, conf, queue, postgresDB)
+	schedulerCtx, stopScheduler := context.WithCancel(ctx)
+	defer stopScheduler()
+	schedulerDone := make(chan struct{})
+	go func() {
+		scheduler.Run(schedulerCtx)
+		close(schedulerDone)
+	}()
+
+	purgeCtx, stopPurge := context.WithCancel(ctx)
+	defer stopPurge()
+	purgeDone := make(chan struct{})
+	go func() {
+		runPurge(purgeCtx, log, conf, postgresDB)
+		close(purgeDone)
+	}()
+
+	registry, err := event.NewDefaultRegistry(event.Dependencies{
+		Queue: queue,
+		Log:   log,
+		UnitOfWork: func() port.UserUnitOfWork {
+			return userrepository.NewUnitOfWork(log, postgresDB)
+		},
+		UserService: userService,
+	})
+	if err != nil {
+		log.Fatal(logger.Queue, logger.Startup, err.Error(), nil)
+		return
+	}
+
+	if err = registry.Subscribe(events...); err != nil {
+		log.Fatal(logger.Queue, logger.Startup, err.Error(), nil)
+		return
+	}
+
+	monitoringServer := startMonitoringServer(log, conf, queue, postgresDB)
 
 	signalCh := make(chan os.Signal, 1)
 	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
 	<-signalCh
 
 	log.Info(logger.Internal, logger.Shutdown, "Shutdown Server ...", nil)
+
+	shutdownMonitoringServer(ctx, monitoringServer, log)
+
+	// the scheduler produces to the driver, so it stops first
+	stopScheduler()
+	<-schedulerDone
+
+	stopPurge()
+	<-purgeDone
+
+	shutdownConsumer(ctx, queue, log, conf)
+}
+
+// runPurge purges the expired soft deleted rows every retention interval until ctx is done,
+// the consumers of several instances may purge side by side, SKIP LOCKED gives every row to one of them
+func runPurge(ctx context.Context, log logger.Logger, conf config.Config, db *sql.DB) {
+	if !conf.Retention.Enable {
+		return
+	}
+
+	interval := conf.Retention.Interval * time.Second
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	purgeService := purgeservice.New(log)
+	uowFactory := func() port.UserUnitOfWork {
+		return userrepository.NewUnitOfWork(log, db)
+	}
+
+	for {
+		if _, err := purgeService.Purge(ctx, uowFactory, setup.PurgeOptions(conf.Retention)); err != nil && ctx.Err() == nil {
+			log.Error(logger.Database, logger.Purge, fmt.Sprintf("Error purge the soft deleted rows: %v", err), nil)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// shutdownConsumer closes the database pool only after the in-flight deliveries are finished,
+// so their transactions are not cut off
+func shutdownConsumer(ctx context.Context, queue *messagebroker.Queue, log logger.Logger, conf config.Config) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, conf.App.GracefullyShutdown*time.Second)
+	defer cancel()
+
+	if err := queue.Driver.Shutdown(ctxWithTimeout); err != nil {
+		log.Error(logger.Queue, logger.Shutdown, fmt.Sprintf("Could not drain the consumers: %v", err), nil)
+	}
+
+	if err := postgres.Close(); err != nil {
+		log.Error(logger.Database, logger.Shutdown, fmt.Sprintf("Error closing database: %v", err), nil)
+	}
+
+	log.Info(logger.Internal, logger.Shutdown, "Consumer exiting", nil)
 }
@@ -0,0 +1,102 @@
+//go:build !test
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/mohsenabedy91/Sikabiz/internal/adaper/meesagebroker"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/config"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
+	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"github.com/mohsenabedy91/Sikabiz/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+// startMonitoringServer serves the metrics and the health endpoints of the consumer,
+// ready fails while the database or the queue backend is unreachable
+func startMonitoringServer(
+	log logger.Logger,
+	conf config.Config,
+	queue *messagebroker.Queue,
+	db *sql.DB,
+) *http.Server {
+	registerPrometheus(log)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/health/live", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/health/ready", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		if err := ready(ctx, queue, db); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(err.Error()))
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	server := &http.Server{
+		Addr:    fmt.Sprintf("%s:%s", conf.App.HTTPUrl, conf.App.ConsumerHTTPPort),
+		Handler: mux,
+	}
+	log.Info(logger.Internal, logger.Startup, "Starting the monitoring server", map[logger.ExtraKey]interface{}{
+		logger.ListeningAddress: server.Addr,
+	})
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error(logger.Internal, logger.Startup, fmt.Sprintf("Error starting the monitoring server: %v", err), nil)
+		}
+	}()
+
+	return server
+}
+
+func ready(ctx context.Context, queue *messagebroker.Queue, db *sql.DB) error {
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("database: %w", err)
+	}
+
+	if checker, ok := queue.Driver.(port.HealthChecker); ok {
+		if err := checker.Health(ctx); err != nil {
+			return fmt.Errorf("queue: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func registerPrometheus(log logger.Logger) {
+	for _, collector := range []prometheus.Collector{
+		metrics.DbCall,
+		metrics.QueueMessage,
+		metrics.QueueHandlerDuration,
+		metrics.QueueReconnect,
+	} {
+		if err := prometheus.Register(collector); err != nil {
+			log.Error(logger.Prometheus, logger.Startup, err.Error(), nil)
+		}
+	}
+}
+
+func shutdownMonitoringServer(ctx context.Context, server *http.Server, log logger.Logger) {
+	ctxWithTimeout, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(ctxWithTimeout); err != nil {
+		log.Error(logger.Internal, logger.Shutdown, fmt.Sprintf("Shutdown monitoring server: %v", err), nil)
+	}
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/mohsenabedy91/Sikabiz/cmd/setup"
+	"github.com/mohsenabedy91/Sikabiz/internal/adaper/meesagebroker"
 	"github.com/mohsenabedy91/Sikabiz/internal/adaper/storage/postgres/userrepository"
 	"github.com/mohsenabedy91/Sikabiz/internal/core/config"
 	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
@@ -12,6 +14,7 @@ import (
 	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
 	"github.com/mohsenabedy91/Sikabiz/internal/core/service/userservice"
 	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"github.com/mohsenabedy91/Sikabiz/pkg/serviceerror"
 	"os"
 	"path"
 	"runtime"
@@ -30,6 +33,14 @@ func main() {
 	conf := configProvider.GetConfig()
 	log := logger.NewLogger("User Importer Consumer", conf.Log)
 
+	// the importer only produces, the memory driver would drop every user when it exits
+	if conf.Queue.Driver == "memory" {
+		log.Fatal(logger.Queue, logger.Startup, "QUEUE_DRIVER=memory is not supported by the importer, use rabbitmq or postgres", nil)
+	}
+
+	shutdownTracing := setup.InitializeTracing(context.Background(), log, conf, "user-importer-service")
+	defer shutdownTracing()
+
 	_, filename, _, _ := runtime.Caller(0)
 	sourceURL := path.Join(path.Dir(filename), "/../../users_data.json")
 
@@ -57,22 +68,46 @@ func main() {
 	if queueErr != nil {
 		return
 	}
-	defer queue.Driver.Close()
+	defer closeQueue(queue, log)
 
-	ctx := context.Background()
+	// every message published by this run carries the same correlation id
+	ctx := event.WithCorrelationID(context.Background(), fmt.Sprintf("user-import-%s", timestampBackupFile))
+	// the audit log records the imported users as created by the importer
+	ctx = domain.WithActor(ctx, domain.SystemActor("userimporterservice"))
 	db, databaseErr := setup.InitializeDatabase(ctx, log, conf)
 	if databaseErr != nil {
 		log.Fatal(logger.Database, logger.Startup, databaseErr.Error(), nil)
 		return
 	}
 
-	userService := userservice.New(log)
-	saveUserEvent := event.NewSaveUser(queue, log, db, userService)
+	setup.InitializeScheduler(log, conf, queue, db)
+	retryAt, retryAtErr := nextOffPeak(conf.Queue.OffPeakAt, time.Now())
+	if retryAtErr != nil {
+		log.Fatal(logger.Queue, logger.Startup, retryAtErr.Error(), nil)
+		return
+	}
 
+	userService := userservice.New(log, event.NewUserEvents(queue, log))
 	uowFactory := func() port.UserUnitOfWork {
 		return userrepository.NewUnitOfWork(log, db)
 	}
 
+	registry, registryErr := event.NewDefaultRegistry(event.Dependencies{
+		Queue:       queue,
+		Log:         log,
+		UnitOfWork:  uowFactory,
+		UserService: userService,
+	})
+	if registryErr != nil {
+		log.Fatal(logger.Queue, logger.Startup, registryErr.Error(), nil)
+		return
+	}
+	saveUserEvent, publisherErr := registry.Publisher(event.SaveUserName)
+	if publisherErr != nil {
+		log.Fatal(logger.Queue, logger.Startup, publisherErr.Error(), nil)
+		return
+	}
+
 	for _, user := range users {
 		select {
 		case semaphore <- struct{}{}:
@@ -84,55 +119,109 @@ func main() {
 				}()
 
 				uow := uowFactory()
-				if txErr := uow.BeginTx(ctx); txErr != nil {
-					handleFailedPublish(u, saveUserEvent, log)
-					return
-				}
-
-				if createErr := userService.Create(uow, &user); createErr != nil {
-					if rollbackErr := uow.Rollback(); rollbackErr != nil {
-						handleFailedPublish(u, saveUserEvent, log)
-						return
+				if txErr := uow.WithTransaction(ctx, port.TxOptions{}, func(ctx context.Context) error {
+					return userService.Create(ctx, uow, &u)
+				}); txErr != nil {
+					if !rejected(txErr) {
+						handleFailedPublish(ctx, u, saveUserEvent, retryAt, log)
 					}
 					return
 				}
-
-				if commitErr := uow.Commit(); commitErr != nil {
-					handleFailedPublish(u, saveUserEvent, log)
-					return
-				}
 				log.Info(logger.Database, logger.DatabaseInsert, "The user has been inserted successfully!", nil)
 			}(user)
 		default:
-			handleFailedPublish(user, saveUserEvent, log)
+			handleFailedPublish(ctx, user, saveUserEvent, retryAt, log)
 		}
 	}
 
 	wg.Wait()
 }
 
+// rejected tells a user Create refused, an invalid or duplicate user fails the same way again, so it is dropped,
+// the server errors and the conflicts which outlived the retries go to the queue
+func rejected(err error) bool {
+	var serviceErr serviceerror.Error
+	if !errors.As(err, &serviceErr) {
+		return false
+	}
+
+	switch serviceErr.GetErrorMessage() {
+	case serviceerror.ServerError, serviceerror.TransactionConflict:
+		return false
+	}
+
+	return true
+}
+
 func retry(attempts int, delay time.Duration, fn func() error) error {
+	var err error
 	for i := 0; i < attempts; i++ {
-		if err := fn(); err == nil {
+		if err = fn(); err == nil {
 			return nil
 		}
+
+		// an unroutable message is returned on every attempt, so it goes straight to the backup
+		var publishErr *messagebroker.PublishError
+		if errors.As(err, &publishErr) && !publishErr.Retryable() {
+			return err
+		}
 		time.Sleep(delay)
 	}
-	return fmt.Errorf("failed after %d attempts", attempts)
+	return fmt.Errorf("failed after %d attempts: %w", attempts, err)
 }
 
-func handleFailedPublish(user domain.User, saveUserEvent *event.SaveUser, log logger.Logger) {
+// handleFailedPublish a user which can not be published now is scheduled for the off-peak hours,
+// the backup file is the last resort
+func handleFailedPublish(ctx context.Context, user domain.User, saveUserEvent port.Event, retryAt time.Time, log logger.Logger) {
 	if err := retry(maxRetries, retryDelay, func() error {
-		return saveUserEvent.Publish(user)
+		return saveUserEvent.Publish(ctx, user)
 	}); err != nil {
 		log.Error(logger.Queue, logger.RabbitMQPublish, fmt.Sprintf("Failed to publish user: %v. Error: %v", user.ID, err), nil)
 
+		if !retryAt.IsZero() {
+			_, scheduleErr := saveUserEvent.PublishAt(ctx, user, retryAt)
+			if scheduleErr == nil {
+				return
+			}
+			log.Error(logger.Queue, logger.Scheduler, fmt.Sprintf("Failed to schedule user: %v. Error: %v", user.ID, scheduleErr), nil)
+		}
+
 		if backupErr := saveFailedDataToBackup(user); backupErr != nil {
 			log.Error(logger.Internal, logger.File, fmt.Sprintf("Failed to save user to backup: %v. Error: %v", user.ID, backupErr), nil)
 		}
 	}
 }
 
+// closeQueue the users the driver accepted but could not publish before it closed go to the backup file,
+// the other messages are only logged
+func closeQueue(queue *messagebroker.Queue, log logger.Logger) {
+	buffered, ok := queue.Driver.(port.BufferedDriver)
+	if !ok {
+		queue.Driver.Close()
+		return
+	}
+
+	for _, message := range buffered.CloseBuffered() {
+		extra := map[logger.ExtraKey]interface{}{
+			logger.QueueName: message.Queue,
+			logger.MessageID: message.Envelope.ID,
+		}
+		if message.Queue != event.SaveUserName {
+			log.Error(logger.Queue, logger.RabbitMQBuffer, "Message is not published before close", extra)
+			continue
+		}
+
+		var user domain.User
+		if err := json.Unmarshal(message.Envelope.Payload, &user); err != nil {
+			log.Error(logger.Queue, logger.RabbitMQBuffer, fmt.Sprintf("Error decode buffered user: %v", err), extra)
+			continue
+		}
+		if err := saveFailedDataToBackup(user); err != nil {
+			log.Error(logger.Internal, logger.File, fmt.Sprintf("Failed to save user to backup: %v. Error: %v", user.ID, err), extra)
+		}
+	}
+}
+
 var fileMutex sync.Mutex
 
 func saveFailedDataToBackup(user domain.User) error {
@@ -158,3 +247,22 @@ func saveFailedDataToBackup(user domain.User) error {
 	}
 	return nil
 }
+
+// nextOffPeak returns the next occurrence of the HH:MM time after now, the zero time when at is empty
+func nextOffPeak(at string, now time.Time) (time.Time, error) {
+	if at == "" {
+		return time.Time{}, nil
+	}
+
+	clock, err := time.Parse("15:04", at)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid QUEUE_OFF_PEAK_AT %q: %w", at, err)
+	}
+
+	next := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+
+	return next, nil
+}
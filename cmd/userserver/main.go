@@ -11,7 +11,9 @@ import (
 	"github.com/mohsenabedy91/Sikabiz/internal/adaper/storage/postgres"
 	"github.com/mohsenabedy91/Sikabiz/internal/adaper/storage/postgres/userrepository"
 	"github.com/mohsenabedy91/Sikabiz/internal/core/config"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/event"
 	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/service/addressservice"
 	"github.com/mohsenabedy91/Sikabiz/internal/core/service/userservice"
 	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
 	"github.com/mohsenabedy91/Sikabiz/pkg/translation"
@@ -32,6 +34,8 @@ func main() {
 	log := logger.NewLogger("UserManagement", conf.Log)
 
 	ctx := context.Background()
+	shutdownTracing := setup.InitializeTracing(ctx, log, conf, conf.App.Name)
+	defer shutdownTracing()
 	defer func() {
 		if err := postgres.Close(); err != nil {
 			log.Fatal(logger.Database, logger.Startup, err.Error(), nil)
@@ -42,16 +46,32 @@ func main() {
 		log.Fatal(logger.Database, logger.Startup, err.Error(), nil)
 		return
 	}
+	replicas := userrepository.NewReplicas(log, postgres.Replicas(), conf.DB.ReplicaMaxLag)
+	cursors, err := userrepository.NewCursorSealer(conf.App.CursorSecret)
+	if err != nil {
+		log.Fatal(logger.Internal, logger.Startup, err.Error(), nil)
+		return
+	}
 	uowFactory := func() port.UserUnitOfWork {
-		return userrepository.NewUnitOfWork(log, postgresDB)
+		return userrepository.NewUnitOfWorkWithReplicas(log, postgresDB, replicas, cursors)
 	}
 
 	trans := translation.NewTranslation(conf.App)
 	trans.GetLocalizer(conf.App.Locale)
 
-	userService := userservice.New(log)
+	queue, err := setup.InitializeQueue(log, conf)
+	if err != nil {
+		return
+	}
+	defer queue.Driver.Close()
+	// the server only records the events it fails to publish, the consumer runs the scheduler which retries them
+	setup.InitializeScheduler(log, conf, queue, postgresDB)
+
+	userEvents := event.NewUserEvents(queue, log)
+	userService := userservice.New(log, userEvents)
+	addressService := addressservice.New(log, userEvents)
 
-	httpServer := startHTTPServer(log, conf, trans, userService, uowFactory)
+	httpServer := startHTTPServer(log, conf, trans, userService, addressService, uowFactory)
 
 	signalCh := make(chan os.Signal, 1)
 	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
@@ -67,10 +87,12 @@ func startHTTPServer(
 	conf config.Config,
 	trans translation.Translator,
 	userService *userservice.UserService,
+	addressService *addressservice.AddressService,
 	uowFactory func() port.UserUnitOfWork,
 ) *http.Server {
 
 	userHandler := handler.NewUserHandler(trans, userService, uowFactory)
+	addressHandler := handler.NewAddressHandler(trans, addressService, uowFactory)
 
 	// Init router
 	router, err := routes.NewRouter(log, conf, trans)
@@ -80,6 +102,7 @@ func startHTTPServer(
 	}
 
 	router = router.NewUserRouter(*userHandler)
+	router = router.NewAddressRouter(*addressHandler)
 
 	listenAddr := fmt.Sprintf("%s:%s", conf.App.HTTPUrl, conf.App.HTTPPort)
 	httpServer := &http.Server{
@@ -0,0 +1,250 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/mohsenabedy91/Sikabiz/internal/adaper/http/presenter"
+	"github.com/mohsenabedy91/Sikabiz/internal/adaper/http/request"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
+	"github.com/mohsenabedy91/Sikabiz/pkg/translation"
+	"net/http"
+)
+
+type AddressHandler struct {
+	trans          translation.Translator
+	addressService port.AddressService
+	uowFactory     func() port.UserUnitOfWork
+}
+
+func NewAddressHandler(
+	trans translation.Translator,
+	addressService port.AddressService,
+	uowFactory func() port.UserUnitOfWork,
+) *AddressHandler {
+	return &AddressHandler{
+		trans:          trans,
+		addressService: addressService,
+		uowFactory:     uowFactory,
+	}
+}
+
+// List godoc
+// @x-kong {"service": "user-management-http-service"}
+// @Security AuthBearer[READ_USER]
+// @Summary List Addresses
+// @Description List the addresses of the user
+// @Tags Address
+// @Produce json
+// @Param language path string true "language 2 abbreviations" default(en)
+// @Param userID path string true "user id should be uuid"
+// @Success 200 {object} presenter.Response{data=[]presenter.Address} "Successful response"
+// @Failure 401 {object} presenter.Error "Unauthorized"
+// @Failure 404 {object} presenter.Error "Not found"
+// @Failure 422 {object} presenter.Response{validationErrors=[]presenter.ValidationError} "Validation error"
+// @Failure 500 {object} presenter.Error "Internal server error"
+// @ID get_language_v1_users_userID_addresses
+// @Router /{language}/v1/users/{userID}/addresses [get]
+func (r AddressHandler) List(ctx *gin.Context) {
+	var userReq request.UserUUIDUri
+	if err := ctx.ShouldBindUri(&userReq); err != nil {
+		presenter.NewResponse(ctx, r.trans).Validation(err).Echo(http.StatusUnprocessableEntity)
+		return
+	}
+
+	var addresses []*domain.Address
+	if ok := readTransaction(ctx, r.trans, r.uowFactory, func(uow port.UserUnitOfWork) (err error) {
+		addresses, err = r.addressService.List(ctx.Request.Context(), uow, userReq.UUIDStr)
+		return err
+	}); !ok {
+		return
+	}
+
+	presenter.NewResponse(ctx, r.trans).Payload(
+		presenter.ToAddressCollection(addresses),
+	).Echo()
+}
+
+// Get godoc
+// @x-kong {"service": "user-management-http-service"}
+// @Security AuthBearer[READ_USER]
+// @Summary Get Address
+// @Description Get an address of the user by UUID
+// @Tags Address
+// @Produce json
+// @Param language path string true "language 2 abbreviations" default(en)
+// @Param userID path string true "user id should be uuid"
+// @Param addressID path string true "address id should be uuid"
+// @Success 200 {object} presenter.Response{data=presenter.Address} "Successful response"
+// @Header 200 {string} ETag "version of the address, the If-Match of its changes"
+// @Failure 401 {object} presenter.Error "Unauthorized"
+// @Failure 404 {object} presenter.Error "Not found"
+// @Failure 422 {object} presenter.Response{validationErrors=[]presenter.ValidationError} "Validation error"
+// @Failure 500 {object} presenter.Error "Internal server error"
+// @ID get_language_v1_users_userID_addresses_addressID
+// @Router /{language}/v1/users/{userID}/addresses/{addressID} [get]
+func (r AddressHandler) Get(ctx *gin.Context) {
+	var addressReq request.AddressUUIDUri
+	if err := ctx.ShouldBindUri(&addressReq); err != nil {
+		presenter.NewResponse(ctx, r.trans).Validation(err).Echo(http.StatusUnprocessableEntity)
+		return
+	}
+
+	var address *domain.Address
+	if ok := readTransaction(ctx, r.trans, r.uowFactory, func(uow port.UserUnitOfWork) (err error) {
+		address, err = r.addressService.GetByID(ctx.Request.Context(), uow, addressReq.UserUUIDStr, addressReq.UUIDStr)
+		return err
+	}); !ok {
+		return
+	}
+
+	setETag(ctx, address.Version)
+	presenter.NewResponse(ctx, r.trans).Payload(
+		presenter.ToAddressResource(address),
+	).Echo()
+}
+
+// Create godoc
+// @x-kong {"service": "user-management-http-service"}
+// @Security AuthBearer[UPDATE_USER]
+// @Summary Add Address
+// @Description Add an address to the user
+// @Tags Address
+// @Accept json
+// @Produce json
+// @Param language path string true "language 2 abbreviations" default(en)
+// @Param userID path string true "user id should be uuid"
+// @Param request body request.Address true "Address body"
+// @Success 201 {object} presenter.Response{data=presenter.Address} "Successful response"
+// @Header 201 {string} ETag "version of the address, the If-Match of its changes"
+// @Failure 400 {object} presenter.Error "Failed response"
+// @Failure 401 {object} presenter.Error "Unauthorized"
+// @Failure 404 {object} presenter.Error "Not found"
+// @Failure 422 {object} presenter.Response{validationErrors=[]presenter.ValidationError} "Validation error"
+// @Failure 500 {object} presenter.Error "Internal server error"
+// @ID post_language_v1_users_userID_addresses
+// @Router /{language}/v1/users/{userID}/addresses [post]
+func (r AddressHandler) Create(ctx *gin.Context) {
+	var userReq request.UserUUIDUri
+	if err := ctx.ShouldBindUri(&userReq); err != nil {
+		presenter.NewResponse(ctx, r.trans).Validation(err).Echo(http.StatusUnprocessableEntity)
+		return
+	}
+
+	var req request.Address
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		presenter.NewResponse(ctx, r.trans).Validation(err).Echo(http.StatusUnprocessableEntity)
+		return
+	}
+
+	var address *domain.Address
+	if ok := transaction(ctx, r.trans, r.uowFactory, func(uow port.UserUnitOfWork) (err error) {
+		address, err = r.addressService.Create(ctx.Request.Context(), uow, userReq.UUIDStr, req.ToAddressDomain())
+		return err
+	}); !ok {
+		return
+	}
+
+	setETag(ctx, address.Version)
+	presenter.NewResponse(ctx, r.trans).Payload(
+		presenter.ToAddressResource(address),
+	).Echo(http.StatusCreated)
+}
+
+// Update godoc
+// @x-kong {"service": "user-management-http-service"}
+// @Security AuthBearer[UPDATE_USER]
+// @Summary Update Address
+// @Description Replace the fields of an address of the user
+// @Tags Address
+// @Accept json
+// @Produce json
+// @Param language path string true "language 2 abbreviations" default(en)
+// @Param userID path string true "user id should be uuid"
+// @Param addressID path string true "address id should be uuid"
+// @Param If-Match header string true "ETag of the address, * for any version"
+// @Param request body request.Address true "Address body"
+// @Success 200 {object} presenter.Response{data=presenter.Address} "Successful response"
+// @Header 200 {string} ETag "version of the address, the If-Match of its changes"
+// @Failure 400 {object} presenter.Error "Failed response"
+// @Failure 401 {object} presenter.Error "Unauthorized"
+// @Failure 404 {object} presenter.Error "Not found"
+// @Failure 409 {object} presenter.Error "Changed meanwhile"
+// @Failure 412 {object} presenter.Error "ETag does not match"
+// @Failure 428 {object} presenter.Error "If-Match required"
+// @Failure 422 {object} presenter.Response{validationErrors=[]presenter.ValidationError} "Validation error"
+// @Failure 500 {object} presenter.Error "Internal server error"
+// @ID put_language_v1_users_userID_addresses_addressID
+// @Router /{language}/v1/users/{userID}/addresses/{addressID} [put]
+func (r AddressHandler) Update(ctx *gin.Context) {
+	var addressReq request.AddressUUIDUri
+	if err := ctx.ShouldBindUri(&addressReq); err != nil {
+		presenter.NewResponse(ctx, r.trans).Validation(err).Echo(http.StatusUnprocessableEntity)
+		return
+	}
+
+	var req request.Address
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		presenter.NewResponse(ctx, r.trans).Validation(err).Echo(http.StatusUnprocessableEntity)
+		return
+	}
+
+	version, ok := ifMatch(ctx, r.trans)
+	if !ok {
+		return
+	}
+
+	var address *domain.Address
+	if ok := transaction(ctx, r.trans, r.uowFactory, func(uow port.UserUnitOfWork) (err error) {
+		address, err = r.addressService.Update(ctx.Request.Context(), uow, addressReq.UserUUIDStr, addressReq.UUIDStr, version, req.ToAddressDomain())
+		return err
+	}); !ok {
+		return
+	}
+
+	setETag(ctx, address.Version)
+	presenter.NewResponse(ctx, r.trans).Payload(
+		presenter.ToAddressResource(address),
+	).Echo()
+}
+
+// Delete godoc
+// @x-kong {"service": "user-management-http-service"}
+// @Security AuthBearer[UPDATE_USER]
+// @Summary Delete Address
+// @Description Soft delete an address of the user
+// @Tags Address
+// @Produce json
+// @Param language path string true "language 2 abbreviations" default(en)
+// @Param userID path string true "user id should be uuid"
+// @Param addressID path string true "address id should be uuid"
+// @Param If-Match header string true "ETag of the address, * for any version"
+// @Success 204 "Deleted"
+// @Failure 401 {object} presenter.Error "Unauthorized"
+// @Failure 404 {object} presenter.Error "Not found"
+// @Failure 409 {object} presenter.Error "Changed meanwhile"
+// @Failure 412 {object} presenter.Error "ETag does not match"
+// @Failure 428 {object} presenter.Error "If-Match required"
+// @Failure 422 {object} presenter.Response{validationErrors=[]presenter.ValidationError} "Validation error"
+// @Failure 500 {object} presenter.Error "Internal server error"
+// @ID delete_language_v1_users_userID_addresses_addressID
+// @Router /{language}/v1/users/{userID}/addresses/{addressID} [delete]
+func (r AddressHandler) Delete(ctx *gin.Context) {
+	var addressReq request.AddressUUIDUri
+	if err := ctx.ShouldBindUri(&addressReq); err != nil {
+		presenter.NewResponse(ctx, r.trans).Validation(err).Echo(http.StatusUnprocessableEntity)
+		return
+	}
+
+	version, ok := ifMatch(ctx, r.trans)
+	if !ok {
+		return
+	}
+
+	if ok := transaction(ctx, r.trans, r.uowFactory, func(uow port.UserUnitOfWork) error {
+		return r.addressService.Delete(ctx.Request.Context(), uow, addressReq.UserUUIDStr, addressReq.UUIDStr, version)
+	}); !ok {
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/mohsenabedy91/Sikabiz/internal/adaper/http/presenter"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/pkg/serviceerror"
+	"github.com/mohsenabedy91/Sikabiz/pkg/translation"
+	"strconv"
+	"strings"
+)
+
+// setETag the version of the resource is its entity tag
+func setETag(ctx *gin.Context, version int) {
+	ctx.Header("ETag", strconv.Quote(strconv.Itoa(version)))
+}
+
+// ifMatch returns the version the If-Match header expects, * expects any version, a request without
+// the header would overwrite the changes it has not seen, so it is refused with the error response written
+func ifMatch(ctx *gin.Context, trans translation.Translator) (int, bool) {
+	value := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if value == "" {
+		presenter.NewResponse(ctx, trans, StatusCodeMapping).Error(serviceerror.New(serviceerror.PreconditionRequired)).Echo()
+		return 0, false
+	}
+	if value == "*" {
+		return domain.AnyVersion, true
+	}
+
+	version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(value, "W/"), `"`))
+	if err != nil || version < 1 {
+		presenter.NewResponse(ctx, trans, StatusCodeMapping).Error(serviceerror.New(serviceerror.PreconditionFailed)).Echo()
+		return 0, false
+	}
+
+	return version, true
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/pkg/translation"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeTranslator struct {
+	translation.Translator
+}
+
+func (r fakeTranslator) Lang(key string, _ map[string]interface{}, _ *string) string {
+	return key
+}
+
+func TestIfMatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name    string
+		header  string
+		want    int
+		wantOK  bool
+		wantErr int
+	}{
+		{name: "missing", wantErr: http.StatusPreconditionRequired},
+		{name: "blank", header: "  ", wantErr: http.StatusPreconditionRequired},
+		{name: "any version", header: "*", want: domain.AnyVersion, wantOK: true},
+		{name: "strong", header: `"3"`, want: 3, wantOK: true},
+		{name: "weak", header: `W/"4"`, want: 4, wantOK: true},
+		{name: "unquoted", header: "5", want: 5, wantOK: true},
+		{name: "not a number", header: `"abc"`, wantErr: http.StatusPreconditionFailed},
+		{name: "zero", header: `"0"`, wantErr: http.StatusPreconditionFailed},
+		{name: "negative", header: `"-1"`, wantErr: http.StatusPreconditionFailed},
+		{name: "list", header: `"1", "2"`, wantErr: http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			ctx, _ := gin.CreateTestContext(recorder)
+			ctx.Request = httptest.NewRequest(http.MethodPut, "/users/1", nil)
+			if tt.header != "" {
+				ctx.Request.Header.Set("If-Match", tt.header)
+			}
+
+			version, ok := ifMatch(ctx, fakeTranslator{})
+			if ok != tt.wantOK || version != tt.want {
+				t.Fatalf("ifMatch() = %d, %v, want %d, %v", version, ok, tt.want, tt.wantOK)
+			}
+			if !tt.wantOK && (recorder.Code != tt.wantErr || !ctx.IsAborted()) {
+				t.Fatalf("status = %d, aborted %v, want %d", recorder.Code, ctx.IsAborted(), tt.wantErr)
+			}
+			if tt.wantOK && ctx.IsAborted() {
+				t.Fatal("a matching request was aborted")
+			}
+		})
+	}
+}
@@ -16,13 +16,19 @@ var StatusCodeMapping = map[serviceerror.ErrorMessage]int{
 	serviceerror.IsNotDeletable:     http.StatusForbidden,
 	serviceerror.NoRowsEffected:     http.StatusNotFound,
 	serviceerror.FailedSendEmail:    http.StatusInternalServerError,
+	// Concurrency
+	serviceerror.VersionConflict:      http.StatusConflict,
+	serviceerror.TransactionConflict:  http.StatusConflict,
+	serviceerror.PreconditionFailed:   http.StatusPreconditionFailed,
+	serviceerror.PreconditionRequired: http.StatusPreconditionRequired,
 	// User
-	serviceerror.UserIsBanned:      http.StatusForbidden,
-	serviceerror.UserInActive:      http.StatusForbidden,
-	serviceerror.UserUnVerified:    http.StatusForbidden,
-	serviceerror.EmailRegistered:   http.StatusConflict,
-	serviceerror.CredentialInvalid: http.StatusUnauthorized,
-	serviceerror.UserLogout:        http.StatusUnauthorized,
+	serviceerror.UserIsBanned:          http.StatusForbidden,
+	serviceerror.UserInActive:          http.StatusForbidden,
+	serviceerror.UserUnVerified:        http.StatusForbidden,
+	serviceerror.EmailRegistered:       http.StatusConflict,
+	serviceerror.PhoneNumberRegistered: http.StatusConflict,
+	serviceerror.CredentialInvalid:     http.StatusUnauthorized,
+	serviceerror.UserLogout:            http.StatusUnauthorized,
 	// OTP
 	serviceerror.InvalidOTP: http.StatusBadRequest,
 	serviceerror.OTPExpired: http.StatusUnauthorized,
@@ -31,6 +37,7 @@ var StatusCodeMapping = map[serviceerror.ErrorMessage]int{
 	serviceerror.TokenExpired: http.StatusUnauthorized,
 	// Validation
 	serviceerror.InvalidRequestBody: http.StatusBadRequest,
+	serviceerror.InvalidCursor:      http.StatusBadRequest,
 	// Role
 	serviceerror.RoleExisted: http.StatusConflict,
 	// TOTP
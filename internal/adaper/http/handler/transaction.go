@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/mohsenabedy91/Sikabiz/internal/adaper/http/presenter"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
+	"github.com/mohsenabedy91/Sikabiz/pkg/translation"
+)
+
+// transaction runs fn in a unit of work, on failure it writes the error response and returns false
+func transaction(
+	ctx *gin.Context,
+	trans translation.Translator,
+	uowFactory func() port.UserUnitOfWork,
+	fn func(uow port.UserUnitOfWork) error,
+) bool {
+	return run(ctx, trans, uowFactory, port.TxOptions{}, fn)
+}
+
+// readTransaction is transaction for the reads, the unit of work is read only and may run on a replica
+func readTransaction(
+	ctx *gin.Context,
+	trans translation.Translator,
+	uowFactory func() port.UserUnitOfWork,
+	fn func(uow port.UserUnitOfWork) error,
+) bool {
+	return run(ctx, trans, uowFactory, port.TxOptions{ReadOnly: true}, fn)
+}
+
+func run(
+	ctx *gin.Context,
+	trans translation.Translator,
+	uowFactory func() port.UserUnitOfWork,
+	opts port.TxOptions,
+	fn func(uow port.UserUnitOfWork) error,
+) bool {
+	uow := uowFactory()
+	if err := uow.WithTransaction(ctx.Request.Context(), opts, func(context.Context) error {
+		return fn(uow)
+	}); err != nil {
+		presenter.NewResponse(ctx, trans, StatusCodeMapping).Error(err).Echo()
+		return false
+	}
+
+	return true
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/mohsenabedy91/Sikabiz/internal/adaper/http/presenter"
 	"github.com/mohsenabedy91/Sikabiz/internal/adaper/http/request"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
 	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
 	"github.com/mohsenabedy91/Sikabiz/pkg/translation"
 	"net/http"
@@ -38,6 +39,7 @@ func NewUserHandler(
 // @Param language path string true "language 2 abbreviations" default(en)
 // @Param userID path string true "user id should be uuid"
 // @Success 200 {object} presenter.Response{data=presenter.User} "Successful response"
+// @Header 200 {string} ETag "version of the user, the If-Match of its changes"
 // @Failure 400 {object} presenter.Error "Failed response"
 // @Failure 401 {object} presenter.Error "Unauthorized"
 // @Failure 422 {object} presenter.Response{validationErrors=[]presenter.ValidationError} "Validation error"
@@ -51,28 +53,376 @@ func (r UserHandler) Get(ctx *gin.Context) {
 		return
 	}
 
-	uowFactory := r.uowFactory()
-	if err := uowFactory.BeginTx(ctx); err != nil {
-		presenter.NewResponse(ctx, r.trans, StatusCodeMapping).Error(err).Echo()
+	var user *domain.User
+	if ok := readTransaction(ctx, r.trans, r.uowFactory, func(uow port.UserUnitOfWork) (err error) {
+		user, err = r.userService.GetByID(ctx.Request.Context(), uow, userReq.UUIDStr)
+		return err
+	}); !ok {
 		return
 	}
 
-	user, err := r.userService.GetByID(uowFactory, userReq.UUIDStr)
-	if err != nil {
-		if rErr := uowFactory.Rollback(); rErr != nil {
-			presenter.NewResponse(ctx, r.trans, StatusCodeMapping).Error(rErr).Echo()
-			return
-		}
-		presenter.NewResponse(ctx, r.trans, StatusCodeMapping).Error(err).Echo()
+	setETag(ctx, user.Version)
+	presenter.NewResponse(ctx, r.trans).Payload(
+		presenter.ToUserResource(user),
+	).Echo()
+}
+
+// List godoc
+// @x-kong {"service": "user-management-http-service"}
+// @Security AuthBearer[READ_USER]
+// @Summary List Users
+// @Description List the users page by page, next_cursor of the meta requests the next page
+// @Tags User
+// @Produce json
+// @Param language path string true "language 2 abbreviations" default(en)
+// @Param request query request.ListUsers false "Filters, sort and page"
+// @Success 200 {object} presenter.Response{data=[]presenter.User,meta=presenter.Pagination} "Successful response"
+// @Failure 400 {object} presenter.Error "Invalid cursor"
+// @Failure 401 {object} presenter.Error "Unauthorized"
+// @Failure 422 {object} presenter.Response{validationErrors=[]presenter.ValidationError} "Validation error"
+// @Failure 500 {object} presenter.Error "Internal server error"
+// @ID get_language_v1_users
+// @Router /{language}/v1/users [get]
+func (r UserHandler) List(ctx *gin.Context) {
+	var req request.ListUsers
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		presenter.NewResponse(ctx, r.trans).Validation(err).Echo(http.StatusUnprocessableEntity)
+		return
+	}
+
+	var page *domain.UserPage
+	if ok := readTransaction(ctx, r.trans, r.uowFactory, func(uow port.UserUnitOfWork) (err error) {
+		page, err = r.userService.List(ctx.Request.Context(), uow, req.ToUserListQuery())
+		return err
+	}); !ok {
+		return
+	}
+
+	presenter.NewResponse(ctx, r.trans).Payload(
+		presenter.ToUserCollection(page.Users),
+	).Meta(
+		presenter.ToPagination(page),
+	).Echo()
+}
+
+// Search godoc
+// @x-kong {"service": "user-management-http-service"}
+// @Security AuthBearer[READ_USER]
+// @Summary Search Users
+// @Description Fuzzy search over the names, emails and addresses, the best matches come first with the matches highlighted
+// @Tags User
+// @Produce json
+// @Param language path string true "language 2 abbreviations" default(en)
+// @Param request query request.SearchUsers true "Search query"
+// @Success 200 {object} presenter.Response{data=[]presenter.UserSearchResult} "Successful response"
+// @Failure 401 {object} presenter.Error "Unauthorized"
+// @Failure 422 {object} presenter.Response{validationErrors=[]presenter.ValidationError} "Validation error"
+// @Failure 500 {object} presenter.Error "Internal server error"
+// @ID get_language_v1_users_search
+// @Router /{language}/v1/users/search [get]
+func (r UserHandler) Search(ctx *gin.Context) {
+	var req request.SearchUsers
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		presenter.NewResponse(ctx, r.trans).Validation(err).Echo(http.StatusUnprocessableEntity)
+		return
+	}
+
+	var results []*domain.UserSearchResult
+	if ok := readTransaction(ctx, r.trans, r.uowFactory, func(uow port.UserUnitOfWork) (err error) {
+		results, err = r.userService.Search(ctx.Request.Context(), uow, req.Query, req.Limit)
+		return err
+	}); !ok {
+		return
+	}
+
+	presenter.NewResponse(ctx, r.trans).Payload(
+		presenter.ToUserSearchCollection(results),
+	).Echo()
+}
+
+// Update godoc
+// @x-kong {"service": "user-management-http-service"}
+// @Security AuthBearer[UPDATE_USER]
+// @Summary Update User
+// @Description Replace the fields of the user, the addresses are not changed
+// @Tags User
+// @Accept json
+// @Produce json
+// @Param language path string true "language 2 abbreviations" default(en)
+// @Param userID path string true "user id should be uuid"
+// @Param If-Match header string true "ETag of the user, * for any version"
+// @Param request body request.UpdateUser true "Update user body"
+// @Success 200 {object} presenter.Response{data=presenter.User} "Successful response"
+// @Header 200 {string} ETag "version of the user, the If-Match of its changes"
+// @Failure 400 {object} presenter.Error "Failed response"
+// @Failure 401 {object} presenter.Error "Unauthorized"
+// @Failure 404 {object} presenter.Error "Not found"
+// @Failure 409 {object} presenter.Error "Email registered or changed meanwhile"
+// @Failure 412 {object} presenter.Error "ETag does not match"
+// @Failure 428 {object} presenter.Error "If-Match required"
+// @Failure 422 {object} presenter.Response{validationErrors=[]presenter.ValidationError} "Validation error"
+// @Failure 500 {object} presenter.Error "Internal server error"
+// @ID put_language_v1_users_userID
+// @Router /{language}/v1/users/{userID} [put]
+func (r UserHandler) Update(ctx *gin.Context) {
+	var userReq request.UserUUIDUri
+	if err := ctx.ShouldBindUri(&userReq); err != nil {
+		presenter.NewResponse(ctx, r.trans).Validation(err).Echo(http.StatusUnprocessableEntity)
+		return
+	}
+
+	var req request.UpdateUser
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		presenter.NewResponse(ctx, r.trans).Validation(err).Echo(http.StatusUnprocessableEntity)
+		return
+	}
+
+	version, ok := ifMatch(ctx, r.trans)
+	if !ok {
+		return
+	}
+
+	var user *domain.User
+	if ok := transaction(ctx, r.trans, r.uowFactory, func(uow port.UserUnitOfWork) (err error) {
+		user, err = r.userService.Update(ctx.Request.Context(), uow, userReq.UUIDStr, version, req.ToUserDomain())
+		return err
+	}); !ok {
+		return
+	}
+
+	setETag(ctx, user.Version)
+	presenter.NewResponse(ctx, r.trans).Payload(
+		presenter.ToUserResource(user),
+	).Echo()
+}
+
+// Patch godoc
+// @x-kong {"service": "user-management-http-service"}
+// @Security AuthBearer[UPDATE_USER]
+// @Summary Partial Update User
+// @Description Change only the fields present in the body
+// @Tags User
+// @Accept json
+// @Produce json
+// @Param language path string true "language 2 abbreviations" default(en)
+// @Param userID path string true "user id should be uuid"
+// @Param If-Match header string true "ETag of the user, * for any version"
+// @Param request body request.PatchUser true "Patch user body"
+// @Success 200 {object} presenter.Response{data=presenter.User} "Successful response"
+// @Header 200 {string} ETag "version of the user, the If-Match of its changes"
+// @Failure 400 {object} presenter.Error "Failed response"
+// @Failure 401 {object} presenter.Error "Unauthorized"
+// @Failure 404 {object} presenter.Error "Not found"
+// @Failure 409 {object} presenter.Error "Email registered or changed meanwhile"
+// @Failure 412 {object} presenter.Error "ETag does not match"
+// @Failure 428 {object} presenter.Error "If-Match required"
+// @Failure 422 {object} presenter.Response{validationErrors=[]presenter.ValidationError} "Validation error"
+// @Failure 500 {object} presenter.Error "Internal server error"
+// @ID patch_language_v1_users_userID
+// @Router /{language}/v1/users/{userID} [patch]
+func (r UserHandler) Patch(ctx *gin.Context) {
+	var userReq request.UserUUIDUri
+	if err := ctx.ShouldBindUri(&userReq); err != nil {
+		presenter.NewResponse(ctx, r.trans).Validation(err).Echo(http.StatusUnprocessableEntity)
+		return
+	}
+
+	var req request.PatchUser
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		presenter.NewResponse(ctx, r.trans).Validation(err).Echo(http.StatusUnprocessableEntity)
+		return
+	}
+
+	version, ok := ifMatch(ctx, r.trans)
+	if !ok {
 		return
 	}
 
-	if commitErr := uowFactory.Commit(); commitErr != nil {
-		presenter.NewResponse(ctx, r.trans, StatusCodeMapping).Error(commitErr).Echo()
+	var user *domain.User
+	if ok := transaction(ctx, r.trans, r.uowFactory, func(uow port.UserUnitOfWork) (err error) {
+		user, err = r.userService.PartialUpdate(ctx.Request.Context(), uow, userReq.UUIDStr, version, req.ToUserPatch())
+		return err
+	}); !ok {
 		return
 	}
 
+	setETag(ctx, user.Version)
 	presenter.NewResponse(ctx, r.trans).Payload(
 		presenter.ToUserResource(user),
 	).Echo()
 }
+
+// Delete godoc
+// @x-kong {"service": "user-management-http-service"}
+// @Security AuthBearer[DELETE_USER]
+// @Summary Delete User
+// @Description Soft delete the user, it can be restored
+// @Tags User
+// @Produce json
+// @Param language path string true "language 2 abbreviations" default(en)
+// @Param userID path string true "user id should be uuid"
+// @Param If-Match header string true "ETag of the user, * for any version"
+// @Success 204 "Deleted"
+// @Failure 401 {object} presenter.Error "Unauthorized"
+// @Failure 404 {object} presenter.Error "Not found"
+// @Failure 409 {object} presenter.Error "Changed meanwhile"
+// @Failure 412 {object} presenter.Error "ETag does not match"
+// @Failure 428 {object} presenter.Error "If-Match required"
+// @Failure 422 {object} presenter.Response{validationErrors=[]presenter.ValidationError} "Validation error"
+// @Failure 500 {object} presenter.Error "Internal server error"
+// @ID delete_language_v1_users_userID
+// @Router /{language}/v1/users/{userID} [delete]
+func (r UserHandler) Delete(ctx *gin.Context) {
+	var userReq request.UserUUIDUri
+	if err := ctx.ShouldBindUri(&userReq); err != nil {
+		presenter.NewResponse(ctx, r.trans).Validation(err).Echo(http.StatusUnprocessableEntity)
+		return
+	}
+
+	version, ok := ifMatch(ctx, r.trans)
+	if !ok {
+		return
+	}
+
+	if ok := transaction(ctx, r.trans, r.uowFactory, func(uow port.UserUnitOfWork) error {
+		return r.userService.SoftDelete(ctx.Request.Context(), uow, userReq.UUIDStr, version)
+	}); !ok {
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// Restore godoc
+// @x-kong {"service": "user-management-http-service"}
+// @Security AuthBearer[DELETE_USER]
+// @Summary Restore User
+// @Description Restore a soft deleted user
+// @Tags User
+// @Produce json
+// @Param language path string true "language 2 abbreviations" default(en)
+// @Param userID path string true "user id should be uuid"
+// @Param If-Match header string true "ETag of the user, * for any version"
+// @Success 200 {object} presenter.Response{data=presenter.User} "Successful response"
+// @Header 200 {string} ETag "version of the user, the If-Match of its changes"
+// @Failure 401 {object} presenter.Error "Unauthorized"
+// @Failure 404 {object} presenter.Error "Not found"
+// @Failure 409 {object} presenter.Error "Changed meanwhile"
+// @Failure 412 {object} presenter.Error "ETag does not match"
+// @Failure 428 {object} presenter.Error "If-Match required"
+// @Failure 422 {object} presenter.Response{validationErrors=[]presenter.ValidationError} "Validation error"
+// @Failure 500 {object} presenter.Error "Internal server error"
+// @ID post_language_v1_users_userID_restore
+// @Router /{language}/v1/users/{userID}/restore [post]
+func (r UserHandler) Restore(ctx *gin.Context) {
+	var userReq request.UserUUIDUri
+	if err := ctx.ShouldBindUri(&userReq); err != nil {
+		presenter.NewResponse(ctx, r.trans).Validation(err).Echo(http.StatusUnprocessableEntity)
+		return
+	}
+
+	version, ok := ifMatch(ctx, r.trans)
+	if !ok {
+		return
+	}
+
+	var user *domain.User
+	if ok := transaction(ctx, r.trans, r.uowFactory, func(uow port.UserUnitOfWork) (err error) {
+		user, err = r.userService.Restore(ctx.Request.Context(), uow, userReq.UUIDStr, version)
+		return err
+	}); !ok {
+		return
+	}
+
+	setETag(ctx, user.Version)
+	presenter.NewResponse(ctx, r.trans).Payload(
+		presenter.ToUserResource(user),
+	).Echo()
+}
+
+// ForceDelete godoc
+// @x-kong {"service": "user-management-http-service"}
+// @Security AuthBearer[FORCE_DELETE_USER]
+// @Summary Permanently Delete User
+// @Description Remove the user and its addresses, a soft deleted user is removed as well
+// @Tags User
+// @Produce json
+// @Param language path string true "language 2 abbreviations" default(en)
+// @Param userID path string true "user id should be uuid"
+// @Param If-Match header string true "ETag of the user, * for any version"
+// @Success 204 "Deleted"
+// @Failure 401 {object} presenter.Error "Unauthorized"
+// @Failure 403 {object} presenter.Error "Not deletable"
+// @Failure 404 {object} presenter.Error "Not found"
+// @Failure 409 {object} presenter.Error "Changed meanwhile"
+// @Failure 412 {object} presenter.Error "ETag does not match"
+// @Failure 428 {object} presenter.Error "If-Match required"
+// @Failure 422 {object} presenter.Response{validationErrors=[]presenter.ValidationError} "Validation error"
+// @Failure 500 {object} presenter.Error "Internal server error"
+// @ID delete_language_v1_users_userID_permanent
+// @Router /{language}/v1/users/{userID}/permanent [delete]
+func (r UserHandler) ForceDelete(ctx *gin.Context) {
+	var userReq request.UserUUIDUri
+	if err := ctx.ShouldBindUri(&userReq); err != nil {
+		presenter.NewResponse(ctx, r.trans).Validation(err).Echo(http.StatusUnprocessableEntity)
+		return
+	}
+
+	version, ok := ifMatch(ctx, r.trans)
+	if !ok {
+		return
+	}
+
+	if ok := transaction(ctx, r.trans, r.uowFactory, func(uow port.UserUnitOfWork) error {
+		return r.userService.HardDelete(ctx.Request.Context(), uow, userReq.UUIDStr, version)
+	}); !ok {
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// History godoc
+// @x-kong {"service": "user-management-http-service"}
+// @Security AuthBearer[READ_USER]
+// @Summary User History
+// @Description The changes of the user and its addresses newest first, who made them and the changed fields, next_cursor of the meta requests the next page
+// @Tags User
+// @Produce json
+// @Param language path string true "language 2 abbreviations" default(en)
+// @Param userID path string true "user id should be uuid"
+// @Param request query request.UserHistory false "Page"
+// @Success 200 {object} presenter.Response{data=[]presenter.AuditLog,meta=presenter.Pagination} "Successful response"
+// @Failure 400 {object} presenter.Error "Invalid cursor"
+// @Failure 401 {object} presenter.Error "Unauthorized"
+// @Failure 422 {object} presenter.Response{validationErrors=[]presenter.ValidationError} "Validation error"
+// @Failure 500 {object} presenter.Error "Internal server error"
+// @ID get_language_v1_users_userID_history
+// @Router /{language}/v1/users/{userID}/history [get]
+func (r UserHandler) History(ctx *gin.Context) {
+	var userReq request.UserUUIDUri
+	if err := ctx.ShouldBindUri(&userReq); err != nil {
+		presenter.NewResponse(ctx, r.trans).Validation(err).Echo(http.StatusUnprocessableEntity)
+		return
+	}
+
+	var req request.UserHistory
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		presenter.NewResponse(ctx, r.trans).Validation(err).Echo(http.StatusUnprocessableEntity)
+		return
+	}
+
+	var page *domain.AuditLogPage
+	if ok := readTransaction(ctx, r.trans, r.uowFactory, func(uow port.UserUnitOfWork) (err error) {
+		page, err = r.userService.History(ctx.Request.Context(), uow, userReq.UUIDStr, req.ToAuditLogQuery())
+		return err
+	}); !ok {
+		return
+	}
+
+	presenter.NewResponse(ctx, r.trans).Payload(
+		presenter.ToAuditLogCollection(page.Logs),
+	).Meta(
+		presenter.ToAuditLogPagination(page),
+	).Echo()
+}
@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/mohsenabedy91/Sikabiz/internal/adaper/http/presenter"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/pkg/serviceerror"
+	"github.com/mohsenabedy91/Sikabiz/pkg/translation"
+	"net/http"
+)
+
+const (
+	// ActorHeader the gateway sets it to the uuid of the user it authenticated the request for
+	ActorHeader = "X-User-ID"
+	// ActorSignatureHeader the gateway signs the ActorHeader with the shared secret, the hex of its HMAC-SHA256
+	ActorSignatureHeader = "X-User-Signature"
+)
+
+// Actor puts the user the gateway authenticated into the context of the request, the changes of the request are
+// recorded as made by it, a header which is not signed with the gateway secret is ignored, so a client cannot
+// act as another user, a request which changes anything is refused without an authenticated user
+func Actor(gatewaySecret string, trans translation.Translator) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		actorID := ctx.GetHeader(ActorHeader)
+		if id, err := uuid.Parse(actorID); err == nil && validActorSignature(gatewaySecret, actorID, ctx.GetHeader(ActorSignatureHeader)) {
+			ctx.Request = ctx.Request.WithContext(domain.WithActor(ctx.Request.Context(), domain.Actor{UUID: id}))
+		}
+
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			if !domain.ActorFromContext(ctx.Request.Context()).IsUser() {
+				presenter.NewResponse(ctx, trans).Error(serviceerror.New(serviceerror.Unauthorized)).Echo(http.StatusUnauthorized)
+				return
+			}
+		}
+
+		ctx.Next()
+	}
+}
+
+// validActorSignature without a gateway secret no actor header is trusted
+func validActorSignature(gatewaySecret string, actorID string, signature string) bool {
+	if gatewaySecret == "" {
+		return false
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(gatewaySecret))
+	mac.Write([]byte(actorID))
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
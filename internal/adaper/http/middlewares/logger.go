@@ -68,7 +68,7 @@ func DefaultStructuredLogger(log logger.Logger) gin.HandlerFunc {
 			keys[logger.RequestBody] = string(bodyBytes)
 			keys[logger.ResponseBody] = bodyLogWriter.body.String()
 
-			log.Info(logger.RequestResponse, logger.API, "Request", keys)
+			log.WithContext(ctx.Request.Context()).Info(logger.RequestResponse, logger.API, "Request", keys)
 		}
 	}
 }
@@ -0,0 +1,48 @@
+package middlewares
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// PrimaryReadsCookie holds the unix milliseconds until which the reads of the client go to the primary
+const PrimaryReadsCookie = "primary_reads_until"
+
+// PrimaryReads sends the reads of a client to the primary for window after its last write, so it reads
+// its own writes and the ETag it sends back is not older than the one the write returned,
+// a client which drops the cookie may still read from a replica, zero window disables it
+func PrimaryReads(window time.Duration) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if window <= 0 {
+			ctx.Next()
+			return
+		}
+
+		if value, err := ctx.Cookie(PrimaryReadsCookie); err == nil {
+			if until, err := strconv.ParseInt(value, 10, 64); err == nil && time.Now().UnixMilli() < until {
+				ctx.Request = ctx.Request.WithContext(domain.WithPrimaryReads(ctx.Request.Context()))
+			}
+		}
+
+		// the cookie goes out with the headers, before the handler writes the response
+		switch ctx.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			ctx.SetCookie(
+				PrimaryReadsCookie,
+				strconv.FormatInt(time.Now().Add(window).UnixMilli(), 10),
+				int(math.Ceil(window.Seconds())),
+				"/",
+				"",
+				false,
+				true,
+			)
+		}
+
+		ctx.Next()
+	}
+}
@@ -3,11 +3,13 @@ package presenter
 import "github.com/mohsenabedy91/Sikabiz/internal/core/domain"
 
 type Address struct {
+	ID      string  `json:"id" example:"3c1e9a52-0d7f-4a8e-9b2a-6f1d2e3c4b5a"`
 	Street  *string `json:"street,omitempty" example:"817 East Lodgeville"`
 	City    *string `json:"city,omitempty" example:"New York City"`
 	State   *string `json:"state,omitempty" example:"Arkansas"`
 	ZipCode *string `json:"zip_code,omitempty" example:"58532"`
 	Country *string `json:"country,omitempty" example:"France"`
+	Version int     `json:"version" example:"1"`
 }
 
 func PrepareAddress(address *domain.Address) *Address {
@@ -16,16 +18,18 @@ func PrepareAddress(address *domain.Address) *Address {
 	}
 
 	return &Address{
+		ID:      address.UUID.String(),
 		Street:  address.Street,
 		City:    address.City,
 		State:   address.State,
 		ZipCode: address.ZipCode,
 		Country: address.Country,
+		Version: address.Version,
 	}
 }
 
 func ToAddressCollection(addresses []*domain.Address) []Address {
-	var response []Address
+	response := make([]Address, 0, len(addresses))
 	for _, address := range addresses {
 		result := PrepareAddress(address)
 		if result != nil {
@@ -35,3 +39,7 @@ func ToAddressCollection(addresses []*domain.Address) []Address {
 
 	return response
 }
+
+func ToAddressResource(address *domain.Address) *Address {
+	return PrepareAddress(address)
+}
@@ -0,0 +1,59 @@
+package presenter
+
+import (
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"time"
+)
+
+type AuditChange struct {
+	Old *string `json:"old" example:"john"`
+	New *string `json:"new" example:"johnny"`
+}
+
+// Actor a user acting through the API has an id, a job of the system has a name
+type Actor struct {
+	ID   string `json:"id,omitempty" example:"8f4a1582-6a67-4d85-950b-2d17049c7385"`
+	Name string `json:"name,omitempty" example:"userimporter"`
+}
+
+type AuditLog struct {
+	Entity    string                 `json:"entity" example:"user"`
+	EntityID  string                 `json:"entity_id" example:"8f4a1582-6a67-4d85-950b-2d17049c7385"`
+	Action    string                 `json:"action" example:"updated"`
+	Actor     *Actor                 `json:"actor,omitempty"`
+	Changes   map[string]AuditChange `json:"changes,omitempty"`
+	CreatedAt time.Time              `json:"created_at" example:"2025-01-26T10:00:00Z"`
+}
+
+func ToAuditLogCollection(logs []*domain.AuditLog) []AuditLog {
+	response := make([]AuditLog, 0, len(logs))
+	for _, entry := range logs {
+		result := AuditLog{
+			Entity:    entry.Entity,
+			EntityID:  entry.EntityUUID.String(),
+			Action:    entry.Action,
+			CreatedAt: entry.CreatedAt,
+		}
+		if entry.Actor.IsUser() || entry.Actor.Name != "" {
+			result.Actor = &Actor{
+				Name: entry.Actor.Name,
+			}
+			if entry.Actor.IsUser() {
+				result.Actor.ID = entry.Actor.UUID.String()
+			}
+		}
+		if len(entry.Changes) > 0 {
+			result.Changes = make(map[string]AuditChange, len(entry.Changes))
+			for field, change := range entry.Changes {
+				result.Changes[field] = AuditChange{
+					Old: change.Old,
+					New: change.New,
+				}
+			}
+		}
+
+		response = append(response, result)
+	}
+
+	return response
+}
@@ -0,0 +1,26 @@
+package presenter
+
+import "github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+
+// Pagination the next page is requested with next_cursor as the cursor
+type Pagination struct {
+	NextCursor string `json:"next_cursor,omitempty" example:"eyJzIjoiY3JlYXRlZF9hdCJ9"`
+	HasMore    bool   `json:"has_more" example:"true"`
+	Limit      int    `json:"limit" example:"20"`
+}
+
+func ToPagination(page *domain.UserPage) Pagination {
+	return Pagination{
+		NextCursor: page.NextCursor,
+		HasMore:    page.HasMore,
+		Limit:      page.Limit,
+	}
+}
+
+func ToAuditLogPagination(page *domain.AuditLogPage) Pagination {
+	return Pagination{
+		NextCursor: page.NextCursor,
+		HasMore:    page.HasMore,
+		Limit:      page.Limit,
+	}
+}
@@ -11,6 +11,7 @@ type User struct {
 	Email       string    `json:"email,omitempty" example:"john.doe@gmail.com"`
 	PhoneNumber string    `json:"phone_number,omitempty" example:"09121111111"`
 	Addresses   []Address `json:"addresses,omitempty"`
+	Version     int       `json:"version" example:"1"`
 }
 
 func PrepareUser(user *domain.User) *User {
@@ -25,9 +26,22 @@ func PrepareUser(user *domain.User) *User {
 		Email:       user.Email,
 		PhoneNumber: user.PhoneNumber,
 		Addresses:   ToAddressCollection(user.Addresses),
+		Version:     user.Version,
 	}
 }
 
 func ToUserResource(user *domain.User) *User {
 	return PrepareUser(user)
 }
+
+func ToUserCollection(users []*domain.User) []User {
+	response := make([]User, 0, len(users))
+	for _, user := range users {
+		result := PrepareUser(user)
+		if result != nil {
+			response = append(response, *result)
+		}
+	}
+
+	return response
+}
@@ -0,0 +1,22 @@
+package presenter
+
+import "github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+
+type UserSearchResult struct {
+	User       *User             `json:"user"`
+	Rank       float64           `json:"rank" example:"0.83"`
+	Highlights map[string]string `json:"highlights,omitempty" swaggertype:"object,string" example:"first_name:<mark>Jo</mark>hn"`
+}
+
+func ToUserSearchCollection(results []*domain.UserSearchResult) []UserSearchResult {
+	response := make([]UserSearchResult, 0, len(results))
+	for _, result := range results {
+		response = append(response, UserSearchResult{
+			User:       PrepareUser(result.User),
+			Rank:       result.Rank,
+			Highlights: result.Highlights,
+		})
+	}
+
+	return response
+}
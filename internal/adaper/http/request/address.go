@@ -0,0 +1,26 @@
+package request
+
+import "github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+
+type AddressUUIDUri struct {
+	UserUUIDStr string `uri:"userID" binding:"required,uuid" example:"8f4a1582-6a67-4d85-950b-2d17049c7385"`
+	UUIDStr     string `uri:"addressID" binding:"required,uuid" example:"3c1e9a52-0d7f-4a8e-9b2a-6f1d2e3c4b5a"`
+}
+
+type Address struct {
+	Street  *string `json:"street" binding:"required,max=255" example:"817 East Lodgeville"`
+	City    *string `json:"city" binding:"required,max=255" example:"New York City"`
+	State   *string `json:"state" binding:"omitempty,max=255" example:"Arkansas"`
+	ZipCode *string `json:"zip_code" binding:"omitempty,max=255" example:"58532"`
+	Country *string `json:"country" binding:"required,max=255" example:"France"`
+}
+
+func (r Address) ToAddressDomain() *domain.Address {
+	return &domain.Address{
+		Street:  r.Street,
+		City:    r.City,
+		State:   r.State,
+		ZipCode: r.ZipCode,
+		Country: r.Country,
+	}
+}
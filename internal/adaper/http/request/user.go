@@ -1,5 +1,95 @@
 package request
 
+import (
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"strings"
+	"time"
+)
+
 type UserUUIDUri struct {
 	UUIDStr string `uri:"userID" binding:"required,uuid" example:"8f4a1582-6a67-4d85-950b-2d17049c7385"`
 }
+
+type UpdateUser struct {
+	FirstName   *string `json:"first_name" binding:"omitempty,max=128" example:"john"`
+	LastName    *string `json:"last_name" binding:"omitempty,max=128" example:"doe"`
+	Email       string  `json:"email" binding:"required,email,max=128" example:"john.doe@gmail.com"`
+	PhoneNumber string  `json:"phone_number" binding:"required,max=128" example:"09121111111"`
+}
+
+func (r UpdateUser) ToUserDomain() *domain.User {
+	return &domain.User{
+		FirstName:   r.FirstName,
+		LastName:    r.LastName,
+		Email:       r.Email,
+		PhoneNumber: r.PhoneNumber,
+	}
+}
+
+// PatchUser only the fields in the body are changed
+type PatchUser struct {
+	FirstName   *string `json:"first_name" binding:"omitempty,max=128" example:"john"`
+	LastName    *string `json:"last_name" binding:"omitempty,max=128" example:"doe"`
+	Email       *string `json:"email" binding:"omitempty,email,max=128" example:"john.doe@gmail.com"`
+	PhoneNumber *string `json:"phone_number" binding:"omitempty,min=1,max=128" example:"09121111111"`
+}
+
+func (r PatchUser) ToUserPatch() domain.UserPatch {
+	return domain.UserPatch{
+		FirstName:   r.FirstName,
+		LastName:    r.LastName,
+		Email:       r.Email,
+		PhoneNumber: r.PhoneNumber,
+	}
+}
+
+// ListUsers a sort field prefixed with a minus sorts descending
+type ListUsers struct {
+	Cursor      string    `form:"cursor" example:"eyJzIjoiY3JlYXRlZF9hdCJ9"`
+	Limit       int       `form:"limit" binding:"omitempty,min=1,max=100" example:"20"`
+	Sort        string    `form:"sort" binding:"omitempty,oneof=created_at -created_at updated_at -updated_at email -email first_name -first_name last_name -last_name" example:"-created_at"`
+	Email       string    `form:"email" binding:"omitempty,email" example:"john.doe@gmail.com"`
+	PhoneNumber string    `form:"phone_number" example:"09121111111"`
+	Name        string    `form:"name" binding:"omitempty,max=128" example:"jo"`
+	Country     string    `form:"country" binding:"omitempty,max=255" example:"France"`
+	City        string    `form:"city" binding:"omitempty,max=255" example:"Paris"`
+	CreatedFrom time.Time `form:"created_from" time_format:"2006-01-02T15:04:05Z07:00" example:"2025-01-01T00:00:00Z"`
+	CreatedTo   time.Time `form:"created_to" time_format:"2006-01-02T15:04:05Z07:00" example:"2025-02-01T00:00:00Z"`
+	Deleted     string    `form:"deleted" binding:"omitempty,oneof=without with only" example:"without"`
+}
+
+func (r ListUsers) ToUserListQuery() domain.UserListQuery {
+	return domain.UserListQuery{
+		Filter: domain.UserFilter{
+			Email:       r.Email,
+			PhoneNumber: r.PhoneNumber,
+			Name:        r.Name,
+			Country:     r.Country,
+			City:        r.City,
+			CreatedFrom: r.CreatedFrom,
+			CreatedTo:   r.CreatedTo,
+			Deleted:     r.Deleted,
+		},
+		Sort:   strings.TrimPrefix(r.Sort, "-"),
+		Desc:   strings.HasPrefix(r.Sort, "-"),
+		Limit:  r.Limit,
+		Cursor: r.Cursor,
+	}
+}
+
+type SearchUsers struct {
+	Query string `form:"q" binding:"required,min=2,max=128" example:"jon doe"`
+	Limit int    `form:"limit" binding:"omitempty,min=1,max=50" example:"20"`
+}
+
+type UserHistory struct {
+	Cursor string `form:"cursor" example:"MTI"`
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=100" example:"20"`
+}
+
+func (r UserHistory) ToAuditLogQuery() domain.AuditLogQuery {
+	return domain.AuditLogQuery{
+		Limit:  r.Limit,
+		Cursor: r.Cursor,
+	}
+}
@@ -0,0 +1,27 @@
+package routes
+
+import (
+	"github.com/mohsenabedy91/Sikabiz/internal/adaper/http/handler"
+	"github.com/mohsenabedy91/Sikabiz/internal/adaper/http/middlewares"
+)
+
+func (r *Router) NewAddressRouter(addressHandler handler.AddressHandler) *Router {
+	v1 := r.Engine.Group(":language/v1", middlewares.LocaleMiddleware(r.trans))
+	{
+		address := v1.Group("users/:userID/addresses")
+		{
+			address.GET("", addressHandler.List)
+			address.POST("", addressHandler.Create)
+			address.GET(":addressID", addressHandler.Get)
+			address.PUT(":addressID", addressHandler.Update)
+			address.DELETE(":addressID", addressHandler.Delete)
+		}
+	}
+
+	return &Router{
+		Engine: r.Engine,
+		log:    r.log,
+		conf:   r.conf,
+		trans:  r.trans,
+	}
+}
@@ -11,7 +11,9 @@ import (
 	"github.com/mohsenabedy91/Sikabiz/pkg/translation"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 	"net/http"
+	"time"
 )
 
 // Router is a wrapper for HTTP router
@@ -37,9 +39,13 @@ func NewRouter(
 	router := gin.New()
 	RegisterPrometheus(log)
 
+	// the span starts first, so the logs of the request carry its trace id
+	router.Use(otelgin.Middleware(conf.App.Name))
 	router.Use(middlewares.Prometheus())
 	router.Use(gin.Logger(), gin.CustomRecovery(middlewares.ErrorHandler(trans)))
 	router.Use(middlewares.DefaultStructuredLogger(log))
+	router.Use(middlewares.Actor(conf.Auth.GatewaySecret, trans))
+	router.Use(middlewares.PrimaryReads(conf.DB.ReplicaPrimaryWindow * time.Second))
 
 	setSwaggerRoutes(router.Group(""), conf.Swagger)
 
@@ -10,7 +10,15 @@ func (r *Router) NewUserRouter(userHandler handler.UserHandler) *Router {
 	{
 		user := v1.Group("users")
 		{
+			user.GET("", userHandler.List)
+			user.GET("search", userHandler.Search)
 			user.GET(":userID", userHandler.Get)
+			user.PUT(":userID", userHandler.Update)
+			user.PATCH(":userID", userHandler.Patch)
+			user.DELETE(":userID", userHandler.Delete)
+			user.POST(":userID/restore", userHandler.Restore)
+			user.DELETE(":userID/permanent", userHandler.ForceDelete)
+			user.GET(":userID/history", userHandler.History)
 		}
 	}
 
@@ -0,0 +1,58 @@
+package messagebroker
+
+import (
+	"encoding/json"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"strings"
+)
+
+const DeadLetterSuffix = ".dlq"
+
+// splitDeadLetter reports whether name addresses the dead letters of a queue which keeps them
+// next to its live messages, as the memory and postgres drivers do
+func splitDeadLetter(name string) (string, bool) {
+	if strings.HasSuffix(name, DeadLetterSuffix) {
+		return strings.TrimSuffix(name, DeadLetterSuffix), true
+	}
+
+	return name, false
+}
+
+// toQueueMessage shows a message which is not plain JSON as the JSON of its envelope
+func toQueueMessage(body []byte, contentType string, contentEncoding string, attempts int) domain.QueueMessage {
+	message := domain.QueueMessage{
+		Attempts: attempts,
+		Body:     body,
+	}
+
+	codec, err := CodecFor(contentType, contentEncoding)
+	if err != nil {
+		return message
+	}
+
+	envelope, err := codec.Unmarshal(body)
+	if err != nil {
+		return message
+	}
+	message.ID = envelope.ID
+	message.Type = envelope.Type
+	message.Timestamp = envelope.Timestamp
+
+	if codec.ContentType != domain.EnvelopeContentType || codec.ContentEncoding != "" {
+		if plain, err := json.Marshal(envelope); err == nil {
+			message.Body = plain
+		}
+	}
+
+	return message
+}
+
+// decode picks the codec from the content type and encoding the message was produced with
+func decode(body []byte, contentType string, contentEncoding string) (*domain.Envelope, error) {
+	codec, err := CodecFor(contentType, contentEncoding)
+	if err != nil {
+		return nil, err
+	}
+
+	return codec.Unmarshal(body)
+}
@@ -0,0 +1,51 @@
+package messagebroker
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// backoff doubles the interval after every failed attempt up to max, the wait is picked
+// between half and the whole interval, so reconnecting clients do not hit the broker together
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	attempt int
+}
+
+func newBackoff(initial time.Duration, max time.Duration) *backoff {
+	if initial <= 0 {
+		initial = time.Second
+	}
+	if max < initial {
+		max = initial
+	}
+
+	return &backoff{initial: initial, max: max}
+}
+
+func (r *backoff) next() time.Duration {
+	interval := r.max
+	if r.attempt < 32 {
+		if doubled := r.initial << r.attempt; doubled > 0 && doubled < r.max {
+			interval = doubled
+		}
+	}
+	r.attempt++
+
+	half := interval / 2
+	return half + rand.N(interval-half+1)
+}
+
+func (r *backoff) reset() {
+	r.attempt = 0
+}
+
+// redeliveryDelay is the wait before a failed message is delivered again, it grows with the deliveries
+// the message already had, so a message which keeps failing does not spin the consumer
+func redeliveryDelay(initial time.Duration, maxDelay time.Duration, deliveries int) time.Duration {
+	delay := newBackoff(initial, maxDelay)
+	delay.attempt = max(deliveries-1, 0)
+
+	return delay.next()
+}
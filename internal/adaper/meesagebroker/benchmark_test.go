@@ -0,0 +1,81 @@
+package messagebroker
+
+import (
+	"context"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/config"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"os"
+	"testing"
+)
+
+const benchmarkQueue = "benchmark_queue"
+
+// benchmarkProduce publishes from parallel producers, run it with -cpu to change their number,
+// e.g. go test -run '^$' -bench Produce -cpu 1,10 ./internal/adaper/meesagebroker
+func benchmarkProduce(b *testing.B, driver interface {
+	Produce(ctx context.Context, name string, envelope *domain.Envelope, delaySeconds int64) error
+}) {
+	firstName := "benchmark"
+	payload := domain.User{FirstName: &firstName, Email: "benchmark@example.com"}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			envelope, err := domain.NewEnvelope("benchmark", 1, payload)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			if err = driver.Produce(context.Background(), benchmarkQueue, envelope, 0); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkMemoryProduce(b *testing.B) {
+	codecs, err := NewCodecs(config.Queue{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	memory := NewMemory(config.Queue{}, codecs, nopLogger{})
+	defer memory.Close()
+
+	benchmarkProduce(b, memory)
+}
+
+// BenchmarkRabbitMQProduce needs a broker at BENCHMARK_RABBITMQ_URL,
+// every message waits for its publisher confirm
+func BenchmarkRabbitMQProduce(b *testing.B) {
+	url := os.Getenv("BENCHMARK_RABBITMQ_URL")
+	if url == "" {
+		b.Skip("BENCHMARK_RABBITMQ_URL is not set")
+	}
+
+	codecs, err := NewCodecs(config.Queue{})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	driver, err := NewRabbitMQ(config.Config{
+		RabbitMQ: config.RabbitMQ{URL: url, ConfirmTimeout: 5, PublishChannels: 10, FlushTimeout: 5},
+	}, nil, codecs, nopLogger{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer driver.Close()
+
+	benchmarkProduce(b, driver)
+	b.StopTimer()
+
+	if err = driver.withChannel(func(channel *amqp.Channel) error {
+		_, err := channel.QueueDelete(benchmarkQueue, false, false, false)
+		return err
+	}); err != nil {
+		b.Error(err)
+	}
+}
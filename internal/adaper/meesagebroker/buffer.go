@@ -0,0 +1,200 @@
+package messagebroker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/config"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"io"
+	"os"
+)
+
+type bufferedMessage struct {
+	Queue    string           `json:"queue"`
+	Envelope *domain.Envelope `json:"envelope"`
+}
+
+// produceBuffer holds the messages produced while the connection is down, the driver
+// serializes the access to it, the publishing of the peeked messages runs without the lock
+type produceBuffer interface {
+	push(message bufferedMessage) error
+	// peek returns up to n of the oldest messages and leaves them in the buffer
+	peek(n int) ([]bufferedMessage, error)
+	// drop removes the n oldest messages once they are published
+	drop(n int) error
+	len() int
+}
+
+// newProduceBuffer returns nil when buffering is disabled
+func newProduceBuffer(conf config.RabbitMQ) (produceBuffer, error) {
+	switch conf.Buffer {
+	case "":
+		return nil, nil
+	case "memory":
+		return &memoryBuffer{size: conf.BufferSize}, nil
+	case "disk":
+		if conf.BufferPath == "" {
+			return nil, errors.New("RABBITMQ_BUFFER_PATH is required for the disk buffer")
+		}
+		return newDiskBuffer(conf.BufferPath, conf.BufferSize)
+	default:
+		return nil, fmt.Errorf("unknown produce buffer %q", conf.Buffer)
+	}
+}
+
+type memoryBuffer struct {
+	size     int
+	messages []bufferedMessage
+}
+
+func (r *memoryBuffer) push(message bufferedMessage) error {
+	if len(r.messages) >= r.size {
+		return ErrBufferFull
+	}
+
+	r.messages = append(r.messages, message)
+	return nil
+}
+
+func (r *memoryBuffer) peek(n int) ([]bufferedMessage, error) {
+	n = min(n, len(r.messages))
+	return append([]bufferedMessage(nil), r.messages[:n]...), nil
+}
+
+func (r *memoryBuffer) drop(n int) error {
+	r.messages = r.messages[min(n, len(r.messages)):]
+	if len(r.messages) == 0 {
+		r.messages = nil
+	}
+	return nil
+}
+
+func (r *memoryBuffer) len() int {
+	return len(r.messages)
+}
+
+// diskBuffer appends the messages to an NDJSON file, so they survive a restart of the producer,
+// the messages left by a previous run are flushed after the first connect
+type diskBuffer struct {
+	path  string
+	size  int
+	count int
+}
+
+func newDiskBuffer(path string, size int) (*diskBuffer, error) {
+	buffer := &diskBuffer{path: path, size: size}
+
+	messages, err := buffer.read()
+	if err != nil {
+		return nil, err
+	}
+	buffer.count = len(messages)
+
+	return buffer, nil
+}
+
+func (r *diskBuffer) push(message bufferedMessage) error {
+	if r.count >= r.size {
+		return ErrBufferFull
+	}
+
+	file, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	if err = json.NewEncoder(file).Encode(message); err != nil {
+		return err
+	}
+
+	r.count++
+	return nil
+}
+
+func (r *diskBuffer) peek(n int) ([]bufferedMessage, error) {
+	messages, err := r.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return messages[:min(n, len(messages))], nil
+}
+
+// drop reads the file again, the messages pushed since the peek are kept
+func (r *diskBuffer) drop(n int) error {
+	messages, err := r.read()
+	if err != nil {
+		return err
+	}
+
+	if n < len(messages) {
+		return r.write(messages[n:])
+	}
+
+	if err = os.Remove(r.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	r.count = 0
+
+	return nil
+}
+
+func (r *diskBuffer) len() int {
+	return r.count
+}
+
+func (r *diskBuffer) read() ([]bufferedMessage, error) {
+	file, err := os.Open(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+
+	var messages []bufferedMessage
+	decoder := json.NewDecoder(file)
+	for {
+		var message bufferedMessage
+		if err = decoder.Decode(&message); errors.Is(err, io.EOF) {
+			return messages, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("read produce buffer %s: %w", r.path, err)
+		}
+		messages = append(messages, message)
+	}
+}
+
+// write replaces the file through a rename, so a crash never leaves it half written
+func (r *diskBuffer) write(messages []bufferedMessage) error {
+	tmp := r.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	for _, message := range messages {
+		if err = encoder.Encode(message); err != nil {
+			_ = file.Close()
+			return err
+		}
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmp, r.path); err != nil {
+		return err
+	}
+	r.count = len(messages)
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package messagebroker
+
+import (
+	"context"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// publishChannel is a long-lived channel in confirm mode, it is held by a single producer
+// from publish until the confirmation arrives, so every return on it belongs to that producer
+type publishChannel struct {
+	channel *amqp.Channel
+	returns chan amqp.Return
+}
+
+func newPublishChannel(conn *amqp.Connection) (*publishChannel, error) {
+	channel, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = channel.Confirm(false); err != nil {
+		_ = channel.Close()
+		return nil, err
+	}
+
+	return &publishChannel{
+		channel: channel,
+		returns: channel.NotifyReturn(make(chan amqp.Return, 1)),
+	}, nil
+}
+
+// drainReturns drops a return left behind by a message which timed out waiting for its confirmation
+func (r *publishChannel) drainReturns() {
+	for {
+		select {
+		case <-r.returns:
+		default:
+			return
+		}
+	}
+}
+
+// channelPool opens at most size channels, every open channel, idle or held by a producer, takes
+// a slot, so a burst of producers waits for a channel instead of opening one each
+type channelPool struct {
+	connection func() *amqp.Connection
+	channels   chan *publishChannel
+	slots      chan struct{}
+}
+
+func newChannelPool(size int, connection func() *amqp.Connection) *channelPool {
+	if size < 1 {
+		size = 1
+	}
+
+	return &channelPool{
+		connection: connection,
+		channels:   make(chan *publishChannel, size),
+		slots:      make(chan struct{}, size),
+	}
+}
+
+// get returns an idle channel, opens a new one while a slot is free or waits until ctx is done
+func (r *channelPool) get(ctx context.Context) (*publishChannel, error) {
+	for {
+		select {
+		case ch := <-r.channels:
+			if ch = r.usable(ch); ch != nil {
+				return ch, nil
+			}
+			continue
+		default:
+		}
+
+		select {
+		case ch := <-r.channels:
+			if ch = r.usable(ch); ch != nil {
+				return ch, nil
+			}
+		case r.slots <- struct{}{}:
+			ch, err := newPublishChannel(r.connection())
+			if err != nil {
+				r.release()
+				return nil, err
+			}
+			return ch, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// usable returns nil and frees the slot of a channel the broker closed while it was idle
+func (r *channelPool) usable(ch *publishChannel) *publishChannel {
+	if ch.channel.IsClosed() {
+		r.release()
+		return nil
+	}
+
+	ch.drainReturns()
+	return ch
+}
+
+// put keeps the channel for the next producer, the slot of a closed channel is freed
+func (r *channelPool) put(ch *publishChannel) {
+	if ch.channel.IsClosed() {
+		r.release()
+		return
+	}
+
+	select {
+	case r.channels <- ch:
+	default:
+		_ = ch.channel.Close()
+		r.release()
+	}
+}
+
+func (r *channelPool) release() {
+	select {
+	case <-r.slots:
+	default:
+	}
+}
+
+// reset closes the idle channels, it is called after the connection is replaced, the channels
+// held by producers are closed with the old connection and free their slots on put
+func (r *channelPool) reset() {
+	for {
+		select {
+		case ch := <-r.channels:
+			_ = ch.channel.Close()
+			r.release()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package messagebroker
+
+import (
+	"context"
+	"errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"testing"
+	"time"
+)
+
+func TestChannelPoolWaitsWhenEveryChannelIsOpen(t *testing.T) {
+	opened := 0
+	pool := newChannelPool(2, func() *amqp.Connection {
+		opened++
+		return nil
+	})
+
+	// both slots are held by producers which have not put their channel back yet
+	pool.slots <- struct{}{}
+	pool.slots <- struct{}{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := pool.get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("get() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if opened != 0 {
+		t.Errorf("opened %d channels above the pool size", opened)
+	}
+}
+
+func TestChannelPoolReleasesSlots(t *testing.T) {
+	pool := newChannelPool(1, nil)
+
+	pool.slots <- struct{}{}
+	pool.release()
+	if len(pool.slots) != 0 {
+		t.Fatalf("%d slots held after release, want 0", len(pool.slots))
+	}
+
+	// a release without a held slot, e.g. after reset, never blocks
+	pool.release()
+}
+
+func TestChannelPoolMinimumSize(t *testing.T) {
+	if pool := newChannelPool(0, nil); cap(pool.slots) != 1 {
+		t.Errorf("pool size = %d, want 1", cap(pool.slots))
+	}
+}
@@ -0,0 +1,318 @@
+package messagebroker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/config"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/vmihailenco/msgpack/v5"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	ContentTypeMsgpack = "application/msgpack"
+	EncodingGzip       = "gzip"
+	// maxDecodedBody bounds what a gzip body may inflate to, so a small message can not exhaust the memory
+	maxDecodedBody = 16 << 20
+)
+
+// Codec turns an envelope into a message body, its content type and encoding travel with the
+// message, so a consumer decodes whatever codec the producer used, msgpack carries the payload
+// as native msgpack and turns it back into JSON on decode
+type Codec struct {
+	Name            string
+	ContentType     string
+	ContentEncoding string
+	marshal         func(envelope *domain.Envelope) ([]byte, error)
+	unmarshal       func(body []byte) (*domain.Envelope, error)
+}
+
+var codecs = []*Codec{
+	{
+		Name:        "json",
+		ContentType: domain.EnvelopeContentType,
+		marshal:     marshalJSON,
+		unmarshal:   domain.DecodeEnvelope,
+	},
+	{
+		Name:            "json+gzip",
+		ContentType:     domain.EnvelopeContentType,
+		ContentEncoding: EncodingGzip,
+		marshal:         gzipped(marshalJSON),
+		unmarshal:       gunzipped(domain.DecodeEnvelope),
+	},
+	{
+		Name:        "msgpack",
+		ContentType: ContentTypeMsgpack,
+		marshal:     marshalMsgpack,
+		unmarshal:   unmarshalMsgpack,
+	},
+	{
+		Name:            "msgpack+gzip",
+		ContentType:     ContentTypeMsgpack,
+		ContentEncoding: EncodingGzip,
+		marshal:         gzipped(marshalMsgpack),
+		unmarshal:       gunzipped(unmarshalMsgpack),
+	},
+}
+
+func (r *Codec) Marshal(envelope *domain.Envelope) ([]byte, error) {
+	return r.marshal(envelope)
+}
+
+func (r *Codec) Unmarshal(body []byte) (*domain.Envelope, error) {
+	return r.unmarshal(body)
+}
+
+func CodecByName(name string) (*Codec, error) {
+	for _, codec := range codecs {
+		if codec.Name == name {
+			return codec, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown codec %q", name)
+}
+
+// CodecFor picks the codec of a received message, the messages published before the codecs
+// existed have no content type or text/plain and are JSON
+func CodecFor(contentType string, contentEncoding string) (*Codec, error) {
+	if contentType == "" || strings.HasPrefix(contentType, "text/plain") {
+		contentType = domain.EnvelopeContentType
+	}
+
+	for _, codec := range codecs {
+		if codec.ContentType == contentType && codec.ContentEncoding == contentEncoding {
+			return codec, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no codec for content type %q and encoding %q", contentType, contentEncoding)
+}
+
+// Codecs selects the codec a queue is produced with
+type Codecs struct {
+	fallback *Codec
+	queues   map[string]*Codec
+}
+
+func NewCodecs(conf config.Queue) (*Codecs, error) {
+	name := conf.Codec
+	if name == "" {
+		name = "json"
+	}
+
+	fallback, err := CodecByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	queues := make(map[string]*Codec, len(conf.Codecs))
+	for queue, name := range conf.Codecs {
+		codec, err := CodecByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("queue %s: %w", queue, err)
+		}
+		queues[queue] = codec
+	}
+
+	return &Codecs{
+		fallback: fallback,
+		queues:   queues,
+	}, nil
+}
+
+// ForQueue is nil-safe, a driver without codecs produces JSON
+func (r *Codecs) ForQueue(name string) *Codec {
+	if r == nil {
+		return codecs[0]
+	}
+
+	if codec, ok := r.queues[name]; ok {
+		return codec
+	}
+
+	return r.fallback
+}
+
+func marshalJSON(envelope *domain.Envelope) ([]byte, error) {
+	return json.Marshal(envelope)
+}
+
+// msgpackEnvelope is the envelope with its payload as a msgpack value instead of the JSON text
+type msgpackEnvelope struct {
+	ID            string             `msgpack:"id"`
+	Type          string             `msgpack:"type"`
+	Version       int                `msgpack:"version"`
+	Timestamp     time.Time          `msgpack:"timestamp"`
+	CorrelationID string             `msgpack:"correlation_id,omitempty"`
+	Payload       msgpack.RawMessage `msgpack:"payload"`
+	Headers       map[string]string  `msgpack:"headers,omitempty"`
+}
+
+func marshalMsgpack(envelope *domain.Envelope) ([]byte, error) {
+	value, err := nativePayload(envelope.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := msgpack.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return msgpack.Marshal(&msgpackEnvelope{
+		ID:            envelope.ID,
+		Type:          envelope.Type,
+		Version:       envelope.Version,
+		Timestamp:     envelope.Timestamp,
+		CorrelationID: envelope.CorrelationID,
+		Payload:       payload,
+		Headers:       envelope.Headers,
+	})
+}
+
+func unmarshalMsgpack(body []byte) (*domain.Envelope, error) {
+	var envelope msgpackEnvelope
+	if err := msgpack.Unmarshal(body, &envelope); err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	if err := msgpack.Unmarshal(envelope.Payload, &value); err != nil {
+		return nil, err
+	}
+
+	// the messages produced before the payload was native carry the JSON text as binary
+	payload, legacy := value.([]byte)
+	if !legacy {
+		var err error
+		if payload, err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+
+	return &domain.Envelope{
+		ID:            envelope.ID,
+		Type:          envelope.Type,
+		Version:       envelope.Version,
+		Timestamp:     envelope.Timestamp,
+		CorrelationID: envelope.CorrelationID,
+		Payload:       payload,
+		Headers:       envelope.Headers,
+	}, nil
+}
+
+// nativePayload decodes the JSON payload keeping the integers exact, a float64 would round the large ones
+func nativePayload(payload json.RawMessage) (interface{}, error) {
+	if len(payload) == 0 {
+		return nil, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	return nativeNumbers(value), nil
+}
+
+func nativeNumbers(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case json.Number:
+		if number, err := typed.Int64(); err == nil {
+			return number
+		}
+		if number, err := strconv.ParseUint(typed.String(), 10, 64); err == nil {
+			return number
+		}
+		if number, err := typed.Float64(); err == nil {
+			return number
+		}
+		return typed.String()
+	case map[string]interface{}:
+		for key, item := range typed {
+			typed[key] = nativeNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range typed {
+			typed[i] = nativeNumbers(item)
+		}
+	}
+
+	return value
+}
+
+func gzipped(marshal func(envelope *domain.Envelope) ([]byte, error)) func(envelope *domain.Envelope) ([]byte, error) {
+	return func(envelope *domain.Envelope) ([]byte, error) {
+		body, err := marshal(envelope)
+		if err != nil {
+			return nil, err
+		}
+
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		if _, err = writer.Write(body); err != nil {
+			return nil, err
+		}
+		if err = writer.Close(); err != nil {
+			return nil, err
+		}
+
+		return buffer.Bytes(), nil
+	}
+}
+
+func gunzipped(unmarshal func(body []byte) (*domain.Envelope, error)) func(body []byte) (*domain.Envelope, error) {
+	return func(body []byte) (*domain.Envelope, error) {
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer func(reader *gzip.Reader) {
+			_ = reader.Close()
+		}(reader)
+
+		plain, err := io.ReadAll(io.LimitReader(reader, maxDecodedBody+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(plain) > maxDecodedBody {
+			return nil, ErrBodyTooLarge
+		}
+
+		return unmarshal(plain)
+	}
+}
+
+// messageBody is a message body in the logs, the text is only built when a line is written, a gzip or
+// msgpack body shows as its JSON envelope and a body which is neither text nor decodable as base64
+type messageBody struct {
+	body            []byte
+	contentType     string
+	contentEncoding string
+}
+
+func (r messageBody) String() string {
+	if r.contentEncoding == "" && r.contentType != ContentTypeMsgpack && utf8.Valid(r.body) {
+		return string(r.body)
+	}
+
+	if envelope, err := decode(r.body, r.contentType, r.contentEncoding); err == nil {
+		if plain, err := json.Marshal(envelope); err == nil {
+			return string(plain)
+		}
+	}
+
+	return base64.StdEncoding.EncodeToString(r.body)
+}
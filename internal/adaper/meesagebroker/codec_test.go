@@ -0,0 +1,159 @@
+package messagebroker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/vmihailenco/msgpack/v5"
+	"strings"
+	"testing"
+)
+
+func codecByName(t *testing.T, name string) *Codec {
+	t.Helper()
+
+	codec, err := CodecByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return codec
+}
+
+func TestCodecsRoundTrip(t *testing.T) {
+	envelope, err := domain.NewEnvelope("test.event", 2, map[string]interface{}{
+		"id":    uint64(18446744073709551615),
+		"big":   int64(9007199254740993),
+		"ratio": 0.5,
+		"name":  "test",
+		"tags":  []string{"a", "b"},
+		"empty": nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope.WithCorrelationID("correlation")
+	envelope.Headers = map[string]string{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}
+
+	for _, name := range []string{"json", "json+gzip", "msgpack", "msgpack+gzip"} {
+		t.Run(name, func(t *testing.T) {
+			codec := codecByName(t, name)
+
+			body, err := codec.Marshal(envelope)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			decoded, err := decode(body, codec.ContentType, codec.ContentEncoding)
+			if err != nil {
+				t.Fatalf("decode() error = %v", err)
+			}
+
+			if decoded.ID != envelope.ID || decoded.Type != envelope.Type || decoded.Version != envelope.Version ||
+				decoded.CorrelationID != envelope.CorrelationID || !decoded.Timestamp.Equal(envelope.Timestamp) ||
+				decoded.Headers["traceparent"] != envelope.Headers["traceparent"] {
+				t.Errorf("decoded envelope = %+v, want %+v", decoded, envelope)
+			}
+			assertSamePayload(t, decoded.Payload, envelope.Payload)
+		})
+	}
+}
+
+func TestMsgpackPayloadIsNative(t *testing.T) {
+	envelope := newTestEnvelope(t)
+
+	body, err := codecByName(t, "msgpack").Marshal(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw struct {
+		Payload interface{} `msgpack:"payload"`
+	}
+	if err = msgpack.Unmarshal(body, &raw); err != nil {
+		t.Fatal(err)
+	}
+
+	payload, ok := raw.Payload.(map[string]interface{})
+	if !ok || payload["name"] != "test" {
+		t.Errorf("payload = %#v, want a msgpack map", raw.Payload)
+	}
+}
+
+func TestMsgpackDecodesLegacyBinaryPayload(t *testing.T) {
+	envelope := newTestEnvelope(t)
+
+	// the encoder before the native payload wrote the envelope with its JSON tags and the payload bytes
+	var buffer bytes.Buffer
+	encoder := msgpack.NewEncoder(&buffer)
+	encoder.SetCustomStructTag("json")
+	if err := encoder.Encode(envelope); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := unmarshalMsgpack(buffer.Bytes())
+	if err != nil {
+		t.Fatalf("unmarshalMsgpack() error = %v", err)
+	}
+	if decoded.ID != envelope.ID {
+		t.Errorf("decoded id = %s, want %s", decoded.ID, envelope.ID)
+	}
+	assertSamePayload(t, decoded.Payload, envelope.Payload)
+}
+
+func TestGunzipStopsAtSizeLimit(t *testing.T) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write(make([]byte, maxDecodedBody+1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := decode(buffer.Bytes(), domain.EnvelopeContentType, EncodingGzip)
+	if !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("decode() error = %v, want %v", err, ErrBodyTooLarge)
+	}
+}
+
+func TestMessageBodyLogsReadableText(t *testing.T) {
+	envelope := newTestEnvelope(t)
+
+	for _, name := range []string{"json", "json+gzip", "msgpack", "msgpack+gzip"} {
+		codec := codecByName(t, name)
+		body, err := codec.Marshal(envelope)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if logged := (messageBody{body, codec.ContentType, codec.ContentEncoding}).String(); !strings.Contains(logged, envelope.ID) {
+			t.Errorf("%s body logged as %q, want the JSON envelope", name, logged)
+		}
+	}
+
+	binary := []byte{0xff, 0xfe, 0x00}
+	if logged := (messageBody{binary, "application/octet-stream", ""}).String(); logged != "//4A" {
+		t.Errorf("binary body logged as %q, want base64", logged)
+	}
+}
+
+func assertSamePayload(t *testing.T, got json.RawMessage, want json.RawMessage) {
+	t.Helper()
+
+	var gotValue, wantValue interface{}
+	for raw, value := range map[*json.RawMessage]*interface{}{&got: &gotValue, &want: &wantValue} {
+		decoder := json.NewDecoder(bytes.NewReader(*raw))
+		decoder.UseNumber()
+		if err := decoder.Decode(value); err != nil {
+			t.Fatalf("payload %s is not JSON: %v", *raw, err)
+		}
+	}
+
+	gotJSON, _ := json.Marshal(gotValue)
+	wantJSON, _ := json.Marshal(wantValue)
+	if !bytes.Equal(gotJSON, wantJSON) {
+		t.Errorf("payload = %s, want %s", gotJSON, wantJSON)
+	}
+}
@@ -0,0 +1,38 @@
+package messagebroker
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrPublishNacked   = errors.New("message was nacked by the broker")
+	ErrPublishReturned = errors.New("message is unroutable and returned by the broker")
+	ErrPublishTimeout  = errors.New("timed out waiting for the broker confirmation")
+	ErrBufferFull      = errors.New("the produce buffer is full")
+	ErrBodyTooLarge    = errors.New("the decoded message body exceeds the size limit")
+
+	ErrScheduleNotFound      = errors.New("scheduled message not found")
+	ErrScheduleNotPending    = errors.New("scheduled message is already dispatched or cancelled")
+	ErrSchedulerNotAvailable = errors.New("no scheduler is attached to the queue")
+)
+
+// PublishError is returned by Produce when the broker did not take responsibility for the message
+type PublishError struct {
+	Queue     string
+	MessageID string
+	Reason    error
+}
+
+func (r *PublishError) Error() string {
+	return fmt.Sprintf("publish message %s to queue %s: %v", r.MessageID, r.Queue, r.Reason)
+}
+
+func (r *PublishError) Unwrap() error {
+	return r.Reason
+}
+
+// Retryable an unroutable message will be returned again, so it is not worth retrying
+func (r *PublishError) Retryable() bool {
+	return !errors.Is(r.Reason, ErrPublishReturned)
+}
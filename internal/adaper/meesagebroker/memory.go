@@ -0,0 +1,276 @@
+package messagebroker
+
+import (
+	"context"
+	"fmt"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/config"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"github.com/mohsenabedy91/Sikabiz/pkg/metrics"
+	"github.com/mohsenabedy91/Sikabiz/pkg/tracing"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type memoryMessage struct {
+	id              string
+	body            []byte
+	contentType     string
+	contentEncoding string
+	availableAt     time.Time
+	deliveries      int
+}
+
+type memoryQueue struct {
+	messages    []*memoryMessage
+	deadLetters []*memoryMessage
+	notify      chan struct{}
+}
+
+// Memory is an in-process driver for tests and local development, messages are lost when the process exits,
+// so only a process which consumes what it produces can use it
+type Memory struct {
+	log             logger.Logger
+	codecs          *Codecs
+	mu              sync.Mutex
+	queues          map[string]*memoryQueue
+	routes          map[string]bool
+	subscribed      map[string]bool
+	maxRedeliveries int
+	redelivery      time.Duration
+	redeliveryMax   time.Duration
+	consumeTimeout  time.Duration
+	consumeCtx      context.Context
+	cancelConsume   context.CancelFunc
+	stop            chan struct{}
+	stopOnce        sync.Once
+	consuming       sync.WaitGroup
+	inFlight        atomic.Int64
+}
+
+func NewMemory(conf config.Queue, codecs *Codecs, log logger.Logger) *Memory {
+	memory := &Memory{
+		log:             log,
+		codecs:          codecs,
+		queues:          make(map[string]*memoryQueue),
+		routes:          make(map[string]bool),
+		subscribed:      make(map[string]bool),
+		maxRedeliveries: conf.MaxRedeliveries,
+		redelivery:      conf.RedeliveryDelay * time.Second,
+		redeliveryMax:   conf.RedeliveryMaxDelay * time.Second,
+		consumeTimeout:  conf.ConsumeTimeout * time.Second,
+		stop:            make(chan struct{}),
+	}
+	memory.consumeCtx, memory.cancelConsume = context.WithCancel(context.Background())
+
+	return memory
+}
+
+// WithRoutes the messages produced under these names are dropped until a consumer registers for them,
+// like a RabbitMQ exchange without a bound queue
+func (r *Memory) WithRoutes(names ...string) *Memory {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, name := range names {
+		r.routes[name] = true
+	}
+
+	return r
+}
+
+func (r *Memory) Close() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+	r.cancelConsume()
+}
+
+func (r *Memory) Shutdown(ctx context.Context) error {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+
+	drained := make(chan struct{})
+	go func() {
+		r.consuming.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+		r.log.Info(logger.Queue, logger.Shutdown, "All in-flight deliveries are drained", nil)
+	case <-ctx.Done():
+		err = fmt.Errorf("%d deliveries still in flight: %w", r.inFlight.Load(), ctx.Err())
+	}
+	r.cancelConsume()
+
+	return err
+}
+
+func (r *Memory) Produce(ctx context.Context, name string, envelope *domain.Envelope, delaySeconds int64) (err error) {
+	ctx, span, envelope := startProduceSpan(ctx, "memory", name, envelope)
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	codec := r.codecs.ForQueue(name)
+	message, err := codec.Marshal(envelope)
+	if err != nil {
+		r.log.WithContext(ctx).Error(logger.Queue, logger.RabbitMQProduce, fmt.Sprintf("Error marshalling value: %v", err), nil)
+		return err
+	}
+
+	r.mu.Lock()
+	if r.routes[name] && !r.subscribed[name] {
+		r.mu.Unlock()
+		return nil
+	}
+	queue := r.queue(name)
+	queue.messages = append(queue.messages, &memoryMessage{
+		id:              envelope.ID,
+		body:            message,
+		contentType:     codec.ContentType,
+		contentEncoding: codec.ContentEncoding,
+		availableAt:     time.Now().Add(time.Duration(delaySeconds) * time.Second),
+	})
+	r.mu.Unlock()
+
+	r.wake(queue)
+
+	return nil
+}
+
+func (r *Memory) RegisterConsumer(name string, callback func(ctx context.Context, envelope *domain.Envelope) error) error {
+	r.mu.Lock()
+	queue := r.queue(name)
+	r.subscribed[name] = true
+	r.mu.Unlock()
+
+	r.consuming.Add(1)
+	go r.consume(name, queue, callback)
+
+	return nil
+}
+
+// queue must be called with r.mu held
+func (r *Memory) queue(name string) *memoryQueue {
+	queue, ok := r.queues[name]
+	if !ok {
+		queue = &memoryQueue{notify: make(chan struct{}, 1)}
+		r.queues[name] = queue
+	}
+
+	return queue
+}
+
+func (r *Memory) wake(queue *memoryQueue) {
+	select {
+	case queue.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Memory) consume(name string, queue *memoryQueue, callback func(ctx context.Context, envelope *domain.Envelope) error) {
+	defer r.consuming.Done()
+
+	for {
+		message, wait := r.claim(queue)
+		if message != nil {
+			r.handle(name, queue, message, callback)
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-r.stop:
+			timer.Stop()
+			return
+		case <-queue.notify:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// claim removes the first message which is due, otherwise it returns how long to wait for the next one
+func (r *Memory) claim(queue *memoryQueue) (*memoryMessage, time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	select {
+	case <-r.stop:
+		return nil, 0
+	default:
+	}
+
+	now := time.Now()
+	wait := time.Hour
+	for i, message := range queue.messages {
+		if !message.availableAt.After(now) {
+			queue.messages = append(queue.messages[:i], queue.messages[i+1:]...)
+			return message, 0
+		}
+		if until := message.availableAt.Sub(now); until < wait {
+			wait = until
+		}
+	}
+
+	return nil, wait
+}
+
+// handle acks the message by dropping it, a failed message is redelivered after a growing delay
+// until it reaches the redelivery limit and then it is dead-lettered
+func (r *Memory) handle(name string, queue *memoryQueue, message *memoryMessage, callback func(ctx context.Context, envelope *domain.Envelope) error) {
+	r.inFlight.Add(1)
+	defer r.inFlight.Add(-1)
+
+	ctx, cancel := context.WithTimeout(r.consumeCtx, r.consumeTimeout)
+	defer cancel()
+
+	if message.deliveries > 0 {
+		countMessage(name, metrics.QueueRetried)
+	}
+
+	start := time.Now()
+	envelope, err := decode(message.body, message.contentType, message.contentEncoding)
+	ctx, span := startConsumeSpan(ctx, "memory", name, envelopeCarrier(envelope))
+	if err == nil {
+		span.SetAttributes(messageAttributes("memory", name, envelope)...)
+		err = callback(ctx, envelope)
+	}
+	observeHandler(name, start, err)
+	tracing.End(span, err)
+	if err == nil {
+		countMessage(name, metrics.QueueAcked)
+		return
+	}
+	countMessage(name, metrics.QueueNacked)
+
+	extra := map[logger.ExtraKey]interface{}{
+		logger.QueueName: name,
+		logger.MessageID: message.id,
+		logger.Body:      messageBody{message.body, message.contentType, message.contentEncoding},
+	}
+	r.log.WithContext(ctx).Error(logger.Queue, logger.RabbitMQConsume, fmt.Sprintf("Error Consume message: %v", err), extra)
+
+	r.mu.Lock()
+	message.deliveries++
+	if message.deliveries > r.maxRedeliveries {
+		queue.deadLetters = append(queue.deadLetters, message)
+		countMessage(name, metrics.QueueDeadLettered)
+	} else {
+		message.availableAt = time.Now().Add(redeliveryDelay(r.redelivery, r.redeliveryMax, message.deliveries))
+		queue.messages = append(queue.messages, message)
+	}
+	r.mu.Unlock()
+
+	// a consumer waiting for a later message picks up the new due time
+	r.wake(queue)
+}
+
+func (r *Memory) Health(_ context.Context) error {
+	return nil
+}
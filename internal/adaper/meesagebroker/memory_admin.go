@@ -0,0 +1,82 @@
+package messagebroker
+
+import (
+	"context"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+)
+
+// messages must be called with r.mu held, it returns the dead letters for a ".dlq" name
+func (r *Memory) messages(name string) *[]*memoryMessage {
+	base, deadLetter := splitDeadLetter(name)
+	queue := r.queue(base)
+	if deadLetter {
+		return &queue.deadLetters
+	}
+
+	return &queue.messages
+}
+
+func (r *Memory) Stats(_ context.Context, name string) (*domain.QueueStats, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return &domain.QueueStats{
+		Name:     name,
+		Messages: len(*r.messages(name)),
+	}, nil
+}
+
+func (r *Memory) Peek(_ context.Context, name string, limit int, filter domain.QueueFilter) ([]domain.QueueMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var messages []domain.QueueMessage
+	for _, message := range *r.messages(name) {
+		if len(messages) == limit {
+			break
+		}
+		if queueMessage := toQueueMessage(message.body, message.contentType, message.contentEncoding, message.deliveries); filter.Match(queueMessage) {
+			messages = append(messages, queueMessage)
+		}
+	}
+
+	return messages, nil
+}
+
+func (r *Memory) Purge(_ context.Context, name string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	messages := r.messages(name)
+	purged := len(*messages)
+	*messages = nil
+
+	return purged, nil
+}
+
+// Move resets the delivery count, so a message moved out of the dead letters gets all its retries again
+func (r *Memory) Move(_ context.Context, from string, to string, limit int, filter domain.QueueFilter) (int, error) {
+	r.mu.Lock()
+	source := r.messages(from)
+	target := r.messages(to)
+	base, _ := splitDeadLetter(to)
+	queue := r.queue(base)
+
+	var kept []*memoryMessage
+	var moved int
+	for _, message := range *source {
+		if (limit == 0 || moved < limit) && filter.Match(toQueueMessage(message.body, message.contentType, message.contentEncoding, message.deliveries)) {
+			message.deliveries = 0
+			*target = append(*target, message)
+			moved++
+			continue
+		}
+		kept = append(kept, message)
+	}
+	*source = kept
+	r.mu.Unlock()
+
+	r.wake(queue)
+
+	return moved, nil
+}
@@ -0,0 +1,244 @@
+package messagebroker
+
+import (
+	"context"
+	"errors"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/config"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testQueue = "test_queue"
+
+func newTestMemory(t *testing.T, maxRedeliveries int) *Memory {
+	t.Helper()
+
+	codecs, err := NewCodecs(config.Queue{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	memory := NewMemory(config.Queue{MaxRedeliveries: maxRedeliveries, ConsumeTimeout: 5}, codecs, nopLogger{})
+	memory.redelivery = 10 * time.Millisecond
+	memory.redeliveryMax = 40 * time.Millisecond
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = memory.Shutdown(ctx)
+	})
+
+	return memory
+}
+
+func newTestEnvelope(t *testing.T) *domain.Envelope {
+	t.Helper()
+
+	envelope, err := domain.NewEnvelope("test.event", 1, map[string]string{"name": "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return envelope
+}
+
+// deliveries records every delivery of the consumer, fail decides the outcome of the nth one
+type deliveries struct {
+	mu    sync.Mutex
+	at    []time.Time
+	ids   []string
+	fail  func(n int) bool
+	calls chan struct{}
+}
+
+func newDeliveries(fail func(n int) bool) *deliveries {
+	return &deliveries{fail: fail, calls: make(chan struct{}, 100)}
+}
+
+func (r *deliveries) callback(_ context.Context, envelope *domain.Envelope) error {
+	r.mu.Lock()
+	r.at = append(r.at, time.Now())
+	r.ids = append(r.ids, envelope.ID)
+	n := len(r.at)
+	r.mu.Unlock()
+
+	r.calls <- struct{}{}
+	if r.fail(n) {
+		return errors.New("handler failed")
+	}
+
+	return nil
+}
+
+func (r *deliveries) wait(t *testing.T, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.calls:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("got %d deliveries, want %d", i, n)
+		}
+	}
+}
+
+func TestMemoryProduceConsume(t *testing.T) {
+	memory := newTestMemory(t, 3)
+	envelope := newTestEnvelope(t)
+
+	if err := memory.Produce(context.Background(), testQueue, envelope, 0); err != nil {
+		t.Fatalf("Produce() error = %v", err)
+	}
+
+	consumer := newDeliveries(func(int) bool { return false })
+	if err := memory.RegisterConsumer(testQueue, consumer.callback); err != nil {
+		t.Fatalf("RegisterConsumer() error = %v", err)
+	}
+	consumer.wait(t, 1)
+
+	if consumer.ids[0] != envelope.ID {
+		t.Errorf("consumed message %s, want %s", consumer.ids[0], envelope.ID)
+	}
+
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	if left := len(memory.queues[testQueue].messages); left != 0 {
+		t.Errorf("%d messages left after the ack, want 0", left)
+	}
+}
+
+func TestMemoryDelayedMessageIsNotClaimedEarly(t *testing.T) {
+	memory := newTestMemory(t, 3)
+
+	if err := memory.Produce(context.Background(), testQueue, newTestEnvelope(t), 60); err != nil {
+		t.Fatalf("Produce() error = %v", err)
+	}
+
+	memory.mu.Lock()
+	queue := memory.queue(testQueue)
+	memory.mu.Unlock()
+
+	message, wait := memory.claim(queue)
+	if message != nil {
+		t.Fatal("claimed a message which is due in a minute")
+	}
+	if wait <= 59*time.Second || wait > time.Minute {
+		t.Errorf("wait = %s, want about a minute", wait)
+	}
+}
+
+func TestMemoryRedeliversWithDelay(t *testing.T) {
+	memory := newTestMemory(t, 3)
+	envelope := newTestEnvelope(t)
+
+	consumer := newDeliveries(func(n int) bool { return n == 1 })
+	if err := memory.RegisterConsumer(testQueue, consumer.callback); err != nil {
+		t.Fatalf("RegisterConsumer() error = %v", err)
+	}
+	if err := memory.Produce(context.Background(), testQueue, envelope, 0); err != nil {
+		t.Fatalf("Produce() error = %v", err)
+	}
+	consumer.wait(t, 2)
+
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	if consumer.ids[1] != envelope.ID {
+		t.Errorf("redelivered message %s, want %s", consumer.ids[1], envelope.ID)
+	}
+	// the first redelivery waits between half and the whole initial delay
+	if gap := consumer.at[1].Sub(consumer.at[0]); gap < memory.redelivery/2 {
+		t.Errorf("redelivered after %s, want at least %s", gap, memory.redelivery/2)
+	}
+}
+
+func TestMemoryDeadLettersAfterMaxRedeliveries(t *testing.T) {
+	const maxRedeliveries = 2
+	memory := newTestMemory(t, maxRedeliveries)
+	envelope := newTestEnvelope(t)
+
+	consumer := newDeliveries(func(int) bool { return true })
+	if err := memory.RegisterConsumer(testQueue, consumer.callback); err != nil {
+		t.Fatalf("RegisterConsumer() error = %v", err)
+	}
+	if err := memory.Produce(context.Background(), testQueue, envelope, 0); err != nil {
+		t.Fatalf("Produce() error = %v", err)
+	}
+	consumer.wait(t, maxRedeliveries+1)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		memory.mu.Lock()
+		queue := memory.queues[testQueue]
+		dead, left := len(queue.deadLetters), len(queue.messages)
+		memory.mu.Unlock()
+
+		if dead == 1 && left == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("dead letters = %d, messages = %d, want 1 and 0", dead, left)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	select {
+	case <-consumer.calls:
+		t.Error("dead-lettered message was delivered again")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRedeliveryDelayGrowsUpToMax(t *testing.T) {
+	initial, maxDelay := 100*time.Millisecond, 400*time.Millisecond
+
+	tests := []struct {
+		deliveries int
+		min        time.Duration
+		max        time.Duration
+	}{
+		{deliveries: 1, min: 50 * time.Millisecond, max: 100 * time.Millisecond},
+		{deliveries: 2, min: 100 * time.Millisecond, max: 200 * time.Millisecond},
+		{deliveries: 3, min: 200 * time.Millisecond, max: 400 * time.Millisecond},
+		{deliveries: 10, min: 200 * time.Millisecond, max: 400 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if delay := redeliveryDelay(initial, maxDelay, tt.deliveries); delay < tt.min || delay > tt.max {
+				t.Errorf("redeliveryDelay(%d) = %s, want between %s and %s", tt.deliveries, delay, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestMemoryRouteKeepsMessagesForSubscribersOnly(t *testing.T) {
+	memory := newTestMemory(t, 3).WithRoutes(testQueue)
+
+	if err := memory.Produce(context.Background(), testQueue, newTestEnvelope(t), 0); err != nil {
+		t.Fatalf("Produce() error = %v", err)
+	}
+
+	consumer := newDeliveries(func(int) bool { return false })
+	if err := memory.RegisterConsumer(testQueue, consumer.callback); err != nil {
+		t.Fatalf("RegisterConsumer() error = %v", err)
+	}
+
+	envelope := newTestEnvelope(t)
+	if err := memory.Produce(context.Background(), testQueue, envelope, 0); err != nil {
+		t.Fatalf("Produce() error = %v", err)
+	}
+	consumer.wait(t, 1)
+
+	select {
+	case <-consumer.calls:
+		t.Error("message produced before the subscription was delivered")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	consumer.mu.Lock()
+	defer consumer.mu.Unlock()
+	if consumer.ids[0] != envelope.ID {
+		t.Errorf("consumed message %s, want %s", consumer.ids[0], envelope.ID)
+	}
+}
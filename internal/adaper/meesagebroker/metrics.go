@@ -0,0 +1,21 @@
+package messagebroker
+
+import (
+	"github.com/mohsenabedy91/Sikabiz/pkg/metrics"
+	"time"
+)
+
+// observeHandler records a consumed message and how long its handler took
+func observeHandler(queue string, start time.Time, err error) {
+	status := "Success"
+	if err != nil {
+		status = "Failed"
+	}
+
+	metrics.QueueMessage.WithLabelValues(queue, metrics.QueueConsumed).Inc()
+	metrics.QueueHandlerDuration.WithLabelValues(queue, status).Observe(float64(time.Since(start) / time.Millisecond))
+}
+
+func countMessage(queue string, outcome string) {
+	metrics.QueueMessage.WithLabelValues(queue, outcome).Inc()
+}
@@ -0,0 +1,370 @@
+package messagebroker
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/config"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"github.com/mohsenabedy91/Sikabiz/pkg/metrics"
+	"github.com/mohsenabedy91/Sikabiz/pkg/tracing"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	queueMessagesChannel = "queue_messages"
+	// postgresPollInterval is the longest a consumer sleeps, it covers notifications lost while the listener reconnects
+	postgresPollInterval = 5 * time.Second
+	// postgresMinWait is the shortest a consumer sleeps, a due message which another consumer is
+	// claiming right now is skipped by the claim but still counted by nextWait
+	postgresMinWait = 100 * time.Millisecond
+)
+
+// Postgres stores the messages in the queue_messages table, a claimed message stays invisible
+// for the visibility timeout, so a consumer which dies mid-delivery does not lose it
+type Postgres struct {
+	log               logger.Logger
+	codecs            *Codecs
+	db                *sql.DB
+	listener          *pq.Listener
+	maxRedeliveries   int
+	redelivery        time.Duration
+	redeliveryMax     time.Duration
+	consumeTimeout    time.Duration
+	visibilityTimeout time.Duration
+	consumeCtx        context.Context
+	cancelConsume     context.CancelFunc
+	stop              chan struct{}
+	stopOnce          sync.Once
+	consuming         sync.WaitGroup
+	inFlight          atomic.Int64
+	waitersLock       sync.Mutex
+	waiters           map[string][]chan struct{}
+	routes            map[string]bool
+}
+
+func NewPostgres(conf config.Queue, codecs *Codecs, dsn string, db *sql.DB, log logger.Logger) (*Postgres, error) {
+	driver := &Postgres{
+		log:               log,
+		codecs:            codecs,
+		db:                db,
+		maxRedeliveries:   conf.MaxRedeliveries,
+		redelivery:        conf.RedeliveryDelay * time.Second,
+		redeliveryMax:     conf.RedeliveryMaxDelay * time.Second,
+		consumeTimeout:    conf.ConsumeTimeout * time.Second,
+		visibilityTimeout: 2 * conf.ConsumeTimeout * time.Second,
+		stop:              make(chan struct{}),
+		waiters:           make(map[string][]chan struct{}),
+		routes:            make(map[string]bool),
+	}
+	driver.consumeCtx, driver.cancelConsume = context.WithCancel(context.Background())
+
+	driver.listener = pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Error(logger.Queue, logger.Postgres, fmt.Sprintf("Queue listener error: %v", err), nil)
+		}
+		if event == pq.ListenerEventReconnected {
+			metrics.QueueReconnect.WithLabelValues("postgres").Inc()
+		}
+	})
+	if err := driver.listener.Listen(queueMessagesChannel); err != nil {
+		_ = driver.listener.Close()
+		return nil, err
+	}
+
+	go driver.dispatchNotifications()
+
+	return driver, nil
+}
+
+// WithRoutes the messages produced under these names are only stored once a consumer subscribed to
+// them in queue_subscriptions, like a RabbitMQ exchange without a bound queue, it is called before use
+func (r *Postgres) WithRoutes(names ...string) *Postgres {
+	for _, name := range names {
+		r.routes[name] = true
+	}
+
+	return r
+}
+
+func (r *Postgres) Close() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+	r.cancelConsume()
+
+	if err := r.listener.Close(); err != nil {
+		r.log.Error(logger.Queue, logger.Postgres, err.Error(), nil)
+	}
+}
+
+// Shutdown leaves the database pool open, the caller closes it after the drain
+func (r *Postgres) Shutdown(ctx context.Context) error {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+
+	drained := make(chan struct{})
+	go func() {
+		r.consuming.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+		r.log.Info(logger.Queue, logger.Shutdown, "All in-flight deliveries are drained", nil)
+	case <-ctx.Done():
+		err = fmt.Errorf("%d deliveries still in flight: %w", r.inFlight.Load(), ctx.Err())
+	}
+
+	r.Close()
+
+	return err
+}
+
+func (r *Postgres) Produce(ctx context.Context, name string, envelope *domain.Envelope, delaySeconds int64) (err error) {
+	ctx, span, envelope := startProduceSpan(ctx, "postgres", name, envelope)
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	codec := r.codecs.ForQueue(name)
+	message, err := codec.Marshal(envelope)
+	if err != nil {
+		r.log.WithContext(ctx).Error(logger.Queue, logger.PostgresProduce, fmt.Sprintf("Error marshalling value: %v", err), nil)
+		return err
+	}
+
+	if _, err = r.db.ExecContext(
+		ctx,
+		`INSERT INTO queue_messages (uuid, queue, type, body, content_type, content_encoding, available_at)
+				SELECT $1, $2, $3, $4, $5, $6, now() + make_interval(secs => $7)
+				WHERE NOT $8 OR EXISTS (SELECT 1 FROM queue_subscriptions WHERE queue = $2)`,
+		envelope.ID,
+		name,
+		envelope.Type,
+		message,
+		codec.ContentType,
+		codec.ContentEncoding,
+		delaySeconds,
+		r.routes[name],
+	); err != nil {
+		r.log.WithContext(ctx).Error(logger.Queue, logger.PostgresProduce, fmt.Sprintf("Error insert message: %v", err), map[logger.ExtraKey]interface{}{
+			logger.QueueName: name,
+			logger.MessageID: envelope.ID,
+		})
+		return err
+	}
+
+	return nil
+}
+
+// RegisterConsumer subscribes to a route, the subscription outlives the consumer like a durable queue
+func (r *Postgres) RegisterConsumer(name string, callback func(ctx context.Context, envelope *domain.Envelope) error) error {
+	if r.routes[name] {
+		if _, err := r.db.Exec(`INSERT INTO queue_subscriptions (queue) VALUES ($1) ON CONFLICT DO NOTHING`, name); err != nil {
+			r.log.Error(logger.Queue, logger.PostgresConsume, fmt.Sprintf("Error subscribe to route: %v", err), map[logger.ExtraKey]interface{}{
+				logger.QueueName: name,
+			})
+			return err
+		}
+	}
+
+	wake := make(chan struct{}, 1)
+
+	r.waitersLock.Lock()
+	r.waiters[name] = append(r.waiters[name], wake)
+	r.waitersLock.Unlock()
+
+	r.consuming.Add(1)
+	go r.consume(name, wake, callback)
+
+	return nil
+}
+
+// dispatchNotifications wakes the consumers of the queue named in the payload,
+// a nil notification means the listener reconnected and every consumer is woken
+func (r *Postgres) dispatchNotifications() {
+	for {
+		select {
+		case <-r.stop:
+			return
+		case notification, ok := <-r.listener.Notify:
+			if !ok {
+				return
+			}
+
+			r.waitersLock.Lock()
+			for name, waiters := range r.waiters {
+				if notification != nil && notification.Extra != name {
+					continue
+				}
+				for _, wake := range waiters {
+					select {
+					case wake <- struct{}{}:
+					default:
+					}
+				}
+			}
+			r.waitersLock.Unlock()
+		}
+	}
+}
+
+func (r *Postgres) consume(name string, wake chan struct{}, callback func(ctx context.Context, envelope *domain.Envelope) error) {
+	defer r.consuming.Done()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+
+		message, err := r.claim(name)
+		if err == nil {
+			r.handle(name, message, callback)
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			r.log.Error(logger.Queue, logger.PostgresConsume, fmt.Sprintf("Error claim message: %v", err), map[logger.ExtraKey]interface{}{
+				logger.QueueName: name,
+			})
+		}
+
+		timer := time.NewTimer(r.nextWait(name))
+		select {
+		case <-r.stop:
+			timer.Stop()
+			return
+		case <-wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+type postgresMessage struct {
+	id              uint64
+	body            []byte
+	contentType     string
+	contentEncoding string
+	attempts        int
+}
+
+// claim takes the oldest due message and hides it for the visibility timeout,
+// SKIP LOCKED lets concurrent consumers claim different messages
+func (r *Postgres) claim(name string) (*postgresMessage, error) {
+	var message postgresMessage
+	err := r.db.QueryRowContext(
+		r.consumeCtx,
+		`UPDATE queue_messages SET attempts = attempts + 1, available_at = now() + make_interval(secs => $2)
+				WHERE id = (
+					SELECT id FROM queue_messages
+					WHERE queue = $1 AND dead_at IS NULL AND available_at <= now()
+					ORDER BY available_at, id
+					FOR UPDATE SKIP LOCKED
+					LIMIT 1
+				)
+				RETURNING id, body, content_type, content_encoding, attempts`,
+		name,
+		r.visibilityTimeout.Seconds(),
+	).Scan(&message.id, &message.body, &message.contentType, &message.contentEncoding, &message.attempts)
+
+	return &message, err
+}
+
+// nextWait returns the time until the next delayed message is due, between the min wait and the poll interval
+func (r *Postgres) nextWait(name string) time.Duration {
+	var wait sql.NullFloat64
+	if err := r.db.QueryRowContext(
+		r.consumeCtx,
+		`SELECT EXTRACT(EPOCH FROM min(available_at) - now()) FROM queue_messages WHERE queue = $1 AND dead_at IS NULL`,
+		name,
+	).Scan(&wait); err != nil || !wait.Valid {
+		return postgresPollInterval
+	}
+
+	next := time.Duration(wait.Float64 * float64(time.Second))
+	if next < postgresMinWait {
+		return postgresMinWait
+	}
+	if next > postgresPollInterval {
+		return postgresPollInterval
+	}
+
+	return next
+}
+
+// handle deletes an acked message, a failed message becomes visible again after the redelivery
+// delay until it reaches the redelivery limit and then it is dead-lettered
+func (r *Postgres) handle(name string, message *postgresMessage, callback func(ctx context.Context, envelope *domain.Envelope) error) {
+	r.inFlight.Add(1)
+	defer r.inFlight.Add(-1)
+
+	extra := map[logger.ExtraKey]interface{}{
+		logger.QueueName: name,
+		logger.Body:      messageBody{message.body, message.contentType, message.contentEncoding},
+	}
+
+	ctx, cancel := context.WithTimeout(r.consumeCtx, r.consumeTimeout)
+	defer cancel()
+
+	if message.attempts > 1 {
+		countMessage(name, metrics.QueueRetried)
+	}
+
+	start := time.Now()
+	envelope, err := decode(message.body, message.contentType, message.contentEncoding)
+	ctx, span := startConsumeSpan(ctx, "postgres", name, envelopeCarrier(envelope))
+	if err == nil {
+		span.SetAttributes(messageAttributes("postgres", name, envelope)...)
+		err = callback(ctx, envelope)
+	}
+	observeHandler(name, start, err)
+	tracing.End(span, err)
+	if err != nil {
+		r.log.WithContext(ctx).Error(logger.Queue, logger.PostgresConsume, fmt.Sprintf("Error Consume message: %v", err), extra)
+
+		// the consumer context may be cancelled already, the outcome is still recorded
+		var dead bool
+		if nackErr := r.db.QueryRowContext(
+			context.Background(),
+			`UPDATE queue_messages
+					SET last_error = $2,
+					    available_at = now() + make_interval(secs => $4),
+					    dead_at = CASE WHEN attempts > $3 THEN now() END
+					WHERE id = $1
+					RETURNING dead_at IS NOT NULL`,
+			message.id,
+			err.Error(),
+			r.maxRedeliveries,
+			redeliveryDelay(r.redelivery, r.redeliveryMax, message.attempts).Seconds(),
+		).Scan(&dead); nackErr != nil {
+			r.log.WithContext(ctx).Error(logger.Queue, logger.PostgresConsume, fmt.Sprintf("Error Nack Consume message: %v", nackErr), extra)
+			return
+		}
+
+		countMessage(name, metrics.QueueNacked)
+		if dead {
+			countMessage(name, metrics.QueueDeadLettered)
+		}
+		return
+	}
+
+	if _, err = r.db.ExecContext(context.Background(), `DELETE FROM queue_messages WHERE id = $1`, message.id); err != nil {
+		r.log.WithContext(ctx).Error(logger.Queue, logger.PostgresConsume, fmt.Sprintf("Error Ack Consume message: %v", err), extra)
+		return
+	}
+	countMessage(name, metrics.QueueAcked)
+}
+
+func (r *Postgres) Health(_ context.Context) error {
+	return r.listener.Ping()
+}
@@ -0,0 +1,134 @@
+package messagebroker
+
+import (
+	"context"
+	"database/sql"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+)
+
+// deadCondition selects the live messages of a queue or its dead letters for a ".dlq" name
+func deadCondition(name string) (string, string) {
+	base, deadLetter := splitDeadLetter(name)
+	if deadLetter {
+		return base, "dead_at IS NOT NULL"
+	}
+
+	return base, "dead_at IS NULL"
+}
+
+func (r *Postgres) Stats(ctx context.Context, name string) (*domain.QueueStats, error) {
+	base, condition := deadCondition(name)
+
+	stats := domain.QueueStats{Name: name}
+	if err := r.db.QueryRowContext(
+		ctx,
+		`SELECT count(*) FROM queue_messages WHERE queue = $1 AND `+condition,
+		base,
+	).Scan(&stats.Messages); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// filterCondition matches the type column and searches the stored body, a compressed body is not searched
+const filterCondition = `($2 = '' OR type = $2) AND ($3 = '' OR position(convert_to($3, 'UTF8') in body) > 0)`
+
+func (r *Postgres) Peek(ctx context.Context, name string, limit int, filter domain.QueueFilter) ([]domain.QueueMessage, error) {
+	base, condition := deadCondition(name)
+
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT body, content_type, content_encoding, attempts FROM queue_messages
+				WHERE queue = $1 AND `+condition+` AND `+filterCondition+`
+				ORDER BY available_at, id LIMIT $4`,
+		base,
+		filter.Type,
+		filter.Contains,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var messages []domain.QueueMessage
+	for rows.Next() {
+		var body []byte
+		var contentType, contentEncoding string
+		var attempts int
+		if err = rows.Scan(&body, &contentType, &contentEncoding, &attempts); err != nil {
+			return nil, err
+		}
+		messages = append(messages, toQueueMessage(body, contentType, contentEncoding, attempts))
+	}
+
+	return messages, rows.Err()
+}
+
+func (r *Postgres) Purge(ctx context.Context, name string) (int, error) {
+	base, condition := deadCondition(name)
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM queue_messages WHERE queue = $1 AND `+condition, base)
+	if err != nil {
+		return 0, err
+	}
+
+	purged, err := result.RowsAffected()
+	return int(purged), err
+}
+
+// Move resets the attempts, so a message moved out of the dead letters gets all its retries again,
+// only the moved rows are locked, the ones a consumer holds are skipped
+func (r *Postgres) Move(ctx context.Context, from string, to string, limit int, filter domain.QueueFilter) (int, error) {
+	fromBase, condition := deadCondition(from)
+	toBase, toDead := splitDeadLetter(to)
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	result, err := tx.ExecContext(
+		ctx,
+		`UPDATE queue_messages
+				SET queue = $5,
+				    attempts = 0,
+				    last_error = NULL,
+				    available_at = now(),
+				    dead_at = CASE WHEN $6 THEN now() END
+				WHERE id IN (
+				    SELECT id FROM queue_messages
+				    WHERE queue = $1 AND `+condition+` AND `+filterCondition+`
+				    ORDER BY available_at, id
+				    LIMIT NULLIF($4, 0)
+				    FOR UPDATE SKIP LOCKED
+				)`,
+		fromBase,
+		filter.Type,
+		filter.Contains,
+		limit,
+		toBase,
+		toDead,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	moved, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	// the insert trigger does not fire on update, so the consumers are woken here
+	if _, err = tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, queueMessagesChannel, toBase); err != nil {
+		return 0, err
+	}
+
+	return int(moved), tx.Commit()
+}
@@ -10,6 +10,8 @@ type Queue struct {
 	Log    logger.Logger
 	Config config.Config
 	Driver port.Driver
+	// Scheduler is nil in the binaries without a database
+	Scheduler port.Scheduler
 }
 
 func NewQueue(log logger.Logger, config config.Config) *Queue {
@@ -18,9 +20,3 @@ func NewQueue(log logger.Logger, config config.Config) *Queue {
 		Config: config,
 	}
 }
-
-func RegisterEvents(events ...port.Event) {
-	for _, event := range events {
-		event.Register()
-	}
-}
@@ -1,133 +1,552 @@
 package messagebroker
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"github.com/google/uuid"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/config"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
 	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"github.com/mohsenabedy91/Sikabiz/pkg/metrics"
+	"github.com/mohsenabedy91/Sikabiz/pkg/tracing"
 	amqp "github.com/rabbitmq/amqp091-go"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// queueExchange the queues are bound to it by their names, the delayed messages are kept by the scheduler,
+	// so no exchange of the delayed message plugin is needed
+	queueExchange = "queue_exchange"
+	// flushBatch is how many buffered messages are published between two looks into the buffer
+	flushBatch = 100
+)
+
 type RabbitMQ struct {
-	conn         *amqp.Connection
-	log          logger.Logger
-	url          string
-	notifyClose  chan *amqp.Error
-	mu           sync.Mutex
-	consumers    map[string]func(message []byte) error
-	consumerLock sync.Mutex
+	conn              *amqp.Connection
+	log               logger.Logger
+	url               string
+	managementURL     string
+	confirmTimeout    time.Duration
+	reconnectInterval time.Duration
+	reconnectMax      time.Duration
+	notifyClose       chan *amqp.Error
+	mu                sync.RWMutex
+	state             atomic.Int32
+	done              chan struct{}
+	doneOnce          sync.Once
+	buffer            produceBuffer
+	bufferLock        sync.Mutex
+	flushLock         sync.Mutex
+	flushing          atomic.Bool
+	retryingFlush     atomic.Bool
+	flushTimeout      time.Duration
+	flushCtx          context.Context
+	cancelFlush       context.CancelFunc
+	closeOnce         sync.Once
+	channels          *channelPool
+	topology          *Topology
+	codecs            *Codecs
+	scheduler         port.Scheduler
+	declared          sync.Map
+	topologyLock      sync.Mutex
+	consumers         map[string]func(ctx context.Context, envelope *domain.Envelope) error
+	consumerTags      map[string]*amqp.Channel
+	consumerLock      sync.Mutex
+	consuming         sync.WaitGroup
+	consumeTimeout    time.Duration
+	consumeCtx        context.Context
+	cancelConsume     context.CancelFunc
+	inFlight          atomic.Int64
+	closing           atomic.Bool
 }
 
-func NewRabbitMQ(url string, log logger.Logger) (*RabbitMQ, error) {
-	conn, err := amqp.Dial(url)
+// NewRabbitMQ topology may be nil, then every queue gets the default topology
+func NewRabbitMQ(conf config.Config, topology *Topology, codecs *Codecs, log logger.Logger) (*RabbitMQ, error) {
+	buffer, err := newProduceBuffer(conf.RabbitMQ)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := amqp.Dial(conf.RabbitMQ.URL)
 	if err != nil {
 		return nil, err
 	}
 
 	rmq := &RabbitMQ{
-		conn:        conn,
-		log:         log,
-		url:         url,
-		notifyClose: conn.NotifyClose(make(chan *amqp.Error)),
-		consumers:   make(map[string]func(message []byte) error),
+		conn:              conn,
+		log:               log,
+		url:               conf.RabbitMQ.URL,
+		managementURL:     conf.RabbitMQ.ManagementURL,
+		confirmTimeout:    conf.RabbitMQ.ConfirmTimeout * time.Second,
+		reconnectInterval: conf.RabbitMQ.ReconnectInterval * time.Second,
+		reconnectMax:      conf.RabbitMQ.ReconnectMax * time.Second,
+		flushTimeout:      conf.RabbitMQ.FlushTimeout * time.Second,
+		topology:          topology,
+		codecs:            codecs,
+		notifyClose:       conn.NotifyClose(make(chan *amqp.Error, 1)),
+		done:              make(chan struct{}),
+		buffer:            buffer,
+		consumers:         make(map[string]func(ctx context.Context, envelope *domain.Envelope) error),
+		consumerTags:      make(map[string]*amqp.Channel),
 	}
+	rmq.channels = newChannelPool(conf.RabbitMQ.PublishChannels, rmq.connection)
+	rmq.consumeTimeout = conf.Queue.ConsumeTimeout * time.Second
+	rmq.consumeCtx, rmq.cancelConsume = context.WithCancel(context.Background())
+	rmq.flushCtx, rmq.cancelFlush = context.WithCancel(context.Background())
+
+	// the disk buffer may hold the messages of a previous run
+	rmq.state.Store(int32(StateReconnecting))
+	rmq.flushBuffer(rmq.flushCtx)
 
 	go rmq.handleReconnect()
 
 	return rmq, nil
 }
 
+// State is read by the health check, the producers buffer their messages while it is not connected
+func (r *RabbitMQ) State() ConnectionState {
+	return ConnectionState(r.state.Load())
+}
+
+// Close flushes the buffer until the flush timeout, the messages left stay in the disk buffer for the
+// next run and are lost with the memory buffer, CloseBuffered hands them to the caller instead
 func (r *RabbitMQ) Close() {
-	if err := r.conn.Close(); err != nil {
-		r.log.Error(logger.Queue, logger.RabbitMQ, err.Error(), nil)
-	}
+	r.close(false)
+}
+
+// CloseBuffered closes the driver like Close and takes the messages the final flush could not publish
+// out of the buffer, the producers got nil for them, so the caller keeps them elsewhere
+func (r *RabbitMQ) CloseBuffered() []domain.UnpublishedMessage {
+	return r.close(true)
+}
+
+func (r *RabbitMQ) close(take bool) (unpublished []domain.UnpublishedMessage) {
+	r.closeOnce.Do(func() {
+		r.closing.Store(true)
+		r.state.Store(int32(StateClosed))
+		r.doneOnce.Do(func() {
+			close(r.done)
+		})
+
+		// a flush started by a producer gives up, the final one below runs with its own deadline
+		r.cancelFlush()
+		if r.buffer != nil {
+			unpublished = r.drain(take)
+		}
+
+		r.channels.reset()
+		if err := r.connection().Close(); err != nil {
+			r.log.Error(logger.Queue, logger.RabbitMQ, err.Error(), nil)
+		}
+	})
+
+	return unpublished
 }
 
-func (r *RabbitMQ) Produce(name string, msg interface{}, delaySeconds int64) error {
-	message, err := json.Marshal(msg)
+// drain is the final flush, the messages it could not publish are returned when take is set
+func (r *RabbitMQ) drain(take bool) []domain.UnpublishedMessage {
+	ctx, cancel := context.WithTimeout(context.Background(), r.flushTimeout)
+	defer cancel()
+
+	r.flushLock.Lock()
+	defer r.flushLock.Unlock()
+
+	_ = r.flush(ctx)
+
+	r.bufferLock.Lock()
+	defer r.bufferLock.Unlock()
+
+	left := r.buffer.len()
+	if left == 0 {
+		return nil
+	}
+
+	if !take {
+		r.log.Error(logger.Queue, logger.RabbitMQBuffer, "Closed with buffered messages which are not published", map[logger.ExtraKey]interface{}{
+			logger.MessageCount: left,
+		})
+		return nil
+	}
+
+	messages, err := r.buffer.peek(left)
+	if err == nil {
+		err = r.buffer.drop(len(messages))
+	}
 	if err != nil {
-		r.log.Error(logger.Queue, logger.RabbitMQProduce, fmt.Sprintf("Error marshalling value: %v", err), nil)
+		r.log.Error(logger.Queue, logger.RabbitMQBuffer, fmt.Sprintf("Error take buffered messages: %v", err), map[logger.ExtraKey]interface{}{
+			logger.MessageCount: left,
+		})
+		return nil
+	}
+
+	unpublished := make([]domain.UnpublishedMessage, 0, len(messages))
+	for _, message := range messages {
+		unpublished = append(unpublished, domain.UnpublishedMessage{
+			Queue:    message.Queue,
+			Envelope: message.Envelope,
+		})
+	}
+
+	return unpublished
+}
+
+// Shutdown cancels the consumers, so no new deliveries arrive, waits until the in-flight
+// handlers are finished or ctx is done and then closes the channels and the connection
+func (r *RabbitMQ) Shutdown(ctx context.Context) error {
+	r.closing.Store(true)
+
+	r.consumerLock.Lock()
+	for tag, channel := range r.consumerTags {
+		if err := channel.Cancel(tag, false); err != nil {
+			r.log.Error(logger.Queue, logger.Shutdown, fmt.Sprintf("Error cancel consumer %s: %v", tag, err), nil)
+		}
+	}
+	r.consumerLock.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		r.consuming.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+		r.log.Info(logger.Queue, logger.Shutdown, "All in-flight deliveries are drained", nil)
+	case <-ctx.Done():
+		err = fmt.Errorf("%d deliveries still in flight: %w", r.inFlight.Load(), ctx.Err())
+	}
+	// the handlers which are still running give up, so their transactions are rolled back
+	r.cancelConsume()
+
+	r.consumerLock.Lock()
+	for tag, channel := range r.consumerTags {
+		if !channel.IsClosed() {
+			if closeErr := channel.Close(); closeErr != nil {
+				r.log.Error(logger.Queue, logger.Shutdown, fmt.Sprintf("Error close consumer channel %s: %v", tag, closeErr), nil)
+			}
+		}
+		delete(r.consumerTags, tag)
+	}
+	r.consumerLock.Unlock()
+
+	r.Close()
+
+	return err
+}
+
+// WithScheduler the delayed messages are kept by the scheduler until they are due, a delayed message
+// is refused without it, it is called before use
+func (r *RabbitMQ) WithScheduler(scheduler port.Scheduler) *RabbitMQ {
+	r.scheduler = scheduler
+
+	return r
+}
+
+// Produce keeps the message in the buffer, when one is configured, while the connection is down
+// or older messages are still waiting in it, so the messages reach the broker in order, once the
+// driver is closed the message is refused
+func (r *RabbitMQ) Produce(ctx context.Context, name string, envelope *domain.Envelope, delaySeconds int64) (err error) {
+	ctx, span, envelope := startProduceSpan(ctx, "rabbitmq", name, envelope)
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	if delaySeconds > 0 {
+		if r.scheduler == nil {
+			return ErrSchedulerNotAvailable
+		}
+		_, err = r.scheduler.Schedule(ctx, name, envelope, time.Now().Add(time.Duration(delaySeconds)*time.Second))
 		return err
 	}
 
-	extra := map[logger.ExtraKey]interface{}{
-		logger.QueueName: name,
-		logger.Body:      message,
+	return r.produce(ctx, name, envelope)
+}
+
+func (r *RabbitMQ) produce(ctx context.Context, name string, envelope *domain.Envelope) error {
+	if r.buffer == nil {
+		return r.publish(ctx, name, envelope)
 	}
 
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	r.bufferLock.Lock()
+	if state := r.State(); state == StateClosed {
+		r.bufferLock.Unlock()
+		return &PublishError{Queue: name, MessageID: envelope.ID, Reason: amqp.ErrClosed}
+	} else if state != StateConnected || r.buffer.len() > 0 {
+		defer r.bufferLock.Unlock()
+		if err := r.bufferMessage(name, envelope); err != nil {
+			return err
+		}
+
+		// a failed flush left the older messages behind while the connection is up
+		if (state == StateConnected || state == StateDegraded) && r.flushing.CompareAndSwap(false, true) {
+			go func() {
+				defer r.flushing.Store(false)
+				r.flushBuffer(r.flushCtx)
+			}()
+		}
+		return nil
+	}
+	r.bufferLock.Unlock()
+
+	err := r.publish(ctx, name, envelope)
+	if errors.Is(err, amqp.ErrClosed) && r.State() != StateClosed {
+		// the connection dropped before the reconnect loop noticed it
+		r.bufferLock.Lock()
+		defer r.bufferLock.Unlock()
+		return r.bufferMessage(name, envelope)
+	}
+
+	return err
+}
+
+func (r *RabbitMQ) publish(ctx context.Context, name string, envelope *domain.Envelope) error {
+	headers := amqp.Table{
+		"x-message-version": envelope.Version,
+	}
+	// the trace context travels in the message headers instead of the body
+	if envelope.Headers != nil {
+		for key, value := range envelope.Headers {
+			headers[key] = value
+		}
+		plain := *envelope
+		plain.Headers = nil
+		envelope = &plain
+	}
 
-	channel, err := r.conn.Channel()
+	codec := r.codecs.ForQueue(name)
+	message, err := codec.Marshal(envelope)
 	if err != nil {
-		r.log.Error(logger.Queue, logger.RabbitMQProduce, fmt.Sprintf("Error create channel: %v", err), extra)
+		r.log.WithContext(ctx).Error(logger.Queue, logger.RabbitMQProduce, fmt.Sprintf("Error marshalling value: %v", err), nil)
 		return err
 	}
 
-	defer func(ch *amqp.Channel) {
-		if err = ch.Close(); err != nil {
-			r.log.Error(logger.Queue, logger.RabbitMQProduce, fmt.Sprintf("Error closing channel: %v", err), extra)
-		}
-	}(channel)
+	extra := map[logger.ExtraKey]interface{}{
+		logger.QueueName: name,
+		logger.MessageID: envelope.ID,
+		logger.Body:      messageBody{message, codec.ContentType, codec.ContentEncoding},
+	}
 
-	if err = channel.ExchangeDeclare(
-		"delayed_exchange",
-		"x-delayed-message",
-		true,
-		false,
-		false,
-		false,
-		amqp.Table{"x-delayed-type": "direct"},
-	); err != nil {
-		r.log.Error(logger.Queue, logger.RabbitMQProduce, fmt.Sprintf("Error ExchangeDeclare: %v", err), extra)
+	if err = r.declareTopology(name); err != nil {
+		r.log.WithContext(ctx).Error(logger.Queue, logger.RabbitMQProduce, fmt.Sprintf("Error declare topology: %v", err), extra)
 		return err
 	}
 
-	queueDeclare, err := channel.QueueDeclare(
-		name,
-		true,
-		false,
-		false,
+	ch, err := r.channels.get(ctx)
+	if err != nil {
+		r.log.WithContext(ctx).Error(logger.Queue, logger.RabbitMQProduce, fmt.Sprintf("Error create channel: %v", err), extra)
+		return err
+	}
+	defer r.channels.put(ch)
+
+	exchange, routingKey := r.topology.Route(name)
+	confirmation, err := ch.channel.PublishWithDeferredConfirmWithContext(
+		ctx,
+		exchange,
+		routingKey,
+		r.topology.Mandatory(name),
 		false,
-		nil,
+		amqp.Publishing{
+			ContentType:     codec.ContentType,
+			ContentEncoding: codec.ContentEncoding,
+			MessageId:       envelope.ID,
+			CorrelationId:   envelope.CorrelationID,
+			Type:            envelope.Type,
+			Timestamp:       envelope.Timestamp,
+			Body:            message,
+			Headers:         headers,
+			DeliveryMode:    amqp.Persistent,
+		},
 	)
 	if err != nil {
-		r.log.Error(logger.Queue, logger.RabbitMQProduce, fmt.Sprintf("Error QueueDeclare: %v", err), extra)
+		r.log.WithContext(ctx).Error(logger.Queue, logger.RabbitMQProduce, fmt.Sprintf("Error Publish message: %v", err), extra)
 		return err
 	}
 
-	if err = channel.QueueBind(
-		queueDeclare.Name,
-		queueDeclare.Name,
-		"delayed_exchange",
-		false,
-		nil,
-	); err != nil {
-		r.log.Error(logger.Queue, logger.RabbitMQProduce, fmt.Sprintf("Error QueueBind: %v", err), extra)
+	if err = r.waitConfirmation(ctx, confirmation, ch.returns); err != nil {
+		r.log.WithContext(ctx).Error(logger.Queue, logger.RabbitMQProduce, fmt.Sprintf("Error confirm message: %v", err), extra)
+		if errors.Is(err, ErrPublishTimeout) {
+			// a late confirmation or return would be read by the next producer
+			_ = ch.channel.Close()
+		}
+		return &PublishError{
+			Queue:     name,
+			MessageID: envelope.ID,
+			Reason:    err,
+		}
+	}
+
+	return nil
+}
+
+// declareTopology declares the part of the topology a queue needs once per queue name,
+// the declared names are forgotten after a reconnect
+func (r *RabbitMQ) declareTopology(name string) error {
+	if _, ok := r.declared.Load(name); ok {
+		return nil
+	}
+
+	r.topologyLock.Lock()
+	defer r.topologyLock.Unlock()
+
+	if _, ok := r.declared.Load(name); ok {
+		return nil
+	}
+
+	if err := r.ApplyTopology(r.topology.ForQueue(name)); err != nil {
 		return err
 	}
 
-	if err = channel.Publish(
-		"delayed_exchange",
-		queueDeclare.Name,
-		false,
-		false,
-		amqp.Publishing{
-			ContentType:  "text/plain",
-			Body:         message,
-			Headers:      amqp.Table{"x-delay": delaySeconds * 1000},
-			DeliveryMode: amqp.Persistent,
-		},
-	); err != nil {
-		r.log.Error(logger.Queue, logger.RabbitMQProduce, fmt.Sprintf("Error Publish message: %v", err), extra)
+	r.declared.Store(name, struct{}{})
+
+	return nil
+}
+
+// ApplyTopology declares every exchange, queue and binding and puts the policies, declaring is
+// idempotent, so applying an unchanged topology is a no-op
+func (r *RabbitMQ) ApplyTopology(topology *Topology) error {
+	if err := r.withChannel(func(channel *amqp.Channel) error {
+		for _, exchange := range topology.Exchanges {
+			if err := channel.ExchangeDeclare(
+				exchange.Name,
+				exchange.Kind,
+				exchange.Durable,
+				exchange.AutoDelete,
+				exchange.Internal,
+				false,
+				exchange.Table(),
+			); err != nil {
+				return fmt.Errorf("exchange declare %s: %w", exchange.Name, err)
+			}
+		}
+
+		for _, queue := range topology.Queues {
+			if _, err := channel.QueueDeclare(
+				queue.Name,
+				queue.Durable,
+				queue.AutoDelete,
+				queue.Exclusive,
+				false,
+				queue.Table(),
+			); err != nil {
+				return fmt.Errorf("queue declare %s: %w", queue.Name, err)
+			}
+		}
+
+		for _, binding := range topology.Bindings {
+			if err := channel.QueueBind(
+				binding.Queue,
+				binding.RoutingKey,
+				binding.Exchange,
+				false,
+				binding.Table(),
+			); err != nil {
+				return fmt.Errorf("queue bind %s to %s: %w", binding.Queue, binding.Exchange, err)
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return r.applyPolicies(topology.Policies)
+}
+
+// VerifyTopology reports every exchange, queue or policy which is missing or declared with different
+// settings on the broker, bindings can not be read over AMQP and are not verified
+func (r *RabbitMQ) VerifyTopology(topology *Topology) error {
+	var problems []error
+
+	for _, exchange := range topology.Exchanges {
+		if err := r.withChannel(func(channel *amqp.Channel) error {
+			return channel.ExchangeDeclarePassive(exchange.Name, exchange.Kind, exchange.Durable, exchange.AutoDelete, exchange.Internal, false, exchange.Table())
+		}); err != nil {
+			problems = append(problems, fmt.Errorf("exchange %s is missing: %w", exchange.Name, err))
+			continue
+		}
+
+		if err := r.withChannel(func(channel *amqp.Channel) error {
+			return channel.ExchangeDeclare(exchange.Name, exchange.Kind, exchange.Durable, exchange.AutoDelete, exchange.Internal, false, exchange.Table())
+		}); err != nil {
+			problems = append(problems, fmt.Errorf("exchange %s does not match the config: %w", exchange.Name, err))
+		}
+	}
+
+	for _, queue := range topology.Queues {
+		if err := r.withChannel(func(channel *amqp.Channel) error {
+			_, err := channel.QueueDeclarePassive(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, false, queue.Table())
+			return err
+		}); err != nil {
+			problems = append(problems, fmt.Errorf("queue %s is missing: %w", queue.Name, err))
+			continue
+		}
+
+		if err := r.withChannel(func(channel *amqp.Channel) error {
+			_, err := channel.QueueDeclare(queue.Name, queue.Durable, queue.AutoDelete, queue.Exclusive, false, queue.Table())
+			return err
+		}); err != nil {
+			problems = append(problems, fmt.Errorf("queue %s does not match the config: %w", queue.Name, err))
+		}
+	}
+
+	problems = append(problems, r.verifyPolicies(topology.Policies)...)
+
+	return errors.Join(problems...)
+}
+
+// withChannel runs fn on a short-lived channel, the broker closes a channel on a failed declare,
+// so these never share the publishing channels
+func (r *RabbitMQ) withChannel(fn func(channel *amqp.Channel) error) error {
+	channel, err := r.connection().Channel()
+	if err != nil {
 		return err
 	}
+	defer func(ch *amqp.Channel) {
+		if !ch.IsClosed() {
+			_ = ch.Close()
+		}
+	}(channel)
+
+	return fn(channel)
+}
+
+func (r *RabbitMQ) connection() *amqp.Connection {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.conn
+}
+
+// waitConfirmation the broker sends basic.return before basic.ack, so a returned message
+// is already in the returns channel once the confirmation arrives
+func (r *RabbitMQ) waitConfirmation(ctx context.Context, confirmation *amqp.DeferredConfirmation, returns <-chan amqp.Return) error {
+	ctx, cancel := context.WithTimeout(ctx, r.confirmTimeout)
+	defer cancel()
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return ErrPublishTimeout
+	}
+
+	select {
+	case returned := <-returns:
+		return fmt.Errorf("%w: %d %s", ErrPublishReturned, returned.ReplyCode, returned.ReplyText)
+	default:
+	}
+
+	if !acked {
+		return ErrPublishNacked
+	}
 
 	return nil
 }
 
-func (r *RabbitMQ) RegisterConsumer(name string, callback func(message []byte) error) error {
+func (r *RabbitMQ) RegisterConsumer(name string, callback func(ctx context.Context, envelope *domain.Envelope) error) error {
 	r.consumerLock.Lock()
 	defer r.consumerLock.Unlock()
 
@@ -136,40 +555,31 @@ func (r *RabbitMQ) RegisterConsumer(name string, callback func(message []byte) e
 	return r.setupConsumer(name, callback)
 }
 
-func (r *RabbitMQ) setupConsumer(name string, callback func(message []byte) error) error {
+func (r *RabbitMQ) setupConsumer(name string, callback func(ctx context.Context, envelope *domain.Envelope) error) error {
 	extra := map[logger.ExtraKey]interface{}{
 		logger.QueueName: name,
 	}
 
-	r.mu.Lock()
-	channel, err := r.conn.Channel()
-	r.mu.Unlock()
-	if err != nil {
-		r.log.Error(logger.Queue, logger.RabbitMQRegisterConsumer, fmt.Sprintf("Error creating channel: %v", err), extra)
-		return err
-	}
-
-	queueDeclare, err := channel.QueueDeclare(
-		name,
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
+	if err := r.declareTopology(name); err != nil {
 		r.log.Error(
 			logger.Queue,
 			logger.RabbitMQRegisterConsumer,
-			fmt.Sprintf("Error QueueDeclare channel: %v", err),
+			fmt.Sprintf("Error declare topology: %v", err),
 			extra,
 		)
 		return err
 	}
 
+	channel, err := r.connection().Channel()
+	if err != nil {
+		r.log.Error(logger.Queue, logger.RabbitMQRegisterConsumer, fmt.Sprintf("Error creating channel: %v", err), extra)
+		return err
+	}
+
+	tag := fmt.Sprintf("%s-%s", name, uuid.NewString())
 	deliveries, err := channel.Consume(
-		queueDeclare.Name,
-		"",
+		name,
+		tag,
 		false,
 		false,
 		false,
@@ -185,73 +595,326 @@ func (r *RabbitMQ) setupConsumer(name string, callback func(message []byte) erro
 		)
 		return err
 	}
+	r.consumerTags[tag] = channel
+	closed := channel.NotifyClose(make(chan *amqp.Error, 1))
 
+	r.consuming.Add(1)
 	go func() {
 		for delivery := range deliveries {
-			extra = map[logger.ExtraKey]interface{}{
-				logger.QueueName: name,
-				logger.Body:      string(delivery.Body),
-			}
-			if err = callback(delivery.Body); err != nil {
-				if err = delivery.Nack(false, false); err != nil {
-					r.log.Error(
-						logger.Queue,
-						logger.RabbitMQRegisterConsumer,
-						fmt.Sprintf("Error Consume message: %v", err),
-						extra,
-					)
-				}
-				r.log.Error(
-					logger.Queue,
-					logger.RabbitMQRegisterConsumer,
-					fmt.Sprintf("Error Consume message: %v", err),
-					extra,
-				)
-			} else {
-				if err = delivery.Ack(false); err != nil {
-					r.log.Error(
-						logger.Queue,
-						logger.RabbitMQRegisterConsumer,
-						fmt.Sprintf("Error Ack Consume message: %v", err),
-						extra,
-					)
-				}
-			}
+			r.handleDelivery(name, delivery, callback)
+		}
+
+		r.consumerLock.Lock()
+		delete(r.consumerTags, tag)
+		r.consumerLock.Unlock()
+		r.consuming.Done()
+
+		// the reconnect loop recovers the consumers of a lost connection, a channel closed by a
+		// channel exception or a consumer cancelled by the broker is recovered here
+		if r.closing.Load() || r.connection().IsClosed() {
+			return
+		}
+		select {
+		case reason := <-closed:
+			extra[logger.ErrorMessage] = reason.Error()
+		default:
+			_ = channel.Close()
 		}
+		r.log.Error(logger.Queue, logger.RabbitMQRegisterConsumer, "Consumer stopped, recovering it", extra)
+
+		r.recoverConsumer(name, callback)
 	}()
 
 	return nil
 }
 
+func (r *RabbitMQ) recoverConsumer(name string, callback func(ctx context.Context, envelope *domain.Envelope) error) {
+	backoff := newBackoff(r.reconnectInterval, r.reconnectMax)
+	for {
+		if !r.sleep(backoff.next()) {
+			return
+		}
+
+		r.consumerLock.Lock()
+		if r.closing.Load() || r.connection().IsClosed() {
+			r.consumerLock.Unlock()
+			return
+		}
+		err := r.setupConsumer(name, callback)
+		r.consumerLock.Unlock()
+
+		if err == nil {
+			r.log.Info(logger.Queue, logger.RabbitMQRegisterConsumer, fmt.Sprintf("Recovered consumer: %s", name), nil)
+			return
+		}
+	}
+}
+
+func (r *RabbitMQ) handleDelivery(name string, delivery amqp.Delivery, callback func(ctx context.Context, envelope *domain.Envelope) error) {
+	extra := map[logger.ExtraKey]interface{}{
+		logger.QueueName: name,
+		logger.Body:      messageBody{delivery.Body, delivery.ContentType, delivery.ContentEncoding},
+	}
+
+	// deliveries buffered before the cancel reached the broker go back to the queue untouched
+	if r.closing.Load() {
+		if err := delivery.Nack(false, true); err != nil {
+			r.log.Error(logger.Queue, logger.Shutdown, fmt.Sprintf("Error requeue message: %v", err), extra)
+		}
+		return
+	}
+
+	r.inFlight.Add(1)
+	defer r.inFlight.Add(-1)
+
+	ctx, cancel := context.WithTimeout(r.consumeCtx, r.consumeTimeout)
+	defer cancel()
+
+	ctx, span := startConsumeSpan(ctx, "rabbitmq", name, amqpCarrier(delivery.Headers))
+
+	if delivery.Redelivered {
+		countMessage(name, metrics.QueueRetried)
+	}
+
+	start := time.Now()
+	envelope, err := decode(delivery.Body, delivery.ContentType, delivery.ContentEncoding)
+	if err == nil {
+		span.SetAttributes(messageAttributes("rabbitmq", name, envelope)...)
+		err = callback(ctx, envelope)
+	}
+	observeHandler(name, start, err)
+	tracing.End(span, err)
+	if err != nil {
+		r.log.WithContext(ctx).Error(
+			logger.Queue,
+			logger.RabbitMQRegisterConsumer,
+			fmt.Sprintf("Error Consume message: %v", err),
+			extra,
+		)
+		if err = delivery.Nack(false, false); err != nil {
+			r.log.WithContext(ctx).Error(
+				logger.Queue,
+				logger.RabbitMQRegisterConsumer,
+				fmt.Sprintf("Error Nack Consume message: %v", err),
+				extra,
+			)
+			return
+		}
+		countMessage(name, metrics.QueueNacked)
+		// a nacked message is only kept when the queue dead-letters it
+		if queues := r.topology.ForQueue(name).Queues; len(queues) > 0 && queues[0].DeadLetterExchange != "" {
+			countMessage(name, metrics.QueueDeadLettered)
+		}
+		return
+	}
+
+	countMessage(name, metrics.QueueAcked)
+	if err = delivery.Ack(false); err != nil {
+		r.log.WithContext(ctx).Error(
+			logger.Queue,
+			logger.RabbitMQRegisterConsumer,
+			fmt.Sprintf("Error Ack Consume message: %v", err),
+			extra,
+		)
+	}
+}
+
 func (r *RabbitMQ) handleReconnect() {
 	for {
-		err := <-r.notifyClose
+		err, ok := <-r.notifyClose
+		if !ok || r.closing.Load() {
+			return
+		}
+		if err == nil {
+			continue
+		}
+
+		r.state.Store(int32(StateReconnecting))
+		r.log.Error(logger.Queue, logger.RabbitMQ, fmt.Sprintf("Connection lost: %v", err), nil)
+
+		if !r.reconnect() {
+			return
+		}
+	}
+}
+
+// reconnect dials with an exponential backoff until it succeeds or the driver is closed
+func (r *RabbitMQ) reconnect() bool {
+	backoff := newBackoff(r.reconnectInterval, r.reconnectMax)
+	for {
+		wait := backoff.next()
+		r.log.Info(logger.Queue, logger.RabbitMQ, fmt.Sprintf("Attempting to reconnect to RabbitMQ in %s...", wait), nil)
+		if !r.sleep(wait) {
+			return false
+		}
+
+		conn, err := amqp.Dial(r.url)
 		if err != nil {
-			r.log.Error(logger.Queue, logger.RabbitMQ, fmt.Sprintf("Connection lost: %v", err), nil)
+			r.log.Error(logger.Queue, logger.RabbitMQ, fmt.Sprintf("Reconnect failed: %v", err), nil)
+			continue
+		}
+		if r.closing.Load() {
+			_ = conn.Close()
+			return false
+		}
+
+		r.mu.Lock()
+		r.conn = conn
+		r.notifyClose = conn.NotifyClose(make(chan *amqp.Error, 1))
+		r.mu.Unlock()
+
+		r.channels.reset()
+		r.declared.Range(func(key, _ interface{}) bool {
+			r.declared.Delete(key)
+			return true
+		})
+
+		r.log.Info(logger.Queue, logger.RabbitMQ, "Successfully reconnected to RabbitMQ", nil)
+		metrics.QueueReconnect.WithLabelValues("rabbitmq").Inc()
+
+		r.recoverConsumers()
+		r.flushBuffer(r.flushCtx)
+		return true
+	}
+}
+
+// sleep returns false when the driver is closed while it waits
+func (r *RabbitMQ) sleep(wait time.Duration) bool {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-r.done:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// flushBuffer publishes the buffered messages before the producers see the connection as up,
+// when it fails the connection stays degraded and the flush is retried until the buffer is empty
+func (r *RabbitMQ) flushBuffer(ctx context.Context) {
+	if r.buffer == nil {
+		r.state.CompareAndSwap(int32(StateReconnecting), int32(StateConnected))
+		return
+	}
+
+	r.flushLock.Lock()
+	defer r.flushLock.Unlock()
 
-			for {
-				r.log.Info(logger.Queue, logger.RabbitMQ, "Attempting to reconnect to RabbitMQ...", nil)
-				time.Sleep(5 * time.Second)
+	if err := r.flush(ctx); err != nil {
+		r.state.CompareAndSwap(int32(StateReconnecting), int32(StateDegraded))
+		if r.retryingFlush.CompareAndSwap(false, true) {
+			go r.retryFlush()
+		}
+	}
+}
 
-				conn, dialErr := amqp.Dial(r.url)
-				if dialErr == nil {
-					r.mu.Lock()
-					r.conn = conn
-					r.notifyClose = conn.NotifyClose(make(chan *amqp.Error))
-					r.mu.Unlock()
+// retryFlush flushes the buffer with a backoff while the connection is degraded,
+// a lost connection hands the flush back to reconnect
+func (r *RabbitMQ) retryFlush() {
+	defer r.retryingFlush.Store(false)
 
-					r.log.Info(logger.Queue, logger.RabbitMQ, "Successfully reconnected to RabbitMQ", nil)
+	backoff := newBackoff(r.reconnectInterval, r.reconnectMax)
+	for r.State() == StateDegraded {
+		if !r.sleep(backoff.next()) {
+			return
+		}
+		if r.State() != StateDegraded || !r.flushing.CompareAndSwap(false, true) {
+			continue
+		}
+		r.flushBuffer(r.flushCtx)
+		r.flushing.Store(false)
+	}
+}
 
-					r.recoverConsumers()
-					break
-				}
+// flush must be called with r.flushLock held, r.bufferLock is held only to peek and drop a batch,
+// so the producers keep buffering behind the flush instead of waiting for it, the connection is
+// reported up once the buffer is empty, an unroutable message would block the buffer for good,
+// so it is logged and dropped
+func (r *RabbitMQ) flush(ctx context.Context) error {
+	flushed := 0
+	for {
+		r.bufferLock.Lock()
+		batch, err := r.buffer.peek(flushBatch)
+		if err == nil && len(batch) == 0 {
+			if !r.state.CompareAndSwap(int32(StateReconnecting), int32(StateConnected)) {
+				r.state.CompareAndSwap(int32(StateDegraded), int32(StateConnected))
+			}
+			r.bufferLock.Unlock()
 
-				r.log.Error(logger.Queue, logger.RabbitMQ, fmt.Sprintf("Reconnect failed: %v", err), nil)
+			if flushed > 0 {
+				r.log.Info(logger.Queue, logger.RabbitMQBuffer, "Flushed the buffered messages", map[logger.ExtraKey]interface{}{
+					logger.MessageCount: flushed,
+				})
 			}
+			return nil
+		}
+		r.bufferLock.Unlock()
+
+		published := 0
+		if err == nil {
+			published, err = r.publishBatch(ctx, batch)
+		}
+
+		r.bufferLock.Lock()
+		if dropErr := r.buffer.drop(published); dropErr != nil {
+			err = errors.Join(err, dropErr)
+		}
+		left := r.buffer.len()
+		r.bufferLock.Unlock()
+
+		flushed += published
+		if err != nil {
+			r.log.Error(logger.Queue, logger.RabbitMQBuffer, fmt.Sprintf("Error flush buffer, %d messages left: %v", left, err), map[logger.ExtraKey]interface{}{
+				logger.MessageCount: flushed,
+			})
+			return err
 		}
 	}
 }
 
+// publishBatch returns how many messages of the batch are done, published or dropped
+func (r *RabbitMQ) publishBatch(ctx context.Context, batch []bufferedMessage) (int, error) {
+	for i, message := range batch {
+		err := r.publish(ctx, message.Queue, message.Envelope)
+
+		var publishErr *PublishError
+		if errors.As(err, &publishErr) && !publishErr.Retryable() {
+			r.log.Error(logger.Queue, logger.RabbitMQBuffer, fmt.Sprintf("Dropped buffered message: %v", err), map[logger.ExtraKey]interface{}{
+				logger.QueueName: message.Queue,
+				logger.MessageID: message.Envelope.ID,
+			})
+			continue
+		}
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(batch), nil
+}
+
+// bufferMessage must be called with r.bufferLock held
+func (r *RabbitMQ) bufferMessage(name string, envelope *domain.Envelope) error {
+	if err := r.buffer.push(bufferedMessage{
+		Queue:    name,
+		Envelope: envelope,
+	}); err != nil {
+		r.log.Error(logger.Queue, logger.RabbitMQBuffer, fmt.Sprintf("Error buffer message: %v", err), map[logger.ExtraKey]interface{}{
+			logger.QueueName: name,
+			logger.MessageID: envelope.ID,
+		})
+		return &PublishError{
+			Queue:     name,
+			MessageID: envelope.ID,
+			Reason:    err,
+		}
+	}
+
+	return nil
+}
+
 func (r *RabbitMQ) recoverConsumers() {
 	r.consumerLock.Lock()
 	defer r.consumerLock.Unlock()
@@ -263,3 +926,17 @@ func (r *RabbitMQ) recoverConsumers() {
 		}
 	}
 }
+
+func (r *RabbitMQ) Health(_ context.Context) error {
+	if state := r.State(); state == StateDegraded {
+		r.bufferLock.Lock()
+		left := r.buffer.len()
+		r.bufferLock.Unlock()
+
+		return fmt.Errorf("rabbitmq connection is %s, %d buffered messages are not published", state, left)
+	} else if state != StateConnected {
+		return fmt.Errorf("rabbitmq connection is %s", state)
+	}
+
+	return nil
+}
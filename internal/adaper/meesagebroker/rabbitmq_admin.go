@@ -0,0 +1,148 @@
+package messagebroker
+
+import (
+	"context"
+	"fmt"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func (r *RabbitMQ) Stats(_ context.Context, name string) (*domain.QueueStats, error) {
+	var stats *domain.QueueStats
+	err := r.withChannel(func(channel *amqp.Channel) error {
+		queue, err := channel.QueueDeclarePassive(name, false, false, false, false, nil)
+		if err != nil {
+			return err
+		}
+
+		stats = &domain.QueueStats{
+			Name:      queue.Name,
+			Messages:  queue.Messages,
+			Consumers: queue.Consumers,
+		}
+		return nil
+	})
+
+	return stats, err
+}
+
+// Peek gets the messages without acking them, closing the channel puts them back on the queue
+// with the redelivered flag set, the unacked messages are not delivered twice, so the scan ends with the queue
+func (r *RabbitMQ) Peek(_ context.Context, name string, limit int, filter domain.QueueFilter) ([]domain.QueueMessage, error) {
+	var messages []domain.QueueMessage
+	err := r.withChannel(func(channel *amqp.Channel) error {
+		for len(messages) < limit {
+			delivery, ok, err := channel.Get(name, false)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+
+			if message := toQueueMessage(delivery.Body, delivery.ContentType, delivery.ContentEncoding, deliveryAttempts(delivery)); filter.Match(message) {
+				messages = append(messages, message)
+			}
+		}
+		return nil
+	})
+
+	return messages, err
+}
+
+func (r *RabbitMQ) Purge(_ context.Context, name string) (int, error) {
+	var purged int
+	err := r.withChannel(func(channel *amqp.Channel) error {
+		var err error
+		purged, err = channel.QueuePurge(name, false)
+		return err
+	})
+
+	return purged, err
+}
+
+// Move republishes the matching messages with the properties they had and acks them only after the broker
+// confirmed and routed the copy, the other ones and a returned one go back to the source queue
+func (r *RabbitMQ) Move(ctx context.Context, from string, to string, limit int, filter domain.QueueFilter) (int, error) {
+	stats, err := r.Stats(ctx, from)
+	if err != nil {
+		return 0, err
+	}
+
+	exchange, routingKey := r.topology.Route(to)
+
+	var moved int
+	err = r.withChannel(func(channel *amqp.Channel) error {
+		if err := channel.Confirm(false); err != nil {
+			return err
+		}
+		returns := channel.NotifyReturn(make(chan amqp.Return, 1))
+
+		for i := 0; i < stats.Messages && (limit == 0 || moved < limit); i++ {
+			delivery, ok, err := channel.Get(from, false)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+
+			if !filter.Match(toQueueMessage(delivery.Body, delivery.ContentType, delivery.ContentEncoding, deliveryAttempts(delivery))) {
+				continue
+			}
+
+			confirmation, err := channel.PublishWithDeferredConfirmWithContext(
+				ctx,
+				exchange,
+				routingKey,
+				r.topology.Mandatory(to),
+				false,
+				amqp.Publishing{
+					ContentType:     delivery.ContentType,
+					ContentEncoding: delivery.ContentEncoding,
+					MessageId:       delivery.MessageId,
+					CorrelationId:   delivery.CorrelationId,
+					Type:            delivery.Type,
+					Timestamp:       delivery.Timestamp,
+					Headers:         delivery.Headers,
+					Body:            delivery.Body,
+					DeliveryMode:    amqp.Persistent,
+				},
+			)
+			if err != nil {
+				return err
+			}
+
+			if err = r.waitConfirmation(ctx, confirmation, returns); err != nil {
+				return fmt.Errorf("message %s was not moved to %s: %w", delivery.MessageId, to, err)
+			}
+
+			if err = delivery.Ack(false); err != nil {
+				return err
+			}
+			moved++
+		}
+		return nil
+	})
+
+	return moved, err
+}
+
+// deliveryAttempts reads the death count the broker adds when it dead-letters a message
+func deliveryAttempts(delivery amqp.Delivery) int {
+	deaths, ok := delivery.Headers["x-death"].([]interface{})
+	if !ok {
+		return 0
+	}
+
+	var attempts int
+	for _, death := range deaths {
+		if table, ok := death.(amqp.Table); ok {
+			if count, ok := table["count"].(int64); ok {
+				attempts += int(count)
+			}
+		}
+	}
+
+	return attempts
+}
@@ -0,0 +1,155 @@
+package messagebroker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const policyTimeout = 10 * time.Second
+
+// policyBody is the policy as the management API reads and writes it
+type policyBody struct {
+	Pattern    string                 `json:"pattern"`
+	ApplyTo    string                 `json:"apply-to"`
+	Priority   int                    `json:"priority"`
+	Definition map[string]interface{} `json:"definition"`
+}
+
+func newPolicyBody(policy TopologyPolicy) (policyBody, error) {
+	applyTo := policy.ApplyTo
+	if applyTo == "" {
+		applyTo = "queues"
+	}
+
+	// the definition is read back from JSON, so it compares like the one on the broker
+	content, err := json.Marshal(policy.Definition)
+	if err != nil {
+		return policyBody{}, err
+	}
+	var definition map[string]interface{}
+	if err = json.Unmarshal(content, &definition); err != nil {
+		return policyBody{}, err
+	}
+
+	return policyBody{
+		Pattern:    policy.Pattern,
+		ApplyTo:    applyTo,
+		Priority:   policy.Priority,
+		Definition: definition,
+	}, nil
+}
+
+// applyPolicies puts every policy on the vhost of the connection, a policy with the same name is replaced
+func (r *RabbitMQ) applyPolicies(policies []TopologyPolicy) error {
+	for _, policy := range policies {
+		body, err := newPolicyBody(policy)
+		if err != nil {
+			return fmt.Errorf("policy %s: %w", policy.Name, err)
+		}
+
+		content, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("policy %s: %w", policy.Name, err)
+		}
+
+		response, err := r.management(http.MethodPut, policy.Name, content)
+		if err != nil {
+			return fmt.Errorf("policy %s: %w", policy.Name, err)
+		}
+		_ = response.Body.Close()
+
+		if response.StatusCode != http.StatusCreated && response.StatusCode != http.StatusNoContent {
+			return fmt.Errorf("policy %s: management api answered %s", policy.Name, response.Status)
+		}
+	}
+
+	return nil
+}
+
+// verifyPolicies reports every policy which is missing or differs on the broker
+func (r *RabbitMQ) verifyPolicies(policies []TopologyPolicy) []error {
+	var problems []error
+
+	for _, policy := range policies {
+		expected, err := newPolicyBody(policy)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("policy %s: %w", policy.Name, err))
+			continue
+		}
+
+		actual, err := r.policy(policy.Name)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("policy %s is missing: %w", policy.Name, err))
+			continue
+		}
+
+		if actual.Pattern != expected.Pattern || actual.ApplyTo != expected.ApplyTo ||
+			actual.Priority != expected.Priority || !reflect.DeepEqual(actual.Definition, expected.Definition) {
+			problems = append(problems, fmt.Errorf("policy %s does not match the config", policy.Name))
+		}
+	}
+
+	return problems
+}
+
+func (r *RabbitMQ) policy(name string) (*policyBody, error) {
+	response, err := r.management(http.MethodGet, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func(body io.ReadCloser) {
+		_ = body.Close()
+	}(response.Body)
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("management api answered %s", response.Status)
+	}
+
+	var body policyBody
+	if err = json.NewDecoder(response.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	return &body, nil
+}
+
+// management calls the policy endpoint of the management API, without credentials in the
+// management url the ones of the AMQP url are used
+func (r *RabbitMQ) management(method string, name string, content []byte) (*http.Response, error) {
+	if r.managementURL == "" {
+		return nil, errors.New("RABBITMQ_MANAGEMENT_URL is required for the topology policies")
+	}
+
+	uri, err := amqp.ParseURI(r.url)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf(
+		"%s/api/policies/%s/%s",
+		strings.TrimRight(r.managementURL, "/"),
+		url.PathEscape(uri.Vhost),
+		url.PathEscape(name),
+	)
+
+	request, err := http.NewRequest(method, endpoint, bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if request.URL.User == nil {
+		request.SetBasicAuth(uri.Username, uri.Password)
+	}
+
+	client := &http.Client{Timeout: policyTimeout}
+	return client.Do(request)
+}
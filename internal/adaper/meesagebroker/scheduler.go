@@ -0,0 +1,331 @@
+package messagebroker
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/config"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
+	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"sort"
+	"time"
+)
+
+const (
+	// schedulerMaxRetryDelay caps how long a message waits after its dispatch failed
+	schedulerMaxRetryDelay = time.Hour
+	// schedulerClaimTimeout is how long a claimed message is held for its dispatcher, the produce
+	// gives up before it, so a message is only dispatched again once its dispatcher is gone
+	schedulerClaimTimeout = time.Minute
+)
+
+// Scheduler keeps the messages for an absolute delivery time in the scheduled_messages table,
+// its dispatcher produces them to the driver once they are due, so no broker plugin is needed
+type Scheduler struct {
+	log      logger.Logger
+	db       *sql.DB
+	driver   port.Driver
+	interval time.Duration
+	batch    int
+}
+
+func NewScheduler(conf config.Queue, db *sql.DB, driver port.Driver, log logger.Logger) *Scheduler {
+	interval := conf.SchedulerInterval * time.Second
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	batch := conf.SchedulerBatch
+	if batch < 1 {
+		batch = 1
+	}
+
+	return &Scheduler{
+		log:      log,
+		db:       db,
+		driver:   driver,
+		interval: interval,
+		batch:    batch,
+	}
+}
+
+func (r *Scheduler) Schedule(ctx context.Context, name string, envelope *domain.Envelope, deliverAt time.Time) (uuid.UUID, error) {
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		r.log.WithContext(ctx).Error(logger.Queue, logger.Scheduler, fmt.Sprintf("Error marshalling value: %v", err), nil)
+		return uuid.Nil, err
+	}
+
+	id := uuid.New()
+	if _, err = r.db.ExecContext(
+		ctx,
+		`INSERT INTO scheduled_messages (uuid, queue, body, deliver_at) VALUES ($1, $2, $3, $4)`,
+		id,
+		name,
+		body,
+		deliverAt,
+	); err != nil {
+		r.log.WithContext(ctx).Error(logger.Queue, logger.Scheduler, fmt.Sprintf("Error insert scheduled message: %v", err), map[logger.ExtraKey]interface{}{
+			logger.QueueName: name,
+			logger.MessageID: envelope.ID,
+		})
+		return uuid.Nil, err
+	}
+
+	return id, nil
+}
+
+func (r *Scheduler) Get(ctx context.Context, id uuid.UUID) (*domain.ScheduledMessage, error) {
+	var message domain.ScheduledMessage
+	var body []byte
+	var lastError sql.NullString
+	var dispatchedAt, cancelledAt sql.NullTime
+	if err := r.db.QueryRowContext(
+		ctx,
+		`SELECT uuid, queue, body, deliver_at, attempts, last_error, dispatched_at, cancelled_at
+				FROM scheduled_messages WHERE uuid = $1`,
+		id,
+	).Scan(
+		&message.ID,
+		&message.Queue,
+		&body,
+		&message.DeliverAt,
+		&message.Attempts,
+		&lastError,
+		&dispatchedAt,
+		&cancelledAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrScheduleNotFound
+		}
+		r.log.WithContext(ctx).Error(logger.Queue, logger.Scheduler, fmt.Sprintf("Error select scheduled message: %v", err), map[logger.ExtraKey]interface{}{
+			logger.ScheduleID: id,
+		})
+		return nil, err
+	}
+
+	envelope, err := domain.DecodeEnvelope(body)
+	if err != nil {
+		return nil, err
+	}
+	message.Envelope = envelope
+	message.LastError = lastError.String
+
+	message.Status = domain.SchedulePending
+	if dispatchedAt.Valid {
+		message.Status = domain.ScheduleDispatched
+		message.DispatchedAt = &dispatchedAt.Time
+	}
+	if cancelledAt.Valid {
+		message.Status = domain.ScheduleCancelled
+		message.CancelledAt = &cancelledAt.Time
+	}
+
+	return &message, nil
+}
+
+// Cancel fails with ErrScheduleNotPending while a dispatcher holds the message, it may be produced already
+func (r *Scheduler) Cancel(ctx context.Context, id uuid.UUID) error {
+	return r.updatePending(
+		ctx,
+		id,
+		`UPDATE scheduled_messages SET cancelled_at = now(), updated_at = now()
+				WHERE uuid = $1 AND dispatched_at IS NULL AND cancelled_at IS NULL
+				  AND (claimed_until IS NULL OR claimed_until <= now())`,
+	)
+}
+
+func (r *Scheduler) Reschedule(ctx context.Context, id uuid.UUID, deliverAt time.Time) error {
+	return r.updatePending(
+		ctx,
+		id,
+		`UPDATE scheduled_messages SET deliver_at = $2, updated_at = now()
+				WHERE uuid = $1 AND dispatched_at IS NULL AND cancelled_at IS NULL
+				  AND (claimed_until IS NULL OR claimed_until <= now())`,
+		deliverAt,
+	)
+}
+
+func (r *Scheduler) updatePending(ctx context.Context, id uuid.UUID, query string, args ...interface{}) error {
+	result, err := r.db.ExecContext(ctx, query, append([]interface{}{id}, args...)...)
+	if err != nil {
+		r.log.WithContext(ctx).Error(logger.Queue, logger.Scheduler, fmt.Sprintf("Error update scheduled message: %v", err), map[logger.ExtraKey]interface{}{
+			logger.ScheduleID: id,
+		})
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	if _, err = r.Get(ctx, id); err != nil {
+		return err
+	}
+
+	return ErrScheduleNotPending
+}
+
+// Run dispatches the due messages until ctx is done, several instances may run side by side,
+// the claim hands every message to one of them
+func (r *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			dispatched, err := r.dispatchDue(ctx)
+			if err != nil && ctx.Err() == nil {
+				r.log.Error(logger.Queue, logger.Scheduler, fmt.Sprintf("Error dispatch scheduled messages: %v", err), nil)
+			}
+			// a full batch means more messages may be due already
+			if err != nil || dispatched < r.batch {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type dueMessage struct {
+	id        uint64
+	uuid      uuid.UUID
+	queue     string
+	body      []byte
+	deliverAt time.Time
+}
+
+// dispatchDue claims a batch of due messages and produces them after the claim is committed, so no
+// row lock is held while the broker is called, a dispatcher which dies before it marks a message
+// leaves it to be dispatched again once the claim expires, so delivery is at least once
+func (r *Scheduler) dispatchDue(ctx context.Context) (int, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`UPDATE scheduled_messages
+				SET attempts = attempts + 1, claimed_until = now() + make_interval(secs => $2), updated_at = now()
+				WHERE id IN (
+					SELECT id FROM scheduled_messages
+					WHERE dispatched_at IS NULL AND cancelled_at IS NULL AND deliver_at <= now()
+					  AND (claimed_until IS NULL OR claimed_until <= now())
+					ORDER BY deliver_at, id
+					FOR UPDATE SKIP LOCKED
+					LIMIT $1
+				)
+				RETURNING id, uuid, queue, body, deliver_at`,
+		r.batch,
+		schedulerClaimTimeout.Seconds(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var due []dueMessage
+	for rows.Next() {
+		var message dueMessage
+		if err = rows.Scan(&message.id, &message.uuid, &message.queue, &message.body, &message.deliverAt); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		due = append(due, message)
+	}
+	if err = rows.Close(); err != nil {
+		return 0, err
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// RETURNING keeps no order, the messages are produced in the order they were due
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].deliverAt.Equal(due[j].deliverAt) {
+			return due[i].id < due[j].id
+		}
+		return due[i].deliverAt.Before(due[j].deliverAt)
+	})
+
+	for i, message := range due {
+		if ctx.Err() != nil {
+			r.release(due[i:])
+			return i, ctx.Err()
+		}
+		if err = r.dispatch(ctx, message); err != nil {
+			r.release(due[i+1:])
+			return i, err
+		}
+	}
+
+	return len(due), nil
+}
+
+// release hands the claimed messages which were not produced back to the other dispatchers right away
+func (r *Scheduler) release(messages []dueMessage) {
+	ids := make([]int64, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, int64(message.id))
+	}
+
+	if _, err := r.db.ExecContext(
+		context.Background(),
+		`UPDATE scheduled_messages SET attempts = attempts - 1, claimed_until = NULL, updated_at = now() WHERE id = ANY($1)`,
+		pq.Array(ids),
+	); err != nil {
+		r.log.Error(logger.Queue, logger.Scheduler, fmt.Sprintf("Error release scheduled messages: %v", err), nil)
+	}
+}
+
+// dispatch a failed message is retried later with a delay growing with its attempts
+func (r *Scheduler) dispatch(ctx context.Context, message dueMessage) error {
+	extra := map[logger.ExtraKey]interface{}{
+		logger.QueueName:  message.queue,
+		logger.ScheduleID: message.uuid,
+	}
+
+	produceCtx, cancel := context.WithTimeout(ctx, schedulerClaimTimeout/2)
+	defer cancel()
+
+	envelope, err := domain.DecodeEnvelope(message.body)
+	if err == nil {
+		err = r.driver.Produce(produceCtx, message.queue, envelope, 0)
+	}
+	if err != nil {
+		r.log.WithContext(ctx).Error(logger.Queue, logger.Scheduler, fmt.Sprintf("Error dispatch scheduled message: %v", err), extra)
+
+		// the outcome is recorded even when ctx is done, otherwise the message waits for its claim to expire
+		_, err = r.db.ExecContext(
+			context.Background(),
+			`UPDATE scheduled_messages
+					SET last_error = $2,
+					    deliver_at = now() + make_interval(secs => LEAST(attempts * $3::float8, $4::float8)),
+					    claimed_until = NULL,
+					    updated_at = now()
+					WHERE id = $1`,
+			message.id,
+			err.Error(),
+			r.interval.Seconds(),
+			schedulerMaxRetryDelay.Seconds(),
+		)
+		return err
+	}
+
+	_, err = r.db.ExecContext(
+		context.Background(),
+		`UPDATE scheduled_messages SET dispatched_at = now(), claimed_until = NULL, updated_at = now() WHERE id = $1`,
+		message.id,
+	)
+
+	return err
+}
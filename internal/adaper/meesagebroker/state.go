@@ -0,0 +1,27 @@
+package messagebroker
+
+// ConnectionState is the state of the connection to the broker
+type ConnectionState int32
+
+const (
+	StateConnected ConnectionState = iota
+	StateReconnecting
+	// StateDegraded is connected but the buffered messages are not flushed yet
+	StateDegraded
+	StateClosed
+)
+
+func (r ConnectionState) String() string {
+	switch r {
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateDegraded:
+		return "degraded"
+	case StateClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
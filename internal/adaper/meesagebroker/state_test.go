@@ -0,0 +1,43 @@
+package messagebroker
+
+import (
+	"context"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"strings"
+	"testing"
+)
+
+func TestHealthReportsTheBufferedMessagesWhileDegraded(t *testing.T) {
+	rmq := &RabbitMQ{buffer: &memoryBuffer{size: 10}}
+	for i := 0; i < 2; i++ {
+		if err := rmq.buffer.push(bufferedMessage{Queue: "user", Envelope: &domain.Envelope{}}); err != nil {
+			t.Fatalf("push: %v", err)
+		}
+	}
+
+	tests := []struct {
+		state ConnectionState
+		want  string
+	}{
+		{state: StateConnected},
+		{state: StateDegraded, want: "rabbitmq connection is degraded, 2 buffered messages are not published"},
+		{state: StateReconnecting, want: "rabbitmq connection is reconnecting"},
+		{state: StateClosed, want: "rabbitmq connection is closed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.state.String(), func(t *testing.T) {
+			rmq.state.Store(int32(tt.state))
+
+			err := rmq.Health(context.Background())
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("Health() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Health() = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package messagebroker
+
+import (
+	"fmt"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gopkg.in/yaml.v3"
+	"os"
+	"slices"
+)
+
+// Topology describes the exchanges, queues and bindings the services expect on the broker
+type Topology struct {
+	Exchanges []TopologyExchange `yaml:"exchanges"`
+	Queues    []TopologyQueue    `yaml:"queues"`
+	Bindings  []TopologyBinding  `yaml:"bindings"`
+	Routes    []TopologyRoute    `yaml:"routes"`
+	Policies  []TopologyPolicy   `yaml:"policies"`
+}
+
+type TopologyExchange struct {
+	Name       string                 `yaml:"name"`
+	Kind       string                 `yaml:"kind"`
+	Durable    bool                   `yaml:"durable"`
+	AutoDelete bool                   `yaml:"auto_delete"`
+	Internal   bool                   `yaml:"internal"`
+	Arguments  map[string]interface{} `yaml:"arguments"`
+}
+
+type TopologyQueue struct {
+	Name                 string                 `yaml:"name"`
+	Durable              bool                   `yaml:"durable"`
+	AutoDelete           bool                   `yaml:"auto_delete"`
+	Exclusive            bool                   `yaml:"exclusive"`
+	Type                 string                 `yaml:"type"`
+	MaxLength            int64                  `yaml:"max_length"`
+	MessageTTL           int64                  `yaml:"message_ttl"`
+	MaxPriority          int64                  `yaml:"max_priority"`
+	DeadLetterExchange   string                 `yaml:"dead_letter_exchange"`
+	DeadLetterRoutingKey string                 `yaml:"dead_letter_routing_key"`
+	Arguments            map[string]interface{} `yaml:"arguments"`
+}
+
+type TopologyBinding struct {
+	Queue      string                 `yaml:"queue"`
+	Exchange   string                 `yaml:"exchange"`
+	RoutingKey string                 `yaml:"routing_key"`
+	Arguments  map[string]interface{} `yaml:"arguments"`
+}
+
+// TopologyRoute publishes the messages produced under a name to an exchange instead of a queue,
+// e.g. the events for the downstream services, nobody may be bound yet, so they are not mandatory
+type TopologyRoute struct {
+	Name       string `yaml:"name"`
+	Exchange   string `yaml:"exchange"`
+	RoutingKey string `yaml:"routing_key"`
+}
+
+// TopologyPolicy is applied through the management API, unlike the queue arguments a policy can be
+// added to or changed on a queue which is already declared, e.g. the dead letter exchange
+type TopologyPolicy struct {
+	Name       string                 `yaml:"name"`
+	Pattern    string                 `yaml:"pattern"`
+	ApplyTo    string                 `yaml:"apply_to"`
+	Priority   int                    `yaml:"priority"`
+	Definition map[string]interface{} `yaml:"definition"`
+}
+
+// DefaultTopology is used for a queue which is not in the topology file,
+// a durable queue bound by its name to the direct queue exchange
+func DefaultTopology(queue string) *Topology {
+	return &Topology{
+		Exchanges: []TopologyExchange{
+			{
+				Name:    queueExchange,
+				Kind:    "direct",
+				Durable: true,
+			},
+		},
+		Queues: []TopologyQueue{
+			{
+				Name:    queue,
+				Durable: true,
+			},
+		},
+		Bindings: []TopologyBinding{
+			{
+				Queue:      queue,
+				Exchange:   queueExchange,
+				RoutingKey: queue,
+			},
+		},
+	}
+}
+
+// WithRoutes returns a copy which routes the names it does not declare yet to exchange, a nil
+// topology keeps the default topology for the queues
+func (r *Topology) WithRoutes(exchange TopologyExchange, names ...string) *Topology {
+	var result Topology
+	if r != nil {
+		result = *r
+		result.Exchanges = append([]TopologyExchange(nil), r.Exchanges...)
+		result.Routes = append([]TopologyRoute(nil), r.Routes...)
+	}
+
+	declared := make(map[string]bool)
+	for _, queue := range result.Queues {
+		declared[queue.Name] = true
+	}
+	for _, route := range result.Routes {
+		declared[route.Name] = true
+	}
+
+	added := false
+	for _, name := range names {
+		if declared[name] {
+			continue
+		}
+		result.Routes = append(result.Routes, TopologyRoute{Name: name, Exchange: exchange.Name})
+		declared[name] = true
+		added = true
+	}
+
+	if added && !slices.ContainsFunc(result.Exchanges, func(declared TopologyExchange) bool {
+		return declared.Name == exchange.Name
+	}) {
+		result.Exchanges = append(result.Exchanges, exchange)
+	}
+
+	return &result
+}
+
+func LoadTopology(path string) (*Topology, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read topology file: %w", err)
+	}
+
+	var topology Topology
+	if err = yaml.Unmarshal(content, &topology); err != nil {
+		return nil, fmt.Errorf("parse topology file: %w", err)
+	}
+
+	if err = topology.validate(); err != nil {
+		return nil, err
+	}
+
+	return &topology, nil
+}
+
+func (r *Topology) validate() error {
+	exchanges := make(map[string]bool)
+	for _, exchange := range r.Exchanges {
+		if exchange.Name == "" || exchange.Kind == "" {
+			return fmt.Errorf("exchange must have a name and a kind")
+		}
+		exchanges[exchange.Name] = true
+	}
+
+	queues := make(map[string]bool)
+	for _, queue := range r.Queues {
+		if queue.Name == "" {
+			return fmt.Errorf("queue must have a name")
+		}
+		if queue.Type != "" && queue.Type != "classic" && queue.Type != "quorum" {
+			return fmt.Errorf("queue %s has unsupported type %s", queue.Name, queue.Type)
+		}
+		if queue.DeadLetterExchange != "" && !exchanges[queue.DeadLetterExchange] {
+			return fmt.Errorf("queue %s dead letters to undeclared exchange %s", queue.Name, queue.DeadLetterExchange)
+		}
+		queues[queue.Name] = true
+	}
+
+	routes := make(map[string]bool)
+	for _, route := range r.Routes {
+		if route.Name == "" {
+			return fmt.Errorf("route must have a name")
+		}
+		if routes[route.Name] || queues[route.Name] {
+			return fmt.Errorf("route %s is declared twice or as a queue", route.Name)
+		}
+		if !exchanges[route.Exchange] {
+			return fmt.Errorf("route %s refers to undeclared exchange %s", route.Name, route.Exchange)
+		}
+		routes[route.Name] = true
+	}
+
+	for _, policy := range r.Policies {
+		if policy.Name == "" || policy.Pattern == "" || len(policy.Definition) == 0 {
+			return fmt.Errorf("policy must have a name, a pattern and a definition")
+		}
+		if policy.ApplyTo != "" && policy.ApplyTo != "queues" && policy.ApplyTo != "exchanges" && policy.ApplyTo != "all" {
+			return fmt.Errorf("policy %s applies to unsupported %s", policy.Name, policy.ApplyTo)
+		}
+		if exchange, ok := policy.Definition["dead-letter-exchange"].(string); ok && !exchanges[exchange] {
+			return fmt.Errorf("policy %s dead letters to undeclared exchange %s", policy.Name, exchange)
+		}
+	}
+
+	for _, binding := range r.Bindings {
+		if !queues[binding.Queue] {
+			return fmt.Errorf("binding refers to undeclared queue %s", binding.Queue)
+		}
+		if !exchanges[binding.Exchange] {
+			return fmt.Errorf("binding refers to undeclared exchange %s", binding.Exchange)
+		}
+	}
+
+	return nil
+}
+
+// ForQueue returns the part of the topology a queue needs, the queue itself, its bindings,
+// the bound exchanges and its dead letter exchange, a route only needs its exchange
+func (r *Topology) ForQueue(name string) *Topology {
+	if r == nil {
+		return DefaultTopology(name)
+	}
+
+	if route := r.route(name); route != nil {
+		result := Topology{Routes: []TopologyRoute{*route}}
+		for _, exchange := range r.Exchanges {
+			if exchange.Name == route.Exchange {
+				result.Exchanges = append(result.Exchanges, exchange)
+			}
+		}
+		return &result
+	}
+
+	var result Topology
+	needed := make(map[string]bool)
+	for _, queue := range r.Queues {
+		if queue.Name == name {
+			result.Queues = append(result.Queues, queue)
+			if queue.DeadLetterExchange != "" {
+				needed[queue.DeadLetterExchange] = true
+			}
+		}
+	}
+	if len(result.Queues) == 0 {
+		return DefaultTopology(name)
+	}
+
+	for _, binding := range r.Bindings {
+		if binding.Queue == name {
+			result.Bindings = append(result.Bindings, binding)
+			needed[binding.Exchange] = true
+		}
+	}
+
+	for _, exchange := range r.Exchanges {
+		if needed[exchange.Name] {
+			result.Exchanges = append(result.Exchanges, exchange)
+		}
+	}
+
+	return &result
+}
+
+// Route returns the exchange and routing key used to publish to a queue, the first binding wins,
+// a route without a routing key publishes with its name
+func (r *Topology) Route(queue string) (exchange string, routingKey string) {
+	if route := r.route(queue); route != nil {
+		if route.RoutingKey == "" {
+			return route.Exchange, route.Name
+		}
+		return route.Exchange, route.RoutingKey
+	}
+
+	for _, binding := range r.ForQueue(queue).Bindings {
+		return binding.Exchange, binding.RoutingKey
+	}
+
+	return "", queue
+}
+
+// Mandatory a message to a queue must be routed, an event on a route may have no subscriber
+func (r *Topology) Mandatory(name string) bool {
+	return r.route(name) == nil
+}
+
+func (r *Topology) route(name string) *TopologyRoute {
+	if r == nil {
+		return nil
+	}
+
+	for i := range r.Routes {
+		if r.Routes[i].Name == name {
+			return &r.Routes[i]
+		}
+	}
+
+	return nil
+}
+
+func (r *TopologyQueue) Table() amqp.Table {
+	table := amqp.Table{}
+	for key, value := range r.Arguments {
+		table[key] = value
+	}
+
+	if r.Type != "" {
+		table["x-queue-type"] = r.Type
+	}
+	if r.MaxLength > 0 {
+		table["x-max-length"] = r.MaxLength
+	}
+	if r.MessageTTL > 0 {
+		table["x-message-ttl"] = r.MessageTTL
+	}
+	if r.MaxPriority > 0 {
+		table["x-max-priority"] = r.MaxPriority
+	}
+	if r.DeadLetterExchange != "" {
+		table["x-dead-letter-exchange"] = r.DeadLetterExchange
+	}
+	if r.DeadLetterRoutingKey != "" {
+		table["x-dead-letter-routing-key"] = r.DeadLetterRoutingKey
+	}
+
+	if len(table) == 0 {
+		return nil
+	}
+
+	return table
+}
+
+func (r *TopologyExchange) Table() amqp.Table {
+	if len(r.Arguments) == 0 {
+		return nil
+	}
+
+	return r.Arguments
+}
+
+func (r *TopologyBinding) Table() amqp.Table {
+	if len(r.Arguments) == 0 {
+		return nil
+	}
+
+	return r.Arguments
+}
@@ -0,0 +1,101 @@
+package messagebroker
+
+import (
+	"context"
+	"fmt"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/pkg/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startProduceSpan returns a copy of the envelope carrying the trace context in its headers,
+// so the consumer span continues the trace, also after the message waited in a buffer
+func startProduceSpan(ctx context.Context, system string, name string, envelope *domain.Envelope) (context.Context, trace.Span, *domain.Envelope) {
+	ctx, span := tracing.Tracer().Start(
+		ctx,
+		fmt.Sprintf("%s publish", name),
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(messageAttributes(system, name, envelope)...),
+		trace.WithAttributes(semconv.MessagingOperationTypePublish),
+	)
+
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return ctx, span, envelope
+	}
+
+	traced := *envelope
+	traced.Headers = make(map[string]string, len(envelope.Headers)+len(carrier))
+	for key, value := range envelope.Headers {
+		traced.Headers[key] = value
+	}
+	for key, value := range carrier {
+		traced.Headers[key] = value
+	}
+
+	return ctx, span, &traced
+}
+
+// startConsumeSpan continues the trace of the producer found in carrier
+func startConsumeSpan(ctx context.Context, system string, name string, carrier propagation.TextMapCarrier) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, carrier)
+
+	return tracing.Tracer().Start(
+		ctx,
+		fmt.Sprintf("%s process", name),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(messageAttributes(system, name, nil)...),
+		trace.WithAttributes(semconv.MessagingOperationTypeDeliver),
+	)
+}
+
+func messageAttributes(system string, name string, envelope *domain.Envelope) []attribute.KeyValue {
+	attributes := []attribute.KeyValue{
+		semconv.MessagingSystemKey.String(system),
+		semconv.MessagingDestinationName(name),
+	}
+	if envelope != nil {
+		attributes = append(
+			attributes,
+			semconv.MessagingMessageID(envelope.ID),
+			attribute.String("messaging.message.type", envelope.Type),
+		)
+	}
+
+	return attributes
+}
+
+// envelopeCarrier reads the trace context of the drivers which keep it in the envelope
+func envelopeCarrier(envelope *domain.Envelope) propagation.TextMapCarrier {
+	if envelope == nil {
+		return propagation.MapCarrier{}
+	}
+
+	return propagation.MapCarrier(envelope.Headers)
+}
+
+// amqpCarrier reads and writes the trace context in the AMQP message headers
+type amqpCarrier amqp.Table
+
+func (r amqpCarrier) Get(key string) string {
+	value, _ := r[key].(string)
+	return value
+}
+
+func (r amqpCarrier) Set(key string, value string) {
+	r[key] = value
+}
+
+func (r amqpCarrier) Keys() []string {
+	keys := make([]string, 0, len(r))
+	for key := range r {
+		keys = append(keys, key)
+	}
+	return keys
+}
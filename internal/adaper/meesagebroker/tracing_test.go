@@ -0,0 +1,142 @@
+package messagebroker
+
+import (
+	"context"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/pkg/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+)
+
+// newTestTracing records every span as it ends and restores the global tracing afterward
+func newTestTracing(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		_ = provider.Shutdown(context.Background())
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	return exporter
+}
+
+func findSpan(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	t.Fatalf("span %q is not recorded, got %d spans", name, len(spans))
+
+	return tracetest.SpanStub{}
+}
+
+func TestMemoryConsumeSpanContinuesProduceSpan(t *testing.T) {
+	exporter := newTestTracing(t)
+	memory := newTestMemory(t, 0)
+
+	ctx, parent := tracing.Start(context.Background(), "import")
+	envelope := newTestEnvelope(t)
+	if err := memory.Produce(ctx, testQueue, envelope, 0); err != nil {
+		t.Fatalf("Produce() error = %v", err)
+	}
+	parent.End()
+
+	var consumed trace.SpanContext
+	done := make(chan struct{})
+	if err := memory.RegisterConsumer(testQueue, func(ctx context.Context, _ *domain.Envelope) error {
+		consumed = trace.SpanContextFromContext(ctx)
+		close(done)
+		return nil
+	}); err != nil {
+		t.Fatalf("RegisterConsumer() error = %v", err)
+	}
+	<-done
+	shutdownMemory(t, memory)
+
+	spans := exporter.GetSpans()
+	publish := findSpan(t, spans, testQueue+" publish")
+	process := findSpan(t, spans, testQueue+" process")
+
+	if publish.SpanKind != trace.SpanKindProducer || process.SpanKind != trace.SpanKindConsumer {
+		t.Errorf("span kinds = %s and %s, want producer and consumer", publish.SpanKind, process.SpanKind)
+	}
+	if publish.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Error("publish span is not a child of the caller span")
+	}
+	if process.SpanContext.TraceID() != publish.SpanContext.TraceID() {
+		t.Errorf("process trace %s, want the publish trace %s", process.SpanContext.TraceID(), publish.SpanContext.TraceID())
+	}
+	if process.Parent.SpanID() != publish.SpanContext.SpanID() {
+		t.Error("process span is not a child of the publish span")
+	}
+	if consumed.SpanID() != process.SpanContext.SpanID() {
+		t.Error("handler context does not carry the process span")
+	}
+	if envelope.Headers != nil {
+		t.Error("Produce changed the headers of the caller envelope")
+	}
+}
+
+func TestTraceparentTravelsInAMQPHeaders(t *testing.T) {
+	exporter := newTestTracing(t)
+
+	_, span, traced := startProduceSpan(context.Background(), "rabbitmq", testQueue, newTestEnvelope(t))
+	span.End()
+
+	if traced.Headers["traceparent"] == "" {
+		t.Fatal("produced envelope has no traceparent header")
+	}
+
+	// the RabbitMQ driver copies the envelope headers into the message headers
+	headers := amqp.Table{}
+	for key, value := range traced.Headers {
+		headers[key] = value
+	}
+
+	_, consume := startConsumeSpan(context.Background(), "rabbitmq", testQueue, amqpCarrier(headers))
+	consume.End()
+
+	spans := exporter.GetSpans()
+	publish := findSpan(t, spans, testQueue+" publish")
+	process := findSpan(t, spans, testQueue+" process")
+
+	if process.Parent.SpanID() != publish.SpanContext.SpanID() || !process.Parent.IsRemote() {
+		t.Error("process span does not continue the remote publish span")
+	}
+}
+
+func TestConsumeWithoutTraceparentStartsNewTrace(t *testing.T) {
+	exporter := newTestTracing(t)
+
+	_, span := startConsumeSpan(context.Background(), "rabbitmq", testQueue, amqpCarrier(amqp.Table{}))
+	span.End()
+
+	process := findSpan(t, exporter.GetSpans(), testQueue+" process")
+	if process.Parent.IsValid() {
+		t.Error("process span has a parent without a traceparent header")
+	}
+}
+
+// shutdownMemory waits for the consumer, so its span has ended before the spans are read
+func shutdownMemory(t *testing.T, memory *Memory) {
+	t.Helper()
+
+	if err := memory.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+}
@@ -16,14 +16,17 @@ import (
 )
 
 var dbClient *sql.DB
+var replicaClients []*sql.DB
 
-func InitClient(ctx context.Context, log logger.Logger, conf config.Config) error {
-	var err error
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s TimeZone=%s",
+func DSN(conf config.Config) string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s TimeZone=%s",
 		conf.DB.Host, conf.DB.Port, conf.DB.Username, conf.DB.Password,
 		conf.DB.Name, conf.DB.Postgres.SSLMode, conf.DB.Postgres.Timezone)
+}
 
-	if dbClient, err = sql.Open("postgres", dsn); err != nil {
+func InitClient(ctx context.Context, log logger.Logger, conf config.Config) error {
+	var err error
+	if dbClient, err = sql.Open("postgres", DSN(conf)); err != nil {
 		log.Error(logger.Database, logger.Startup, fmt.Sprintf("There is an Error in Open DB : %v", err), nil)
 		return err
 	}
@@ -35,20 +38,63 @@ func InitClient(ctx context.Context, log logger.Logger, conf config.Config) erro
 		return err
 	}
 
-	dbClient.SetMaxOpenConns(conf.DB.Postgres.MaxOpenConnections)
-	dbClient.SetMaxIdleConns(conf.DB.Postgres.MaxIdleConnections)
-	dbClient.SetConnMaxLifetime(conf.DB.Postgres.MaxLifetime * time.Minute)
+	setPool(dbClient, conf)
 
 	log.Info(logger.Database, logger.Startup, "Database client initialized", nil)
 
+	initReplicas(ctx, log, conf)
+
 	return nil
 }
 
+// initReplicas a replica which can not be reached is skipped, the reads fall back to the primary
+func initReplicas(ctx context.Context, log logger.Logger, conf config.Config) {
+	replicaClients = nil
+	for index, dsn := range conf.DB.ReplicaDSNs {
+		replica, err := sql.Open("postgres", dsn)
+		if err != nil {
+			log.Error(logger.Database, logger.Startup, fmt.Sprintf("There is an Error in Open replica %d : %v", index, err), nil)
+			continue
+		}
+
+		if err = replica.PingContext(ctx); err != nil {
+			log.Error(logger.Database, logger.Startup, fmt.Sprintf("Replica %d Ping is not available %v", index, err), nil)
+			_ = replica.Close()
+			continue
+		}
+
+		setPool(replica, conf)
+		replicaClients = append(replicaClients, replica)
+	}
+
+	if len(replicaClients) > 0 {
+		log.Info(logger.Database, logger.Startup, fmt.Sprintf("%d database replicas initialized", len(replicaClients)), nil)
+	}
+}
+
+func setPool(db *sql.DB, conf config.Config) {
+	db.SetMaxOpenConns(conf.DB.Postgres.MaxOpenConnections)
+	db.SetMaxIdleConns(conf.DB.Postgres.MaxIdleConnections)
+	db.SetConnMaxLifetime(conf.DB.Postgres.MaxLifetime * time.Minute)
+}
+
 func Get() *sql.DB {
 	return dbClient
 }
 
+// Replicas returns the reachable read replicas, empty when none is configured
+func Replicas() []*sql.DB {
+	return replicaClients
+}
+
 func Close() error {
+	for _, replica := range replicaClients {
+		if cErr := replica.Close(); cErr != nil {
+			return cErr
+		}
+	}
+	replicaClients = nil
+
 	if cErr := dbClient.Close(); cErr != nil {
 		return cErr
 	}
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS trg_queue_messages_notify ON queue_messages;
+DROP FUNCTION IF EXISTS notify_queue_messages();
+DROP TABLE IF EXISTS queue_messages;
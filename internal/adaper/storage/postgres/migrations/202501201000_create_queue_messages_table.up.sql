@@ -0,0 +1,34 @@
+-- Table: queue_messages
+CREATE TABLE IF NOT EXISTS queue_messages
+(
+    id           BIGINT GENERATED BY DEFAULT AS IDENTITY
+        CONSTRAINT pk_queue_messages PRIMARY KEY,
+    uuid         uuid                     NOT NULL UNIQUE,
+    queue        VARCHAR(255)             NOT NULL,
+    body         BYTEA                    NOT NULL,
+    attempts     INTEGER                  NOT NULL DEFAULT 0,
+    last_error   TEXT,
+    available_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    created_at   TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    dead_at      TIMESTAMP WITH TIME ZONE
+);
+
+-- Index: idx_queue_messages_queue_available_at
+CREATE INDEX IF NOT EXISTS idx_queue_messages_queue_available_at
+    ON queue_messages (queue, available_at, id) WHERE dead_at IS NULL;
+
+-- Function: notify_queue_messages
+CREATE OR REPLACE FUNCTION notify_queue_messages() RETURNS TRIGGER AS
+$$
+BEGIN
+    PERFORM pg_notify('queue_messages', NEW.queue);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Trigger: trg_queue_messages_notify
+CREATE TRIGGER trg_queue_messages_notify
+    AFTER INSERT
+    ON queue_messages
+    FOR EACH ROW
+EXECUTE FUNCTION notify_queue_messages();
@@ -0,0 +1,20 @@
+-- Table: scheduled_messages
+CREATE TABLE IF NOT EXISTS scheduled_messages
+(
+    id            BIGINT GENERATED BY DEFAULT AS IDENTITY
+        CONSTRAINT pk_scheduled_messages PRIMARY KEY,
+    uuid          uuid                     NOT NULL UNIQUE,
+    queue         VARCHAR(255)             NOT NULL,
+    body          BYTEA                    NOT NULL,
+    deliver_at    TIMESTAMP WITH TIME ZONE NOT NULL,
+    attempts      INTEGER                  NOT NULL DEFAULT 0,
+    last_error    TEXT,
+    created_at    TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    updated_at    TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    dispatched_at TIMESTAMP WITH TIME ZONE,
+    cancelled_at  TIMESTAMP WITH TIME ZONE
+);
+
+-- Index: idx_scheduled_messages_deliver_at
+CREATE INDEX IF NOT EXISTS idx_scheduled_messages_deliver_at
+    ON scheduled_messages (deliver_at, id) WHERE dispatched_at IS NULL AND cancelled_at IS NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE queue_messages
+    DROP COLUMN IF EXISTS content_type,
+    DROP COLUMN IF EXISTS content_encoding;
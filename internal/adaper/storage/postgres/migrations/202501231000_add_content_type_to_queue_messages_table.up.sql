@@ -0,0 +1,3 @@
+ALTER TABLE queue_messages
+    ADD COLUMN IF NOT EXISTS content_type     VARCHAR(255) NOT NULL DEFAULT 'application/json',
+    ADD COLUMN IF NOT EXISTS content_encoding VARCHAR(255) NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+DROP INDEX CONCURRENTLY IF EXISTS idx_users_created_at_id;
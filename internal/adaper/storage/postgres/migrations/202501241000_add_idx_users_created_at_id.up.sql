@@ -0,0 +1,5 @@
+-- every listing index is built CONCURRENTLY in a migration of its own, a multi statement migration runs in a
+-- transaction which CONCURRENTLY refuses, and a plain CREATE INDEX blocks the writes to the table while it builds
+-- Index: idx_users_created_at_id, keyset pagination by created_at
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_users_created_at_id
+    ON users (created_at, id);
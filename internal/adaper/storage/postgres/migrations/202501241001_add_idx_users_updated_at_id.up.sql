@@ -0,0 +1,3 @@
+-- Index: idx_users_updated_at_id, keyset pagination by updated_at
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_users_updated_at_id
+    ON users (updated_at, id);
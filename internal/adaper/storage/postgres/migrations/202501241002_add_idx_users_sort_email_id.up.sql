@@ -0,0 +1,3 @@
+-- Index: idx_users_sort_email_id, keyset pagination by email
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_users_sort_email_id
+    ON users (COALESCE(email, ''), id);
@@ -0,0 +1,3 @@
+-- Index: idx_users_sort_first_name_id, keyset pagination by first name
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_users_sort_first_name_id
+    ON users (COALESCE(first_name, ''), id);
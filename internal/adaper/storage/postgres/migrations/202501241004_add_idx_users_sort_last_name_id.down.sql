@@ -0,0 +1 @@
+DROP INDEX CONCURRENTLY IF EXISTS idx_users_sort_last_name_id;
@@ -0,0 +1,3 @@
+-- Index: idx_users_sort_last_name_id, keyset pagination by last name
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_users_sort_last_name_id
+    ON users (COALESCE(last_name, ''), id);
@@ -0,0 +1,3 @@
+-- Index: idx_users_lower_email, case insensitive email filter
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_users_lower_email
+    ON users (lower(email));
@@ -0,0 +1 @@
+DROP INDEX CONCURRENTLY IF EXISTS idx_users_lower_first_name;
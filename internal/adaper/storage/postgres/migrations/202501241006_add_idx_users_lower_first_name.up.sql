@@ -0,0 +1,3 @@
+-- Index: idx_users_lower_first_name, name prefix filter
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_users_lower_first_name
+    ON users (lower(first_name) text_pattern_ops);
@@ -0,0 +1,3 @@
+-- Index: idx_users_lower_last_name, name prefix filter
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_users_lower_last_name
+    ON users (lower(last_name) text_pattern_ops);
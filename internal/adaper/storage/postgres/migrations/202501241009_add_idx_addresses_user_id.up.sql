@@ -0,0 +1,4 @@
+-- Index: idx_addresses_user_id, the addresses of a user
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_addresses_user_id
+    ON addresses (user_id)
+    WHERE deleted_at IS NULL;
@@ -0,0 +1 @@
+DROP INDEX CONCURRENTLY IF EXISTS idx_addresses_lower_country_city;
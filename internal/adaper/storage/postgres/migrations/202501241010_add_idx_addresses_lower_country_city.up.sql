@@ -0,0 +1,4 @@
+-- Index: idx_addresses_lower_country_city, country and city filter
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_addresses_lower_country_city
+    ON addresses (lower(country), lower(city))
+    WHERE deleted_at IS NULL;
@@ -0,0 +1,15 @@
+DROP TRIGGER IF EXISTS trg_addresses_search_text ON addresses;
+DROP TRIGGER IF EXISTS trg_users_search_text ON users;
+
+DROP FUNCTION IF EXISTS set_addresses_search_text();
+DROP FUNCTION IF EXISTS set_users_search_text();
+DROP FUNCTION IF EXISTS addresses_search_text(TEXT, TEXT, TEXT, TEXT, TEXT);
+DROP FUNCTION IF EXISTS users_search_text(TEXT, TEXT, TEXT);
+
+ALTER TABLE addresses
+    DROP COLUMN IF EXISTS search_text;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS search_text;
+
+-- pg_trgm stays installed, other schemas may use it
@@ -0,0 +1,56 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- the searched text of a row, kept lower case, so the trigram index serves case insensitive search,
+-- a plain column filled by a trigger, a GENERATED STORED column would rewrite the whole table under an
+-- ACCESS EXCLUSIVE lock, the rows which exist already are filled by the backfill migration in batches
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS search_text TEXT;
+
+ALTER TABLE addresses
+    ADD COLUMN IF NOT EXISTS search_text TEXT;
+
+-- Function: users_search_text, shared by the trigger and the backfill
+CREATE OR REPLACE FUNCTION users_search_text(first_name TEXT, last_name TEXT, email TEXT) RETURNS TEXT AS
+$$
+SELECT lower(coalesce(first_name, '') || ' ' || coalesce(last_name, '') || ' ' || coalesce(email, ''));
+$$ LANGUAGE sql IMMUTABLE;
+
+-- Function: addresses_search_text, shared by the trigger and the backfill
+CREATE OR REPLACE FUNCTION addresses_search_text(street TEXT, city TEXT, state TEXT, zip_code TEXT,
+                                                 country TEXT) RETURNS TEXT AS
+$$
+SELECT lower(coalesce(street, '') || ' ' || coalesce(city, '') || ' ' || coalesce(state, '') || ' ' ||
+             coalesce(zip_code, '') || ' ' || coalesce(country, ''));
+$$ LANGUAGE sql IMMUTABLE;
+
+-- Function: set_users_search_text
+CREATE OR REPLACE FUNCTION set_users_search_text() RETURNS TRIGGER AS
+$$
+BEGIN
+    NEW.search_text := users_search_text(NEW.first_name, NEW.last_name, NEW.email);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Function: set_addresses_search_text
+CREATE OR REPLACE FUNCTION set_addresses_search_text() RETURNS TRIGGER AS
+$$
+BEGIN
+    NEW.search_text := addresses_search_text(NEW.street, NEW.city, NEW.state, NEW.zip_code, NEW.country);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Trigger: trg_users_search_text
+CREATE TRIGGER trg_users_search_text
+    BEFORE INSERT OR UPDATE OF first_name, last_name, email
+    ON users
+    FOR EACH ROW
+EXECUTE FUNCTION set_users_search_text();
+
+-- Trigger: trg_addresses_search_text
+CREATE TRIGGER trg_addresses_search_text
+    BEFORE INSERT OR UPDATE OF street, city, state, zip_code, country
+    ON addresses
+    FOR EACH ROW
+EXECUTE FUNCTION set_addresses_search_text();
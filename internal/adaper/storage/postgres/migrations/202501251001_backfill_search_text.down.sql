@@ -0,0 +1 @@
+-- the backfilled search text goes with the columns 202501251000_add_user_search.down.sql drops
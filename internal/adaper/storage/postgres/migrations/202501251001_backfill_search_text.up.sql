@@ -0,0 +1,36 @@
+-- fills the search text of the rows which existed before the triggers, one short transaction per batch,
+-- so the writes to the tables are never blocked for long, this migration is a single statement, so it
+-- runs outside a transaction block and may COMMIT
+DO
+$$
+    DECLARE
+        batch   CONSTANT BIGINT := 10000;
+        last_id BIGINT          := 0;
+        max_id  BIGINT;
+    BEGIN
+        SELECT coalesce(max(id), 0) INTO max_id FROM users;
+        WHILE last_id < max_id
+            LOOP
+                UPDATE users
+                SET search_text = users_search_text(first_name, last_name, email)
+                WHERE id > last_id
+                  AND id <= last_id + batch
+                  AND search_text IS NULL;
+                last_id := last_id + batch;
+                COMMIT;
+            END LOOP;
+
+        last_id := 0;
+        SELECT coalesce(max(id), 0) INTO max_id FROM addresses;
+        WHILE last_id < max_id
+            LOOP
+                UPDATE addresses
+                SET search_text = addresses_search_text(street, city, state, zip_code, country)
+                WHERE id > last_id
+                  AND id <= last_id + batch
+                  AND search_text IS NULL;
+                last_id := last_id + batch;
+                COMMIT;
+            END LOOP;
+    END
+$$;
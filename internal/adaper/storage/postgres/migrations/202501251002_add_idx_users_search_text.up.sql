@@ -0,0 +1,5 @@
+-- Index: idx_users_search_text, a GiST index returns the nearest rows first, so a search reads only the top rows,
+-- built CONCURRENTLY in a migration of its own, so the writes to users go on while it builds
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_users_search_text
+    ON users USING gist (search_text gist_trgm_ops)
+    WHERE deleted_at IS NULL;
@@ -0,0 +1 @@
+DROP INDEX CONCURRENTLY IF EXISTS idx_addresses_search_text;
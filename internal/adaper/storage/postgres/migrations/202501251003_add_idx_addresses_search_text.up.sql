@@ -0,0 +1,4 @@
+-- Index: idx_addresses_search_text
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_addresses_search_text
+    ON addresses USING gist (search_text gist_trgm_ops)
+    WHERE deleted_at IS NULL;
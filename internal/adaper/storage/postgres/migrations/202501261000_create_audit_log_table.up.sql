@@ -0,0 +1,19 @@
+-- Table: audit_log, the rows do not refer to the users, so the history outlives a hard deleted user
+CREATE TABLE IF NOT EXISTS audit_log
+(
+    id          BIGINT GENERATED BY DEFAULT AS IDENTITY
+        CONSTRAINT pk_audit_log PRIMARY KEY,
+    user_uuid   uuid                     NOT NULL,
+    entity      VARCHAR(32)              NOT NULL,
+    entity_uuid uuid                     NOT NULL,
+    action      VARCHAR(32)              NOT NULL,
+    actor_id    INTEGER,
+    actor_uuid  uuid,
+    actor_name  VARCHAR(128),
+    changes     JSONB                    NOT NULL DEFAULT '{}',
+    created_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+-- Index: idx_audit_log_user_uuid_id, the history of a user newest first
+CREATE INDEX IF NOT EXISTS idx_audit_log_user_uuid_id
+    ON audit_log (user_uuid, id DESC);
@@ -0,0 +1,5 @@
+ALTER TABLE addresses
+    DROP COLUMN IF EXISTS version;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS version;
@@ -0,0 +1,6 @@
+-- the version of a row grows on every write, a write expecting another version lost a race
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+ALTER TABLE addresses
+    ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
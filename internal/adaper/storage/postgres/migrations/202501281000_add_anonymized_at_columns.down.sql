@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_audit_log_entity_uuid;
+DROP INDEX IF EXISTS idx_addresses_purge;
+DROP INDEX IF EXISTS idx_users_purge;
+
+ALTER TABLE addresses
+    DROP COLUMN IF EXISTS anonymized_at;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS anonymized_at;
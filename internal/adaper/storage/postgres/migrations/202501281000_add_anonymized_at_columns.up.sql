@@ -0,0 +1,20 @@
+-- an anonymized row is kept without its personal data, the purge does not pick it again
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS anonymized_at TIMESTAMP WITH TIME ZONE;
+
+ALTER TABLE addresses
+    ADD COLUMN IF NOT EXISTS anonymized_at TIMESTAMP WITH TIME ZONE;
+
+-- Index: idx_users_purge, the soft deleted users the purge has not handled yet
+CREATE INDEX IF NOT EXISTS idx_users_purge
+    ON users (deleted_at, id)
+    WHERE deleted_at IS NOT NULL AND anonymized_at IS NULL;
+
+-- Index: idx_addresses_purge, the soft deleted addresses the purge has not handled yet
+CREATE INDEX IF NOT EXISTS idx_addresses_purge
+    ON addresses (deleted_at, id)
+    WHERE deleted_at IS NOT NULL AND anonymized_at IS NULL;
+
+-- Index: idx_audit_log_entity_uuid, the purge redacts the entries of a purged address
+CREATE INDEX IF NOT EXISTS idx_audit_log_entity_uuid
+    ON audit_log (entity_uuid);
@@ -0,0 +1,9 @@
+ALTER TABLE queue_messages
+    ADD COLUMN IF NOT EXISTS type VARCHAR(255) NOT NULL DEFAULT '';
+
+-- the plain JSON messages which are already queued get their envelope type
+UPDATE queue_messages
+SET type = COALESCE(convert_from(body, 'UTF8')::jsonb ->> 'type', '')
+WHERE content_type = 'application/json'
+  AND content_encoding = ''
+  AND type = '';
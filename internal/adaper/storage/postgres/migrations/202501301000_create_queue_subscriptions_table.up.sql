@@ -0,0 +1,8 @@
+-- Table: queue_subscriptions
+-- the events are routed names, the postgres driver stores them only for the names a consumer subscribed to
+CREATE TABLE IF NOT EXISTS queue_subscriptions
+(
+    queue      VARCHAR(255)             NOT NULL
+        CONSTRAINT pk_queue_subscriptions PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
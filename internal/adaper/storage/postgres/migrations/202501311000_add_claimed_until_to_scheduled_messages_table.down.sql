@@ -0,0 +1,2 @@
+ALTER TABLE scheduled_messages
+    DROP COLUMN IF EXISTS claimed_until;
@@ -0,0 +1,4 @@
+-- a dispatcher claims the due messages and commits before it produces them, claimed_until hides them
+-- from the other dispatchers and the cancel meanwhile, a dispatcher which dies leaves the claim to expire
+ALTER TABLE scheduled_messages
+    ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMP WITH TIME ZONE;
@@ -1,11 +1,15 @@
 package userrepository
 
 import (
+	"context"
 	"database/sql"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
 	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
 	"github.com/mohsenabedy91/Sikabiz/pkg/metrics"
 	"github.com/mohsenabedy91/Sikabiz/pkg/serviceerror"
+	"github.com/mohsenabedy91/Sikabiz/pkg/tracing"
 )
 
 type AddressRepository struct {
@@ -20,25 +24,35 @@ func NewAddressRepository(log logger.Logger, tx *sql.Tx) *AddressRepository {
 	}
 }
 
-func (r *AddressRepository) Save(userID uint64, addresses []*domain.Address) error {
-	stmt, err := r.tx.Prepare(`INSERT INTO addresses (street, city, state, zip_code, country, user_id) VALUES ($1, $2, $3, $4, $5, $6)`)
+func (r *AddressRepository) Save(ctx context.Context, userID uint64, addresses []*domain.Address) error {
+	ctx, span := startSpan(ctx, "addresses", "Save")
+	err := r.save(ctx, userID, addresses)
+	tracing.End(span, err)
+
+	return err
+}
+
+func (r *AddressRepository) save(ctx context.Context, userID uint64, addresses []*domain.Address) error {
+	stmt, err := r.tx.PrepareContext(ctx, `INSERT INTO addresses (street, city, state, zip_code, country, user_id, created_by, updated_by)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+				RETURNING uuid, version`)
 	if err != nil {
 		metrics.DbCall.WithLabelValues("addresses", "Save", "Failed").Inc()
 
-		r.log.Error(logger.Database, logger.DatabasePrepare, err.Error(), nil)
-		return serviceerror.NewServerError()
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabasePrepare, err.Error(), nil)
+		return dbError(err)
 	}
 	defer func(stmt *sql.Stmt) {
 		if err = stmt.Close(); err != nil {
-			r.log.Error(logger.Database, logger.DatabaseInsert, err.Error(), nil)
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseInsert, err.Error(), nil)
 		}
 	}(stmt)
 
 	for _, address := range addresses {
-		if _, err = stmt.Exec(address.Street, address.City, address.State, address.ZipCode, address.Country, userID); err != nil {
+		if err = stmt.QueryRowContext(ctx, address.Street, address.City, address.State, address.ZipCode, address.Country, userID, address.CreatedBy).Scan(&address.UUID, &address.Version); err != nil {
 			metrics.DbCall.WithLabelValues("addresses", "Save", "Failed").Inc()
 
-			r.log.Error(logger.Database, logger.DatabaseInsert, err.Error(), map[logger.ExtraKey]interface{}{
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseInsert, err.Error(), map[logger.ExtraKey]interface{}{
 				"userID":   userID,
 				"street":   address.Street,
 				"city":     address.City,
@@ -46,7 +60,7 @@ func (r *AddressRepository) Save(userID uint64, addresses []*domain.Address) err
 				"zip_code": address.ZipCode,
 				"country":  address.Country,
 			})
-			return serviceerror.NewServerError()
+			return dbError(err)
 		}
 	}
 
@@ -54,3 +68,221 @@ func (r *AddressRepository) Save(userID uint64, addresses []*domain.Address) err
 
 	return nil
 }
+
+func (r *AddressRepository) ListByUserID(ctx context.Context, userID uint64) ([]*domain.Address, error) {
+	ctx, span := startSpan(ctx, "addresses", "ListByUserID")
+	addresses, err := r.list(ctx, userID, nil)
+	tracing.End(span, err)
+
+	return addresses, err
+}
+
+func (r *AddressRepository) GetByID(ctx context.Context, userID uint64, id uuid.UUID) (*domain.Address, error) {
+	ctx, span := startSpan(ctx, "addresses", "GetByID")
+	addresses, err := r.list(ctx, userID, &id)
+	if err == nil && len(addresses) == 0 {
+		metrics.DbCall.WithLabelValues("addresses", "GetByID", "NotFound").Inc()
+		err = serviceerror.New(serviceerror.RecordNotFound)
+	}
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return addresses[0], nil
+}
+
+// list returns the addresses of the user which are not deleted, only the one with id when it is set
+func (r *AddressRepository) list(ctx context.Context, userID uint64, id *uuid.UUID) ([]*domain.Address, error) {
+	rows, err := r.tx.QueryContext(
+		ctx,
+		`SELECT id, uuid, street, city, state, zip_code, country, version, created_at, updated_at FROM addresses
+				WHERE user_id = $1 AND deleted_at IS NULL AND ($2::uuid IS NULL OR uuid = $2)
+				ORDER BY id`,
+		userID,
+		id,
+	)
+	if err != nil {
+		metrics.DbCall.WithLabelValues("addresses", "List", "Failed").Inc()
+
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+		return nil, dbError(err)
+	}
+
+	defer func(rows *sql.Rows) {
+		if err = rows.Close(); err != nil {
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+		}
+	}(rows)
+
+	var addresses []*domain.Address
+	for rows.Next() {
+		var address domain.Address
+		if err = rows.Scan(
+			&address.ID,
+			&address.UUID,
+			&address.Street,
+			&address.City,
+			&address.State,
+			&address.ZipCode,
+			&address.Country,
+			&address.Version,
+			&address.CreatedAt,
+			&address.UpdatedAt,
+		); err != nil {
+			metrics.DbCall.WithLabelValues("addresses", "List", "Failed").Inc()
+
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+			return nil, dbError(err)
+		}
+
+		addresses = append(addresses, &address)
+	}
+
+	if err = rows.Err(); err != nil {
+		metrics.DbCall.WithLabelValues("addresses", "List", "Failed").Inc()
+
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+		return nil, dbError(err)
+	}
+
+	metrics.DbCall.WithLabelValues("addresses", "List", "Success").Inc()
+
+	return addresses, nil
+}
+
+// ListByUserIDs loads the addresses of a page of users in one query
+func (r *AddressRepository) ListByUserIDs(ctx context.Context, userIDs []uint64) (map[uint64][]*domain.Address, error) {
+	ctx, span := startSpan(ctx, "addresses", "ListByUserIDs")
+	addresses, err := r.listByUserIDs(ctx, userIDs)
+	tracing.End(span, err)
+
+	return addresses, err
+}
+
+func (r *AddressRepository) listByUserIDs(ctx context.Context, userIDs []uint64) (map[uint64][]*domain.Address, error) {
+	addresses := make(map[uint64][]*domain.Address, len(userIDs))
+	if len(userIDs) == 0 {
+		return addresses, nil
+	}
+
+	ids := make([]int64, 0, len(userIDs))
+	for _, id := range userIDs {
+		ids = append(ids, int64(id))
+	}
+
+	rows, err := r.tx.QueryContext(
+		ctx,
+		`SELECT user_id, id, uuid, street, city, state, zip_code, country, version, created_at, updated_at FROM addresses
+				WHERE user_id = ANY($1) AND deleted_at IS NULL
+				ORDER BY id`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		metrics.DbCall.WithLabelValues("addresses", "ListByUserIDs", "Failed").Inc()
+
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+		return nil, dbError(err)
+	}
+
+	defer func(rows *sql.Rows) {
+		if err = rows.Close(); err != nil {
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+		}
+	}(rows)
+
+	for rows.Next() {
+		var userID uint64
+		var address domain.Address
+		if err = rows.Scan(
+			&userID,
+			&address.ID,
+			&address.UUID,
+			&address.Street,
+			&address.City,
+			&address.State,
+			&address.ZipCode,
+			&address.Country,
+			&address.Version,
+			&address.CreatedAt,
+			&address.UpdatedAt,
+		); err != nil {
+			metrics.DbCall.WithLabelValues("addresses", "ListByUserIDs", "Failed").Inc()
+
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+			return nil, dbError(err)
+		}
+
+		addresses[userID] = append(addresses[userID], &address)
+	}
+
+	if err = rows.Err(); err != nil {
+		metrics.DbCall.WithLabelValues("addresses", "ListByUserIDs", "Failed").Inc()
+
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+		return nil, dbError(err)
+	}
+
+	metrics.DbCall.WithLabelValues("addresses", "ListByUserIDs", "Success").Inc()
+
+	return addresses, nil
+}
+
+func (r *AddressRepository) Update(ctx context.Context, userID uint64, address *domain.Address) error {
+	ctx, span := startSpan(ctx, "addresses", "Update")
+	err := exec(
+		ctx,
+		r.tx,
+		r.log,
+		"addresses",
+		"Update",
+		logger.DatabaseUpdate,
+		`UPDATE addresses
+				SET street = $3, city = $4, state = $5, zip_code = $6, country = $7,
+				    updated_by = NULLIF($8, 0), updated_at = now(), version = version + 1
+				WHERE user_id = $1 AND uuid = $2 AND deleted_at IS NULL AND version = $9`,
+		userID,
+		address.UUID,
+		address.Street,
+		address.City,
+		address.State,
+		address.ZipCode,
+		address.Country,
+		int64(address.UpdatedBy),
+		address.Version,
+	)
+	err = versioned(ctx, r.tx, r.log, "addresses", "Update", err,
+		`SELECT EXISTS (SELECT 1 FROM addresses WHERE user_id = $1 AND uuid = $2 AND deleted_at IS NULL)`, userID, address.UUID)
+	tracing.End(span, err)
+	if err == nil {
+		address.Version++
+	}
+
+	return serviceError(err)
+}
+
+func (r *AddressRepository) SoftDelete(ctx context.Context, userID uint64, address *domain.Address) error {
+	ctx, span := startSpan(ctx, "addresses", "SoftDelete")
+	err := exec(
+		ctx,
+		r.tx,
+		r.log,
+		"addresses",
+		"SoftDelete",
+		logger.DatabaseUpdate,
+		`UPDATE addresses SET deleted_by = NULLIF($3, 0), deleted_at = now(), version = version + 1
+				WHERE user_id = $1 AND uuid = $2 AND deleted_at IS NULL AND version = $4`,
+		userID,
+		address.UUID,
+		int64(address.DeleteBy),
+		address.Version,
+	)
+	err = versioned(ctx, r.tx, r.log, "addresses", "SoftDelete", err,
+		`SELECT EXISTS (SELECT 1 FROM addresses WHERE user_id = $1 AND uuid = $2 AND deleted_at IS NULL)`, userID, address.UUID)
+	tracing.End(span, err)
+	if err == nil {
+		address.Version++
+	}
+
+	return serviceError(err)
+}
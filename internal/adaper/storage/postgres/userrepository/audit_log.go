@@ -0,0 +1,222 @@
+package userrepository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"github.com/mohsenabedy91/Sikabiz/pkg/metrics"
+	"github.com/mohsenabedy91/Sikabiz/pkg/serviceerror"
+	"github.com/mohsenabedy91/Sikabiz/pkg/tracing"
+	"strconv"
+)
+
+type AuditLogRepository struct {
+	log logger.Logger
+	tx  *sql.Tx
+}
+
+func NewAuditLogRepository(log logger.Logger, tx *sql.Tx) *AuditLogRepository {
+	return &AuditLogRepository{
+		log: log,
+		tx:  tx,
+	}
+}
+
+// Save writes the entries in the transaction of the change they record, so a rolled back change
+// leaves no entry
+func (r *AuditLogRepository) Save(ctx context.Context, logs ...*domain.AuditLog) error {
+	ctx, span := startSpan(ctx, "audit_log", "Save")
+	err := r.save(ctx, logs)
+	tracing.End(span, err)
+
+	return err
+}
+
+func (r *AuditLogRepository) save(ctx context.Context, logs []*domain.AuditLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	stmt, err := r.tx.PrepareContext(
+		ctx,
+		`INSERT INTO audit_log (user_uuid, entity, entity_uuid, action, actor_id, actor_uuid, actor_name, changes)
+				VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6, NULLIF($7, ''), $8)
+				RETURNING id, created_at`,
+	)
+	if err != nil {
+		metrics.DbCall.WithLabelValues("audit_log", "Save", "Failed").Inc()
+
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabasePrepare, err.Error(), nil)
+		return dbError(err)
+	}
+	defer func(stmt *sql.Stmt) {
+		if err = stmt.Close(); err != nil {
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseInsert, err.Error(), nil)
+		}
+	}(stmt)
+
+	for _, entry := range logs {
+		if entry.Changes == nil {
+			entry.Changes = map[string]domain.AuditChange{}
+		}
+		changes, marshalErr := json.Marshal(entry.Changes)
+		if marshalErr != nil {
+			metrics.DbCall.WithLabelValues("audit_log", "Save", "Failed").Inc()
+
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseInsert, marshalErr.Error(), nil)
+			return serviceerror.NewServerError()
+		}
+
+		var actorUUID uuid.NullUUID
+		if entry.Actor.IsUser() {
+			actorUUID = uuid.NullUUID{UUID: entry.Actor.UUID, Valid: true}
+		}
+
+		if err = stmt.QueryRowContext(
+			ctx,
+			entry.UserUUID,
+			entry.Entity,
+			entry.EntityUUID,
+			entry.Action,
+			int64(entry.Actor.ID),
+			actorUUID,
+			entry.Actor.Name,
+			changes,
+		).Scan(&entry.ID, &entry.CreatedAt); err != nil {
+			metrics.DbCall.WithLabelValues("audit_log", "Save", "Failed").Inc()
+
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseInsert, err.Error(), map[logger.ExtraKey]interface{}{
+				logger.InsertDBArg: entry,
+			})
+			return dbError(err)
+		}
+	}
+
+	metrics.DbCall.WithLabelValues("audit_log", "Save", "Success").Inc()
+
+	return nil
+}
+
+func (r *AuditLogRepository) ListByUserUUID(ctx context.Context, userUUID uuid.UUID, query domain.AuditLogQuery) (*domain.AuditLogPage, error) {
+	ctx, span := startSpan(ctx, "audit_log", "ListByUserUUID")
+	page, err := r.listByUserUUID(ctx, userUUID, query)
+	tracing.End(span, err)
+
+	return page, err
+}
+
+func (r *AuditLogRepository) listByUserUUID(ctx context.Context, userUUID uuid.UUID, query domain.AuditLogQuery) (*domain.AuditLogPage, error) {
+	limit := query.Limit
+	if limit < 1 {
+		limit = domain.DefaultAuditLogLimit
+	}
+	if limit > domain.MaxAuditLogLimit {
+		limit = domain.MaxAuditLogLimit
+	}
+
+	// the cursor is the id of the last entry of the previous page, the ids only grow
+	var before sql.NullInt64
+	if query.Cursor != "" {
+		id, err := decodeAuditLogCursor(query.Cursor)
+		if err != nil {
+			return nil, serviceerror.New(serviceerror.InvalidCursor)
+		}
+		before = sql.NullInt64{Int64: id, Valid: true}
+	}
+
+	rows, err := r.tx.QueryContext(
+		ctx,
+		`SELECT id, user_uuid, entity, entity_uuid, action, COALESCE(actor_id, 0), actor_uuid, COALESCE(actor_name, ''),
+       				changes, created_at FROM audit_log
+				WHERE user_uuid = $1 AND ($2::bigint IS NULL OR id < $2)
+				ORDER BY id DESC
+				LIMIT $3`,
+		userUUID,
+		before,
+		limit+1,
+	)
+	if err != nil {
+		metrics.DbCall.WithLabelValues("audit_log", "ListByUserUUID", "Failed").Inc()
+
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+		return nil, dbError(err)
+	}
+
+	defer func(rows *sql.Rows) {
+		if err = rows.Close(); err != nil {
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+		}
+	}(rows)
+
+	logs := make([]*domain.AuditLog, 0, limit)
+	for rows.Next() {
+		var entry domain.AuditLog
+		var actorUUID uuid.NullUUID
+		var changes []byte
+		if err = rows.Scan(
+			&entry.ID,
+			&entry.UserUUID,
+			&entry.Entity,
+			&entry.EntityUUID,
+			&entry.Action,
+			&entry.Actor.ID,
+			&actorUUID,
+			&entry.Actor.Name,
+			&changes,
+			&entry.CreatedAt,
+		); err != nil {
+			metrics.DbCall.WithLabelValues("audit_log", "ListByUserUUID", "Failed").Inc()
+
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+			return nil, dbError(err)
+		}
+		entry.Actor.UUID = actorUUID.UUID
+
+		if err = json.Unmarshal(changes, &entry.Changes); err != nil {
+			metrics.DbCall.WithLabelValues("audit_log", "ListByUserUUID", "Failed").Inc()
+
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+			return nil, dbError(err)
+		}
+
+		logs = append(logs, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		metrics.DbCall.WithLabelValues("audit_log", "ListByUserUUID", "Failed").Inc()
+
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+		return nil, dbError(err)
+	}
+
+	metrics.DbCall.WithLabelValues("audit_log", "ListByUserUUID", "Success").Inc()
+
+	page := &domain.AuditLogPage{
+		Logs:  logs,
+		Limit: limit,
+	}
+	if len(logs) > limit {
+		page.Logs = logs[:limit]
+		page.HasMore = true
+		page.NextCursor = encodeAuditLogCursor(page.Logs[limit-1].ID)
+	}
+
+	return page, nil
+}
+
+func encodeAuditLogCursor(id uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(id, 10)))
+}
+
+func decodeAuditLogCursor(value string) (int64, error) {
+	body, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(string(body), 10, 64)
+}
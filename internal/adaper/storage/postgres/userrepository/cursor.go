@@ -0,0 +1,63 @@
+package userrepository
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+)
+
+// CursorSealer encrypts the listing cursors, the sort value a cursor carries may be personal data like
+// the email, sealed it does not end up readable in the urls and the logs the cursor passes through,
+// a nil sealer keeps the cursors plain
+type CursorSealer struct {
+	aead cipher.AEAD
+}
+
+// NewCursorSealer derives the key from secret, an empty secret returns a nil sealer
+func NewCursorSealer(secret string) (*CursorSealer, error) {
+	if secret == "" {
+		return nil, nil
+	}
+
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CursorSealer{aead: aead}, nil
+}
+
+func (r *CursorSealer) seal(body []byte) string {
+	if r == nil {
+		return base64.RawURLEncoding.EncodeToString(body)
+	}
+
+	nonce := make([]byte, r.aead.NonceSize())
+	_, _ = rand.Read(nonce)
+
+	return base64.RawURLEncoding.EncodeToString(r.aead.Seal(nonce, nonce, body, nil))
+}
+
+// open fails for a cursor which was changed or sealed with another secret
+func (r *CursorSealer) open(value string) ([]byte, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || r == nil {
+		return sealed, err
+	}
+
+	if len(sealed) < r.aead.NonceSize() {
+		return nil, errors.New("cursor is too short")
+	}
+	nonce, body := sealed[:r.aead.NonceSize()], sealed[r.aead.NonceSize():]
+
+	return r.aead.Open(nil, nonce, body, nil)
+}
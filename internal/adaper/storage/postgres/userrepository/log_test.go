@@ -0,0 +1,40 @@
+package userrepository
+
+import (
+	"context"
+	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+)
+
+// nopLogger keeps the repository logs out of the test output
+type nopLogger struct{}
+
+func (r nopLogger) Init(string) {}
+
+func (r nopLogger) Debug(logger.Category, logger.SubCategory, string, map[logger.ExtraKey]interface{}) {
+}
+
+func (r nopLogger) DebugF(string, ...interface{}) {}
+
+func (r nopLogger) Info(logger.Category, logger.SubCategory, string, map[logger.ExtraKey]interface{}) {
+}
+
+func (r nopLogger) InfoF(string, ...interface{}) {}
+
+func (r nopLogger) Warn(logger.Category, logger.SubCategory, string, map[logger.ExtraKey]interface{}) {
+}
+
+func (r nopLogger) WarnF(string, ...interface{}) {}
+
+func (r nopLogger) Error(logger.Category, logger.SubCategory, string, map[logger.ExtraKey]interface{}) {
+}
+
+func (r nopLogger) ErrorF(string, ...interface{}) {}
+
+func (r nopLogger) Fatal(logger.Category, logger.SubCategory, string, map[logger.ExtraKey]interface{}) {
+}
+
+func (r nopLogger) FatalF(string, ...interface{}) {}
+
+func (r nopLogger) WithContext(context.Context) logger.Logger {
+	return r
+}
@@ -0,0 +1,258 @@
+package userrepository
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"github.com/mohsenabedy91/Sikabiz/pkg/metrics"
+	"github.com/mohsenabedy91/Sikabiz/pkg/tracing"
+	"time"
+)
+
+type PurgeRepository struct {
+	log logger.Logger
+	tx  *sql.Tx
+}
+
+func NewPurgeRepository(log logger.Logger, tx *sql.Tx) *PurgeRepository {
+	return &PurgeRepository{
+		log: log,
+		tx:  tx,
+	}
+}
+
+// ListUsers SKIP LOCKED leaves the users a running request holds to the next run
+func (r *PurgeRepository) ListUsers(ctx context.Context, deletedBefore time.Time, afterID uint64, limit int) ([]domain.PurgeCandidate, error) {
+	ctx, span := startSpan(ctx, "users", "ListPurgeable")
+	candidates, err := r.list(
+		ctx,
+		"users",
+		`SELECT id, uuid, uuid, deleted_at FROM users
+				WHERE deleted_at < $1 AND anonymized_at IS NULL AND id > $2
+				ORDER BY id
+				LIMIT $3
+				FOR UPDATE SKIP LOCKED`,
+		domain.AuditEntityUser,
+		deletedBefore,
+		afterID,
+		limit,
+	)
+	tracing.End(span, err)
+
+	return candidates, err
+}
+
+func (r *PurgeRepository) ListAddresses(ctx context.Context, deletedBefore time.Time, afterID uint64, limit int) ([]domain.PurgeCandidate, error) {
+	ctx, span := startSpan(ctx, "addresses", "ListPurgeable")
+	candidates, err := r.list(
+		ctx,
+		"addresses",
+		`SELECT a.id, a.uuid, u.uuid, a.deleted_at FROM addresses AS a
+				INNER JOIN users AS u ON u.id = a.user_id
+				WHERE a.deleted_at < $1 AND a.anonymized_at IS NULL AND a.id > $2
+				ORDER BY a.id
+				LIMIT $3
+				FOR UPDATE OF a SKIP LOCKED`,
+		domain.AuditEntityAddress,
+		deletedBefore,
+		afterID,
+		limit,
+	)
+	tracing.End(span, err)
+
+	return candidates, err
+}
+
+func (r *PurgeRepository) list(ctx context.Context, table string, query string, entity string, args ...interface{}) ([]domain.PurgeCandidate, error) {
+	rows, err := r.tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		metrics.DbCall.WithLabelValues(table, "ListPurgeable", "Failed").Inc()
+
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), map[logger.ExtraKey]interface{}{
+			logger.SelectDBArg: args,
+		})
+		return nil, dbError(err)
+	}
+
+	defer func(rows *sql.Rows) {
+		if err = rows.Close(); err != nil {
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+		}
+	}(rows)
+
+	var candidates []domain.PurgeCandidate
+	for rows.Next() {
+		candidate := domain.PurgeCandidate{Entity: entity}
+		if err = rows.Scan(&candidate.ID, &candidate.UUID, &candidate.UserUUID, &candidate.DeletedAt); err != nil {
+			metrics.DbCall.WithLabelValues(table, "ListPurgeable", "Failed").Inc()
+
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+			return nil, dbError(err)
+		}
+
+		candidates = append(candidates, candidate)
+	}
+
+	if err = rows.Err(); err != nil {
+		metrics.DbCall.WithLabelValues(table, "ListPurgeable", "Failed").Inc()
+
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+		return nil, dbError(err)
+	}
+
+	metrics.DbCall.WithLabelValues(table, "ListPurgeable", "Success").Inc()
+
+	return candidates, nil
+}
+
+// DeleteUsers the modifier columns are cleared first, their foreign keys would refuse the delete
+func (r *PurgeRepository) DeleteUsers(ctx context.Context, ids []uint64) error {
+	ctx, span := startSpan(ctx, "users", "Purge")
+	err := r.exec(
+		ctx,
+		"users",
+		"Purge",
+		logger.DatabaseDelete,
+		[]string{
+			`UPDATE users
+					SET created_by = CASE WHEN created_by = ANY($1) THEN NULL ELSE created_by END,
+					    updated_by = CASE WHEN updated_by = ANY($1) THEN NULL ELSE updated_by END,
+					    deleted_by = CASE WHEN deleted_by = ANY($1) THEN NULL ELSE deleted_by END
+					WHERE created_by = ANY($1) OR updated_by = ANY($1) OR deleted_by = ANY($1)`,
+			`UPDATE addresses
+					SET created_by = CASE WHEN created_by = ANY($1) THEN NULL ELSE created_by END,
+					    updated_by = CASE WHEN updated_by = ANY($1) THEN NULL ELSE updated_by END,
+					    deleted_by = CASE WHEN deleted_by = ANY($1) THEN NULL ELSE deleted_by END
+					WHERE created_by = ANY($1) OR updated_by = ANY($1) OR deleted_by = ANY($1)`,
+			`DELETE FROM addresses WHERE user_id = ANY($1)`,
+			`DELETE FROM users WHERE id = ANY($1)`,
+		},
+		pq.Array(int64s(ids)),
+	)
+	tracing.End(span, err)
+
+	return err
+}
+
+// AnonymizeUsers clears the personal data of the users and of all their addresses
+func (r *PurgeRepository) AnonymizeUsers(ctx context.Context, ids []uint64) error {
+	ctx, span := startSpan(ctx, "users", "Anonymize")
+	err := r.exec(
+		ctx,
+		"users",
+		"Anonymize",
+		logger.DatabaseUpdate,
+		[]string{
+			`UPDATE addresses
+					SET street = NULL, city = NULL, state = NULL, zip_code = NULL, country = NULL,
+					    anonymized_at = now(), deleted_at = COALESCE(deleted_at, now())
+					WHERE user_id = ANY($1)`,
+			`UPDATE users
+					SET first_name = NULL, last_name = NULL, email = NULL, phone_number = NULL, anonymized_at = now()
+					WHERE id = ANY($1)`,
+		},
+		pq.Array(int64s(ids)),
+	)
+	tracing.End(span, err)
+
+	return err
+}
+
+func (r *PurgeRepository) DeleteAddresses(ctx context.Context, ids []uint64) error {
+	ctx, span := startSpan(ctx, "addresses", "Purge")
+	err := r.exec(
+		ctx,
+		"addresses",
+		"Purge",
+		logger.DatabaseDelete,
+		[]string{`DELETE FROM addresses WHERE id = ANY($1)`},
+		pq.Array(int64s(ids)),
+	)
+	tracing.End(span, err)
+
+	return err
+}
+
+func (r *PurgeRepository) AnonymizeAddresses(ctx context.Context, ids []uint64) error {
+	ctx, span := startSpan(ctx, "addresses", "Anonymize")
+	err := r.exec(
+		ctx,
+		"addresses",
+		"Anonymize",
+		logger.DatabaseUpdate,
+		[]string{
+			`UPDATE addresses
+					SET street = NULL, city = NULL, state = NULL, zip_code = NULL, country = NULL, anonymized_at = now()
+					WHERE id = ANY($1)`,
+		},
+		pq.Array(int64s(ids)),
+	)
+	tracing.End(span, err)
+
+	return err
+}
+
+func (r *PurgeRepository) RedactAuditLog(ctx context.Context, userUUIDs []uuid.UUID, addressUUIDs []uuid.UUID) error {
+	ctx, span := startSpan(ctx, "audit_log", "Redact")
+	err := r.exec(
+		ctx,
+		"audit_log",
+		"Redact",
+		logger.DatabaseUpdate,
+		[]string{
+			`UPDATE audit_log
+					SET changes = (
+					    SELECT COALESCE(jsonb_object_agg(field, '{"old": null, "new": null}'::jsonb), '{}'::jsonb)
+					    FROM jsonb_object_keys(changes) AS field
+					)
+					WHERE (user_uuid = ANY($1::uuid[]) OR entity_uuid = ANY($2::uuid[])) AND changes <> '{}'::jsonb`,
+		},
+		pq.Array(uuidStrings(userUUIDs)),
+		pq.Array(uuidStrings(addressUUIDs)),
+	)
+	tracing.End(span, err)
+
+	return err
+}
+
+// exec runs the statements in order, a statement touching no row is fine
+func (r *PurgeRepository) exec(
+	ctx context.Context,
+	table string,
+	operation string,
+	subCategory logger.SubCategory,
+	queries []string,
+	args ...interface{},
+) error {
+	for _, query := range queries {
+		if _, err := r.tx.ExecContext(ctx, query, args...); err != nil {
+			metrics.DbCall.WithLabelValues(table, operation, "Failed").Inc()
+
+			r.log.WithContext(ctx).Error(logger.Database, subCategory, err.Error(), nil)
+			return dbError(err)
+		}
+	}
+
+	metrics.DbCall.WithLabelValues(table, operation, "Success").Inc()
+
+	return nil
+}
+
+func int64s(ids []uint64) []int64 {
+	values := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		values = append(values, int64(id))
+	}
+	return values
+}
+
+func uuidStrings(ids []uuid.UUID) []string {
+	values := make([]string, 0, len(ids))
+	for _, id := range ids {
+		values = append(values, id.String())
+	}
+	return values
+}
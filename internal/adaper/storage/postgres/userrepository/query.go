@@ -0,0 +1,114 @@
+package userrepository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"github.com/mohsenabedy91/Sikabiz/pkg/metrics"
+	"github.com/mohsenabedy91/Sikabiz/pkg/serviceerror"
+)
+
+const (
+	uniqueViolation      = "23505"
+	foreignKeyViolation  = "23503"
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+)
+
+// exec returns NoRowsEffected when the statement did not touch a row, the database errors
+// are returned as they are, so the callers can map them
+func exec(
+	ctx context.Context,
+	tx *sql.Tx,
+	log logger.Logger,
+	table string,
+	operation string,
+	subCategory logger.SubCategory,
+	query string,
+	args ...interface{},
+) error {
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		metrics.DbCall.WithLabelValues(table, operation, "Failed").Inc()
+
+		log.WithContext(ctx).Error(logger.Database, subCategory, err.Error(), map[logger.ExtraKey]interface{}{
+			logger.InsertDBArg: args,
+		})
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		metrics.DbCall.WithLabelValues(table, operation, "Failed").Inc()
+
+		log.WithContext(ctx).Error(logger.Database, subCategory, err.Error(), nil)
+		return err
+	}
+	if affected == 0 {
+		metrics.DbCall.WithLabelValues(table, operation, "NoRowsEffected").Inc()
+		return serviceerror.New(serviceerror.NoRowsEffected)
+	}
+
+	metrics.DbCall.WithLabelValues(table, operation, "Success").Inc()
+
+	return nil
+}
+
+// serviceError hides the database errors behind a server error
+func serviceError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var serviceErr serviceerror.Error
+	if errors.As(err, &serviceErr) {
+		return err
+	}
+
+	return dbError(err)
+}
+
+// dbError hides the database errors behind a server error, except the serialization failures and deadlocks,
+// which a retried transaction may get past
+func dbError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && (pqErr.Code == serializationFailure || pqErr.Code == deadlockDetected) {
+		return serviceerror.New(serviceerror.TransactionConflict)
+	}
+
+	return serviceerror.NewServerError()
+}
+
+// versioned tells a row written meanwhile from a missing one, once a statement guarded by the version
+// of the row touched nothing, exists selects whether the row is still there
+func versioned(
+	ctx context.Context,
+	tx *sql.Tx,
+	log logger.Logger,
+	table string,
+	operation string,
+	err error,
+	exists string,
+	args ...interface{},
+) error {
+	var serviceErr serviceerror.Error
+	if !errors.As(err, &serviceErr) || serviceErr.GetErrorMessage() != serviceerror.NoRowsEffected {
+		return err
+	}
+
+	var found bool
+	if existsErr := tx.QueryRowContext(ctx, exists, args...).Scan(&found); existsErr != nil {
+		metrics.DbCall.WithLabelValues(table, operation, "Failed").Inc()
+
+		log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, existsErr.Error(), nil)
+		return dbError(existsErr)
+	}
+	if found {
+		metrics.DbCall.WithLabelValues(table, operation, "VersionConflict").Inc()
+		return serviceerror.New(serviceerror.VersionConflict)
+	}
+
+	return err
+}
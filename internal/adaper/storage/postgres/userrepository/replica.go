@@ -0,0 +1,94 @@
+package userrepository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"github.com/mohsenabedy91/Sikabiz/pkg/metrics"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Replicas picks the replica a read only unit of work runs on, in turn
+type Replicas struct {
+	log    logger.Logger
+	dbs    []*sql.DB
+	maxLag time.Duration
+	next   atomic.Uint32
+}
+
+// NewReplicas maxLag is in seconds like the config, zero disables the lag check
+func NewReplicas(log logger.Logger, dbs []*sql.DB, maxLag time.Duration) *Replicas {
+	return &Replicas{
+		log:    log,
+		dbs:    dbs,
+		maxLag: maxLag * time.Second,
+	}
+}
+
+// begin returns a READ ONLY transaction on the first usable replica, nil sends the read to the primary
+func (r *Replicas) begin(ctx context.Context, options *sql.TxOptions) *sql.Tx {
+	if r == nil || len(r.dbs) == 0 {
+		return nil
+	}
+
+	start := int(r.next.Add(1))
+	for i := range r.dbs {
+		index := (start + i) % len(r.dbs)
+
+		tx, err := r.dbs[index].BeginTx(ctx, options)
+		if err != nil {
+			metrics.DbCall.WithLabelValues("replicas", "BeginReadOnlyTx", "Failed").Inc()
+
+			r.log.WithContext(ctx).Warn(logger.Database, logger.DatabaseBeginTransaction,
+				fmt.Sprintf("Replica %d is not available: %v", index, err), nil)
+			continue
+		}
+
+		if err = r.checkLag(ctx, tx); err != nil {
+			_ = tx.Rollback()
+			metrics.DbCall.WithLabelValues("replicas", "BeginReadOnlyTx", "Lagging").Inc()
+
+			r.log.WithContext(ctx).Warn(logger.Database, logger.DatabaseBeginTransaction,
+				fmt.Sprintf("Replica %d is skipped: %v", index, err), nil)
+			continue
+		}
+
+		metrics.DbCall.WithLabelValues("replicas", "BeginReadOnlyTx", "Success").Inc()
+		return tx
+	}
+
+	return nil
+}
+
+// checkLag a streaming replica which replayed all it received is caught up, however old its last replayed
+// transaction is, a replica whose WAL receiver is down has received nothing new either, so only the age
+// of its last replayed transaction tells how far behind it may be
+func (r *Replicas) checkLag(ctx context.Context, tx *sql.Tx) error {
+	if r.maxLag <= 0 {
+		return nil
+	}
+
+	var lag float64
+	if err := tx.QueryRowContext(
+		ctx,
+		`SELECT CASE
+					WHEN EXISTS (SELECT 1 FROM pg_stat_wal_receiver WHERE status = 'streaming')
+						AND pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+					ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 'Infinity') END`,
+	).Scan(&lag); err != nil {
+		return err
+	}
+
+	if math.IsInf(lag, 1) {
+		return errors.New("replica is not streaming and replayed no transaction yet")
+	}
+	if behind := time.Duration(lag * float64(time.Second)); behind > r.maxLag {
+		return fmt.Errorf("replication lag %s exceeds %s", behind.Round(time.Millisecond), r.maxLag)
+	}
+
+	return nil
+}
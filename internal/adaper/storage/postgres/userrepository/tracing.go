@@ -0,0 +1,20 @@
+package userrepository
+
+import (
+	"context"
+	"github.com/mohsenabedy91/Sikabiz/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startSpan a query span is named after its table and operation, like the db_calls_total labels
+func startSpan(ctx context.Context, table string, operation string) (context.Context, trace.Span) {
+	return tracing.Start(
+		ctx,
+		table+"."+operation,
+		semconv.DBSystemPostgreSQL,
+		attribute.String("db.collection.name", table),
+		attribute.String("db.operation.name", operation),
+	)
+}
@@ -0,0 +1,142 @@
+package userrepository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
+	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"github.com/mohsenabedy91/Sikabiz/pkg/metrics"
+	"github.com/mohsenabedy91/Sikabiz/pkg/serviceerror"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	retryInitialDelay = 50 * time.Millisecond
+	retryMaxDelay     = time.Second
+)
+
+var isolationLevels = map[port.IsolationLevel]sql.IsolationLevel{
+	port.IsolationDefault:        sql.LevelDefault,
+	port.IsolationReadCommitted:  sql.LevelReadCommitted,
+	port.IsolationRepeatableRead: sql.LevelRepeatableRead,
+	port.IsolationSerializable:   sql.LevelSerializable,
+}
+
+func (r *unitOfWork) WithTransaction(ctx context.Context, opts port.TxOptions, fn func(ctx context.Context) error) error {
+	if r.depth > 0 {
+		return r.savepoint(ctx, fn)
+	}
+
+	attempts := opts.MaxAttempts
+	if attempts <= 0 {
+		attempts = port.DefaultTxAttempts
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := r.transaction(ctx, opts, fn)
+		if err == nil || !conflicted(err) || attempt >= attempts {
+			return err
+		}
+
+		metrics.DbCall.WithLabelValues("transactions", "WithTransaction", "Retried").Inc()
+
+		delay := retryDelay(attempt)
+		r.log.WithContext(ctx).Warn(logger.Database, logger.DatabaseRetry,
+			fmt.Sprintf("Transaction conflicted, attempt %d of %d runs in %s", attempt+1, attempts, delay), nil)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// transaction runs a single attempt, a panic of fn rolls back and goes on up
+func (r *unitOfWork) transaction(ctx context.Context, opts port.TxOptions, fn func(ctx context.Context) error) (err error) {
+	txOptions := &sql.TxOptions{Isolation: isolationLevels[opts.Isolation], ReadOnly: opts.ReadOnly}
+	if err = r.begin(ctx, txOptions); err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = r.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err = fn(ctx); err != nil {
+		// a failed rollback leaves the connection in doubt, so its server error wins over the error of fn
+		if rollbackErr := r.Rollback(); rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+
+	return r.Commit()
+}
+
+// savepoint runs fn in a savepoint of the open transaction, a failure of fn undoes only its own writes
+// and drops the hooks it registered, the outer transaction goes on
+func (r *unitOfWork) savepoint(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	name := fmt.Sprintf("sp_%d", r.depth)
+	if _, err = r.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSavepoint, err.Error(), nil)
+		return dbError(err)
+	}
+
+	hooks := len(r.afterCommit)
+	r.depth++
+
+	rollback := func() {
+		r.depth--
+		r.afterCommit = r.afterCommit[:hooks]
+		if _, rErr := r.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+name); rErr != nil {
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSavepoint, rErr.Error(), nil)
+		}
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			rollback()
+			panic(p)
+		}
+	}()
+
+	if err = fn(ctx); err != nil {
+		rollback()
+		return err
+	}
+
+	r.depth--
+	if _, err = r.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+name); err != nil {
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSavepoint, err.Error(), nil)
+		return dbError(err)
+	}
+
+	return nil
+}
+
+// conflicted tells the serialization failures and deadlocks, running the transaction again may get past them
+func conflicted(err error) bool {
+	var serviceErr serviceerror.Error
+	return errors.As(err, &serviceErr) && serviceErr.GetErrorMessage() == serviceerror.TransactionConflict
+}
+
+// retryDelay doubles after every attempt up to retryMaxDelay, the wait is picked between half and the whole
+// interval, so the conflicting transactions do not run into each other again
+func retryDelay(attempt int) time.Duration {
+	interval := retryMaxDelay
+	if attempt < 32 {
+		if doubled := retryInitialDelay << (attempt - 1); doubled > 0 && doubled < retryMaxDelay {
+			interval = doubled
+		}
+	}
+
+	half := interval / 2
+	return half + rand.N(interval-half+1)
+}
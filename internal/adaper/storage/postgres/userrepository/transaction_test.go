@@ -0,0 +1,263 @@
+package userrepository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/lib/pq"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
+	"github.com/mohsenabedy91/Sikabiz/pkg/serviceerror"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// fakeDriver records the statements the unit of work sends, the commits fail with commitErrs in turn
+type fakeDriver struct {
+	mu         sync.Mutex
+	statements []string
+	commitErrs []error
+}
+
+func (r *fakeDriver) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{driver: r}, nil
+}
+
+func (r *fakeDriver) Driver() driver.Driver {
+	return r
+}
+
+func (r *fakeDriver) Open(string) (driver.Conn, error) {
+	return &fakeConn{driver: r}, nil
+}
+
+func (r *fakeDriver) record(statement string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statements = append(r.statements, statement)
+}
+
+func (r *fakeDriver) recorded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.statements...)
+}
+
+type fakeConn struct {
+	driver *fakeDriver
+}
+
+func (r *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare is not supported")
+}
+
+func (r *fakeConn) Close() error {
+	return nil
+}
+
+func (r *fakeConn) Begin() (driver.Tx, error) {
+	return r.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (r *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	r.driver.record("BEGIN")
+	return r, nil
+}
+
+func (r *fakeConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	r.driver.record(query)
+	return driver.RowsAffected(0), nil
+}
+
+func (r *fakeConn) Commit() error {
+	r.driver.record("COMMIT")
+
+	r.driver.mu.Lock()
+	defer r.driver.mu.Unlock()
+	if len(r.driver.commitErrs) == 0 {
+		return nil
+	}
+	err := r.driver.commitErrs[0]
+	r.driver.commitErrs = r.driver.commitErrs[1:]
+	return err
+}
+
+func (r *fakeConn) Rollback() error {
+	r.driver.record("ROLLBACK")
+	return nil
+}
+
+func newFakeUnitOfWork(t *testing.T, commitErrs ...error) (port.UserUnitOfWork, *fakeDriver) {
+	fake := &fakeDriver{commitErrs: commitErrs}
+	db := sql.OpenDB(fake)
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	return NewUnitOfWork(nopLogger{}, db), fake
+}
+
+func TestWithTransactionRetriesConflicts(t *testing.T) {
+	conflict := &pq.Error{Code: serializationFailure}
+	failed := errors.New("failed")
+
+	tests := []struct {
+		name       string
+		commitErrs []error
+		fnErr      error
+		attempts   int
+		wantCalls  int
+		wantErr    serviceerror.ErrorMessage
+		wantFnErr  bool
+		statements []string
+	}{
+		{
+			name:       "commits",
+			wantCalls:  1,
+			statements: []string{"BEGIN", "COMMIT"},
+		},
+		{
+			name:       "retries a conflicted commit",
+			commitErrs: []error{conflict},
+			wantCalls:  2,
+			statements: []string{"BEGIN", "COMMIT", "BEGIN", "COMMIT"},
+		},
+		{
+			name:       "gives up after the attempts",
+			commitErrs: []error{conflict, conflict, conflict},
+			attempts:   2,
+			wantCalls:  2,
+			wantErr:    serviceerror.TransactionConflict,
+			statements: []string{"BEGIN", "COMMIT", "BEGIN", "COMMIT"},
+		},
+		{
+			name:       "does not retry other errors",
+			fnErr:      failed,
+			wantCalls:  1,
+			wantFnErr:  true,
+			statements: []string{"BEGIN", "ROLLBACK"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uow, fake := newFakeUnitOfWork(t, tt.commitErrs...)
+
+			calls := 0
+			err := uow.WithTransaction(context.Background(), port.TxOptions{MaxAttempts: tt.attempts}, func(ctx context.Context) error {
+				calls++
+				return tt.fnErr
+			})
+
+			switch {
+			case tt.wantFnErr:
+				if !errors.Is(err, tt.fnErr) {
+					t.Fatalf("WithTransaction() error = %v, want %v", err, tt.fnErr)
+				}
+			case tt.wantErr != "":
+				var serviceErr serviceerror.Error
+				if !errors.As(err, &serviceErr) || serviceErr.GetErrorMessage() != tt.wantErr {
+					t.Fatalf("WithTransaction() error = %v, want %s", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("WithTransaction() error = %v", err)
+			}
+			if calls != tt.wantCalls {
+				t.Fatalf("fn ran %d times, want %d", calls, tt.wantCalls)
+			}
+			if got := fake.recorded(); !reflect.DeepEqual(got, tt.statements) {
+				t.Fatalf("statements = %v, want %v", got, tt.statements)
+			}
+		})
+	}
+}
+
+func TestWithTransactionSavepoint(t *testing.T) {
+	uow, fake := newFakeUnitOfWork(t)
+
+	var hooks []string
+	err := uow.WithTransaction(context.Background(), port.TxOptions{}, func(ctx context.Context) error {
+		uow.AfterCommit(func() { hooks = append(hooks, "outer") })
+
+		// the failed savepoint is undone on its own and drops its hooks, the outer transaction goes on
+		failed := errors.New("failed")
+		if err := uow.WithTransaction(ctx, port.TxOptions{}, func(ctx context.Context) error {
+			uow.AfterCommit(func() { hooks = append(hooks, "failed") })
+			return failed
+		}); !errors.Is(err, failed) {
+			t.Fatalf("nested WithTransaction() error = %v, want %v", err, failed)
+		}
+
+		return uow.WithTransaction(ctx, port.TxOptions{}, func(ctx context.Context) error {
+			uow.AfterCommit(func() { hooks = append(hooks, "released") })
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("WithTransaction() error = %v", err)
+	}
+
+	want := []string{
+		"BEGIN",
+		"SAVEPOINT sp_1", "ROLLBACK TO SAVEPOINT sp_1",
+		"SAVEPOINT sp_1", "RELEASE SAVEPOINT sp_1",
+		"COMMIT",
+	}
+	if got := fake.recorded(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("statements = %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(hooks, []string{"outer", "released"}) {
+		t.Fatalf("hooks = %v", hooks)
+	}
+}
+
+func TestWithTransactionRollsBackOnPanic(t *testing.T) {
+	tests := []struct {
+		name       string
+		nested     bool
+		statements []string
+	}{
+		{
+			name:       "transaction",
+			statements: []string{"BEGIN", "ROLLBACK"},
+		},
+		{
+			name:       "savepoint",
+			nested:     true,
+			statements: []string{"BEGIN", "SAVEPOINT sp_1", "ROLLBACK TO SAVEPOINT sp_1", "ROLLBACK"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uow, fake := newFakeUnitOfWork(t)
+
+			func() {
+				defer func() {
+					if p := recover(); p != "boom" {
+						t.Fatalf("recovered %v, want the panic of fn", p)
+					}
+				}()
+
+				_ = uow.WithTransaction(context.Background(), port.TxOptions{}, func(ctx context.Context) error {
+					if tt.nested {
+						return uow.WithTransaction(ctx, port.TxOptions{}, func(context.Context) error {
+							panic("boom")
+						})
+					}
+					panic("boom")
+				})
+			}()
+
+			if got := fake.recorded(); !reflect.DeepEqual(got, tt.statements) {
+				t.Fatalf("statements = %v, want %v", got, tt.statements)
+			}
+
+			// the unit of work is usable again once the panic is handled
+			if err := uow.WithTransaction(context.Background(), port.TxOptions{}, func(context.Context) error {
+				return nil
+			}); err != nil {
+				t.Fatalf("WithTransaction() after the panic error = %v", err)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package userrepository
 import (
 	"context"
 	"database/sql"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
 	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
 	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
 	"github.com/mohsenabedy91/Sikabiz/pkg/serviceerror"
@@ -12,9 +13,18 @@ type unitOfWork struct {
 	log logger.Logger
 	db  *sql.DB
 	tx  *sql.Tx
+	// depth counts the open transaction and its savepoints, zero when no transaction is open
+	depth int
 
-	userRepository    port.UserRepository
-	addressRepository port.AddressRepository
+	replicas *Replicas
+	cursors  *CursorSealer
+
+	afterCommit []func()
+
+	userRepository     port.UserRepository
+	addressRepository  port.AddressRepository
+	auditLogRepository port.AuditLogRepository
+	purgeRepository    port.PurgeRepository
 	// Add other repositories as needed
 }
 
@@ -25,33 +35,82 @@ func NewUnitOfWork(log logger.Logger, db *sql.DB) port.UserUnitOfWork {
 	}
 }
 
+// NewUnitOfWorkWithReplicas the read only transactions run on the replicas, the rest on db,
+// cursors seals the listing cursors the API hands out
+func NewUnitOfWorkWithReplicas(log logger.Logger, db *sql.DB, replicas *Replicas, cursors *CursorSealer) port.UserUnitOfWork {
+	return &unitOfWork{
+		log:      log,
+		db:       db,
+		replicas: replicas,
+		cursors:  cursors,
+	}
+}
+
 func (r *unitOfWork) BeginTx(ctx context.Context) error {
-	tx, err := r.db.BeginTx(ctx, nil)
-	if err != nil {
-		r.log.Error(logger.Database, logger.DatabaseBeginTransaction, err.Error(), nil)
+	return r.begin(ctx, &sql.TxOptions{})
+}
 
-		return serviceerror.NewServerError()
+// BeginReadOnlyTx the primary serves the transaction when no replica is configured, reachable or caught up
+func (r *unitOfWork) BeginReadOnlyTx(ctx context.Context) error {
+	return r.begin(ctx, &sql.TxOptions{ReadOnly: true})
+}
+
+// begin runs the read only transactions on a replica, except the serializable ones,
+// a hot standby can not run them
+func (r *unitOfWork) begin(ctx context.Context, options *sql.TxOptions) error {
+	var tx *sql.Tx
+	if options.ReadOnly && options.Isolation != sql.LevelSerializable && !domain.PrimaryReads(ctx) {
+		tx = r.replicas.begin(ctx, options)
 	}
 
+	if tx == nil {
+		var err error
+		if tx, err = r.db.BeginTx(ctx, options); err != nil {
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseBeginTransaction, err.Error(), nil)
+
+			return serviceerror.NewServerError()
+		}
+	}
+
+	r.start(tx)
+
+	return nil
+}
+
+func (r *unitOfWork) start(tx *sql.Tx) {
 	r.tx = tx
-	r.userRepository = NewUserRepository(r.log, tx)
+	r.depth = 1
+	r.afterCommit = nil
+	userRepository := NewUserRepository(r.log, tx)
+	userRepository.cursors = r.cursors
+	r.userRepository = userRepository
 	r.addressRepository = NewAddressRepository(r.log, tx)
+	r.auditLogRepository = NewAuditLogRepository(r.log, tx)
+	r.purgeRepository = NewPurgeRepository(r.log, tx)
 	// Initialize other repositories as needed
-
-	return nil
 }
 
 func (r *unitOfWork) Commit() error {
+	r.depth = 0
 
 	if err := r.tx.Commit(); err != nil {
+		r.afterCommit = nil
 		r.log.Error(logger.Database, logger.DatabaseCommit, err.Error(), nil)
-		return serviceerror.NewServerError()
+		return dbError(err)
+	}
+
+	hooks := r.afterCommit
+	r.afterCommit = nil
+	for _, hook := range hooks {
+		hook()
 	}
 
 	return nil
 }
 
 func (r *unitOfWork) Rollback() error {
+	r.depth = 0
+	r.afterCommit = nil
 
 	if err := r.tx.Rollback(); err != nil {
 		r.log.Error(logger.Database, logger.DatabaseRollback, err.Error(), nil)
@@ -61,6 +120,10 @@ func (r *unitOfWork) Rollback() error {
 	return nil
 }
 
+func (r *unitOfWork) AfterCommit(fn func()) {
+	r.afterCommit = append(r.afterCommit, fn)
+}
+
 func (r *unitOfWork) UserRepository() port.UserRepository {
 	return r.userRepository
 }
@@ -68,3 +131,11 @@ func (r *unitOfWork) UserRepository() port.UserRepository {
 func (r *unitOfWork) AddressRepository() port.AddressRepository {
 	return r.addressRepository
 }
+
+func (r *unitOfWork) AuditLogRepository() port.AuditLogRepository {
+	return r.auditLogRepository
+}
+
+func (r *unitOfWork) PurgeRepository() port.PurgeRepository {
+	return r.purgeRepository
+}
@@ -1,17 +1,22 @@
 package userrepository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
 	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
 	"github.com/mohsenabedy91/Sikabiz/pkg/metrics"
 	"github.com/mohsenabedy91/Sikabiz/pkg/serviceerror"
+	"github.com/mohsenabedy91/Sikabiz/pkg/tracing"
 )
 
 type UserRepository struct {
-	log logger.Logger
-	tx  *sql.Tx
+	log     logger.Logger
+	tx      *sql.Tx
+	cursors *CursorSealer
 }
 
 func NewUserRepository(log logger.Logger, tx *sql.Tx) *UserRepository {
@@ -21,85 +26,353 @@ func NewUserRepository(log logger.Logger, tx *sql.Tx) *UserRepository {
 	}
 }
 
-func (r *UserRepository) GetByID(uuid uuid.UUID) (*domain.User, error) {
-	rows, err := r.tx.Query(
-		`SELECT u.uuid, u.first_name, u.last_name, u.email, u.phone_number, a.street, a.city, a.state, a.zip_code, a.country FROM users AS u 
-				INNER JOIN addresses as a on u.id = a.user_id AND a.deleted_at IS NULL
-               	WHERE u.deleted_at IS NULL AND u.uuid = $1 FOR UPDATE SKIP LOCKED`,
-		uuid,
+func (r *UserRepository) GetByID(ctx context.Context, uuid uuid.UUID) (*domain.User, error) {
+	ctx, span := startSpan(ctx, "users", "GetByID")
+	user, err := r.getByID(ctx, uuid, false, false)
+	tracing.End(span, err)
+
+	return user, err
+}
+
+func (r *UserRepository) GetByIDForUpdate(ctx context.Context, uuid uuid.UUID) (*domain.User, error) {
+	ctx, span := startSpan(ctx, "users", "GetByIDForUpdate")
+	user, err := r.getByID(ctx, uuid, false, true)
+	tracing.End(span, err)
+
+	return user, err
+}
+
+// GetByIDWithDeleted also returns a soft deleted user and locks it, restore and hard delete start from it
+func (r *UserRepository) GetByIDWithDeleted(ctx context.Context, uuid uuid.UUID) (*domain.User, error) {
+	ctx, span := startSpan(ctx, "users", "GetByIDWithDeleted")
+	user, err := r.getByID(ctx, uuid, true, true)
+	tracing.End(span, err)
+
+	return user, err
+}
+
+// getByID with forUpdate locks the user row until the transaction ends, so a change is based on the latest state,
+// the reads skip the lock, a read only transaction can not take it
+func (r *UserRepository) getByID(ctx context.Context, id uuid.UUID, withDeleted bool, forUpdate bool) (*domain.User, error) {
+	lock := ""
+	if forUpdate {
+		lock = "FOR UPDATE OF u"
+	}
+
+	rows, err := r.tx.QueryContext(
+		ctx,
+		`SELECT u.id, u.uuid, u.first_name, u.last_name, u.email, u.phone_number, u.version, u.created_at, u.updated_at,
+       				u.deleted_at, a.uuid, a.street, a.city, a.state, a.zip_code, a.country, a.version FROM users AS u
+				LEFT JOIN addresses as a on u.id = a.user_id AND a.deleted_at IS NULL
+               	WHERE u.uuid = $1 AND ($2 OR u.deleted_at IS NULL)
+               	ORDER BY a.id
+               	`+lock,
+		id,
+		withDeleted,
 	)
 	if err != nil {
 		metrics.DbCall.WithLabelValues("users", "GetByID", "Failed").Inc()
 
-		r.log.Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
-		return nil, serviceerror.NewServerError()
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+		return nil, dbError(err)
 	}
 
 	defer func(rows *sql.Rows) {
 		if err = rows.Close(); err != nil {
-			r.log.Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
 		}
 	}(rows)
 
-	var user domain.User
+	var user *domain.User
 	var addresses []*domain.Address
 
 	for rows.Next() {
+		var scanned domain.User
+		var deletedAt sql.NullTime
 		var address domain.Address
+		var addressUUID uuid.NullUUID
+		var addressVersion sql.NullInt64
 		if err = rows.Scan(
-			&user.Base.UUID,
-			&user.FirstName,
-			&user.LastName,
-			&user.Email,
-			&user.PhoneNumber,
+			&scanned.ID,
+			&scanned.UUID,
+			&scanned.FirstName,
+			&scanned.LastName,
+			&scanned.Email,
+			&scanned.PhoneNumber,
+			&scanned.Version,
+			&scanned.CreatedAt,
+			&scanned.UpdatedAt,
+			&deletedAt,
+			&addressUUID,
 			&address.Street,
 			&address.City,
 			&address.State,
 			&address.ZipCode,
 			&address.Country,
+			&addressVersion,
 		); err != nil {
 			metrics.DbCall.WithLabelValues("users", "GetByID", "Failed").Inc()
 
-			r.log.Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
-			return nil, serviceerror.NewServerError()
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+			return nil, dbError(err)
 		}
 
-		addresses = append(addresses, &address)
+		if user == nil {
+			scanned.DeletedAt = deletedAt.Time
+			user = &scanned
+		}
+		// a user without addresses comes back as a single row with null address columns
+		if addressUUID.Valid {
+			address.UUID = addressUUID.UUID
+			address.Version = int(addressVersion.Int64)
+			addresses = append(addresses, &address)
+		}
 	}
 
 	if err = rows.Err(); err != nil {
 		metrics.DbCall.WithLabelValues("users", "GetByID", "Failed").Inc()
 
-		r.log.Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
-		return nil, serviceerror.NewServerError()
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+		return nil, dbError(err)
+	}
+
+	if user == nil {
+		metrics.DbCall.WithLabelValues("users", "GetByID", "NotFound").Inc()
+		return nil, serviceerror.New(serviceerror.RecordNotFound)
 	}
 
 	metrics.DbCall.WithLabelValues("users", "GetByUUID", "Success").Inc()
 
 	user.Addresses = addresses
-	return &user, nil
+	return user, nil
+}
+
+// GetActorID returns the id of the user acting through the API, the id is what the users.*_by columns refer to
+func (r *UserRepository) GetActorID(ctx context.Context, id uuid.UUID) (uint64, error) {
+	ctx, span := startSpan(ctx, "users", "GetActorID")
+	actorID, err := r.getActorID(ctx, id)
+	tracing.End(span, err)
+
+	return actorID, err
 }
 
-func (r *UserRepository) Save(user *domain.User) (uint64, error) {
+func (r *UserRepository) getActorID(ctx context.Context, id uuid.UUID) (uint64, error) {
+	var actorID uint64
+	err := r.tx.QueryRowContext(
+		ctx,
+		`SELECT id FROM users WHERE uuid = $1 AND deleted_at IS NULL`,
+		id,
+	).Scan(&actorID)
+	if errors.Is(err, sql.ErrNoRows) {
+		metrics.DbCall.WithLabelValues("users", "GetActorID", "NotFound").Inc()
+		return 0, serviceerror.New(serviceerror.RecordNotFound)
+	}
+	if err != nil {
+		metrics.DbCall.WithLabelValues("users", "GetActorID", "Failed").Inc()
+
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+		return 0, dbError(err)
+	}
+
+	metrics.DbCall.WithLabelValues("users", "GetActorID", "Success").Inc()
+
+	return actorID, nil
+}
+
+func (r *UserRepository) Save(ctx context.Context, user *domain.User) (uint64, error) {
+	ctx, span := startSpan(ctx, "users", "Save")
+	userID, err := r.save(ctx, user)
+	tracing.End(span, err)
+
+	return userID, err
+}
+
+func (r *UserRepository) save(ctx context.Context, user *domain.User) (uint64, error) {
 	var userID uint64
-	err := r.tx.QueryRow(
-		`INSERT INTO users (first_name, last_name, email, phone_number) 
-				VALUES ($1, $2, $3, $4) 
-				RETURNING id`,
+	err := r.tx.QueryRowContext(
+		ctx,
+		`INSERT INTO users (first_name, last_name, email, phone_number, created_by, updated_by)
+				VALUES ($1, $2, $3, $4, $5, $5)
+				RETURNING id, uuid, version`,
 		user.FirstName,
 		user.LastName,
 		user.Email,
 		user.PhoneNumber,
-	).Scan(&userID)
+		user.CreatedBy,
+	).Scan(&userID, &user.UUID, &user.Version)
 	if err != nil {
 		metrics.DbCall.WithLabelValues("users", "Save", "Failed").Inc()
 
-		r.log.Error(logger.Database, logger.DatabaseInsert, err.Error(), map[logger.ExtraKey]interface{}{
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseInsert, err.Error(), map[logger.ExtraKey]interface{}{
 			logger.InsertDBArg: user,
 		})
-		return userID, serviceerror.NewServerError()
+		if registeredErr := registered(err, user); registeredErr != nil {
+			return userID, registeredErr
+		}
+		return userID, dbError(err)
 	}
 
 	metrics.DbCall.WithLabelValues("users", "Save", "Success").Inc()
+	user.ID = userID
 	return userID, nil
 }
+
+// Update writes the columns of a user which is not deleted, the addresses are left alone
+func (r *UserRepository) Update(ctx context.Context, user *domain.User) error {
+	ctx, span := startSpan(ctx, "users", "Update")
+	err := exec(
+		ctx,
+		r.tx,
+		r.log,
+		"users",
+		"Update",
+		logger.DatabaseUpdate,
+		`UPDATE users
+				SET first_name = $2, last_name = $3, email = $4, phone_number = $5,
+				    updated_by = NULLIF($6, 0), updated_at = now(), version = version + 1
+				WHERE uuid = $1 AND deleted_at IS NULL AND version = $7`,
+		user.UUID,
+		user.FirstName,
+		user.LastName,
+		user.Email,
+		user.PhoneNumber,
+		int64(user.UpdatedBy),
+		user.Version,
+	)
+	err = versioned(ctx, r.tx, r.log, "users", "Update", err,
+		`SELECT EXISTS (SELECT 1 FROM users WHERE uuid = $1 AND deleted_at IS NULL)`, user.UUID)
+	tracing.End(span, err)
+	if err == nil {
+		user.Version++
+	}
+
+	if registeredErr := registered(err, user); registeredErr != nil {
+		return registeredErr
+	}
+
+	return serviceError(err)
+}
+
+// BumpVersion the user representation holds the addresses, so their changes are a new version of the user
+func (r *UserRepository) BumpVersion(ctx context.Context, user *domain.User) error {
+	ctx, span := startSpan(ctx, "users", "BumpVersion")
+	err := exec(
+		ctx,
+		r.tx,
+		r.log,
+		"users",
+		"BumpVersion",
+		logger.DatabaseUpdate,
+		`UPDATE users SET version = version + 1 WHERE uuid = $1 AND version = $2`,
+		user.UUID,
+		user.Version,
+	)
+	tracing.End(span, err)
+	if err == nil {
+		user.Version++
+	}
+
+	return serviceError(err)
+}
+
+// registered maps the unique violations of the email and the phone number to a conflict, nil for the other errors
+func registered(err error, user *domain.User) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != uniqueViolation {
+		return nil
+	}
+
+	switch pqErr.Constraint {
+	case "users_email_key":
+		return serviceerror.New(serviceerror.EmailRegistered, map[string]interface{}{
+			"email": user.Email,
+		})
+	case "users_phone_number_key":
+		return serviceerror.New(serviceerror.PhoneNumberRegistered, map[string]interface{}{
+			"phone_number": user.PhoneNumber,
+		})
+	}
+
+	return nil
+}
+
+func (r *UserRepository) SoftDelete(ctx context.Context, user *domain.User) error {
+	ctx, span := startSpan(ctx, "users", "SoftDelete")
+	err := exec(
+		ctx,
+		r.tx,
+		r.log,
+		"users",
+		"SoftDelete",
+		logger.DatabaseUpdate,
+		`UPDATE users SET deleted_by = NULLIF($2, 0), deleted_at = now(), version = version + 1
+				WHERE uuid = $1 AND deleted_at IS NULL AND version = $3`,
+		user.UUID,
+		int64(user.DeleteBy),
+		user.Version,
+	)
+	err = versioned(ctx, r.tx, r.log, "users", "SoftDelete", err,
+		`SELECT EXISTS (SELECT 1 FROM users WHERE uuid = $1 AND deleted_at IS NULL)`, user.UUID)
+	tracing.End(span, err)
+	if err == nil {
+		user.Version++
+	}
+
+	return serviceError(err)
+}
+
+func (r *UserRepository) Restore(ctx context.Context, user *domain.User) error {
+	ctx, span := startSpan(ctx, "users", "Restore")
+	err := exec(
+		ctx,
+		r.tx,
+		r.log,
+		"users",
+		"Restore",
+		logger.DatabaseUpdate,
+		`UPDATE users SET deleted_by = NULL, deleted_at = NULL, updated_by = NULLIF($2, 0), updated_at = now(),
+				    version = version + 1
+				WHERE uuid = $1 AND deleted_at IS NOT NULL AND anonymized_at IS NULL AND version = $3`,
+		user.UUID,
+		int64(user.UpdatedBy),
+		user.Version,
+	)
+	err = versioned(ctx, r.tx, r.log, "users", "Restore", err,
+		`SELECT EXISTS (SELECT 1 FROM users WHERE uuid = $1 AND deleted_at IS NOT NULL AND anonymized_at IS NULL)`, user.UUID)
+	tracing.End(span, err)
+	if err == nil {
+		user.Version++
+	}
+
+	return serviceError(err)
+}
+
+// HardDelete removes the user with its addresses, a user other rows refer to as their modifier
+// is not deletable
+func (r *UserRepository) HardDelete(ctx context.Context, user *domain.User) error {
+	ctx, span := startSpan(ctx, "users", "HardDelete")
+	err := exec(
+		ctx,
+		r.tx,
+		r.log,
+		"users",
+		"HardDelete",
+		logger.DatabaseDelete,
+		`WITH deleted_addresses AS (
+					DELETE FROM addresses WHERE user_id IN (SELECT id FROM users WHERE uuid = $1 AND version = $2)
+				)
+				DELETE FROM users WHERE uuid = $1 AND version = $2`,
+		user.UUID,
+		user.Version,
+	)
+	err = versioned(ctx, r.tx, r.log, "users", "HardDelete", err,
+		`SELECT EXISTS (SELECT 1 FROM users WHERE uuid = $1)`, user.UUID)
+	tracing.End(span, err)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == foreignKeyViolation {
+		return serviceerror.New(serviceerror.IsNotDeletable)
+	}
+
+	return serviceError(err)
+}
@@ -0,0 +1,286 @@
+package userrepository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"github.com/mohsenabedy91/Sikabiz/pkg/metrics"
+	"github.com/mohsenabedy91/Sikabiz/pkg/serviceerror"
+	"github.com/mohsenabedy91/Sikabiz/pkg/tracing"
+	"strings"
+	"time"
+)
+
+const defaultUserSort = "created_at"
+
+type sortColumn struct {
+	expression string
+	cast       string
+	value      func(user *domain.User) string
+}
+
+// userSortColumns the whitelist of the listing sorts, the nullable columns are coalesced,
+// so the keyset comparison never meets a null
+var userSortColumns = map[string]sortColumn{
+	"created_at": {
+		expression: "u.created_at",
+		cast:       "timestamptz",
+		value: func(user *domain.User) string {
+			return user.CreatedAt.Format(time.RFC3339Nano)
+		},
+	},
+	"updated_at": {
+		expression: "u.updated_at",
+		cast:       "timestamptz",
+		value: func(user *domain.User) string {
+			return user.UpdatedAt.Format(time.RFC3339Nano)
+		},
+	},
+	"email": {
+		expression: "COALESCE(u.email, '')",
+		cast:       "text",
+		value: func(user *domain.User) string {
+			return user.Email
+		},
+	},
+	"first_name": {
+		expression: "COALESCE(u.first_name, '')",
+		cast:       "text",
+		value: func(user *domain.User) string {
+			return stringValue(user.FirstName)
+		},
+	},
+	"last_name": {
+		expression: "COALESCE(u.last_name, '')",
+		cast:       "text",
+		value: func(user *domain.User) string {
+			return stringValue(user.LastName)
+		},
+	},
+}
+
+// userCursor points after the last user of a page, it is bound to the sort it was issued for,
+// it carries the sort value of that user, so a change or removal of the user does not move the
+// next page, the cursor is sealed by the CursorSealer, the value may be the email
+type userCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	ID    uint64 `json:"i"`
+}
+
+// List pages through the users with keyset pagination, a page costs the same however deep it is
+// and the rows added meanwhile do not shift the next page
+func (r *UserRepository) List(ctx context.Context, query domain.UserListQuery) (*domain.UserPage, error) {
+	ctx, span := startSpan(ctx, "users", "List")
+	page, err := r.list(ctx, query)
+	tracing.End(span, err)
+
+	return page, err
+}
+
+func (r *UserRepository) list(ctx context.Context, query domain.UserListQuery) (*domain.UserPage, error) {
+	if query.Sort == "" {
+		query.Sort = defaultUserSort
+	}
+	column, ok := userSortColumns[query.Sort]
+	if !ok {
+		return nil, serviceerror.New(serviceerror.InvalidRequestBody)
+	}
+
+	limit := query.Limit
+	if limit < 1 {
+		limit = domain.DefaultUserListLimit
+	}
+	if limit > domain.MaxUserListLimit {
+		limit = domain.MaxUserListLimit
+	}
+
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	switch query.Filter.Deleted {
+	case domain.WithDeleted:
+	case domain.OnlyDeleted:
+		conditions = append(conditions, "u.deleted_at IS NOT NULL")
+	default:
+		conditions = append(conditions, "u.deleted_at IS NULL")
+	}
+
+	filter := query.Filter
+	if filter.Email != "" {
+		conditions = append(conditions, fmt.Sprintf("lower(u.email) = lower(%s)", arg(filter.Email)))
+	}
+	if filter.PhoneNumber != "" {
+		conditions = append(conditions, fmt.Sprintf("u.phone_number = %s", arg(filter.PhoneNumber)))
+	}
+	if filter.Name != "" {
+		prefix := arg(likePrefix(filter.Name))
+		conditions = append(conditions, fmt.Sprintf(
+			"(lower(u.first_name) LIKE %[1]s OR lower(u.last_name) LIKE %[1]s OR lower(u.first_name || ' ' || u.last_name) LIKE %[1]s)",
+			prefix,
+		))
+	}
+	if filter.Country != "" || filter.City != "" {
+		// the country and the city have to match the same address
+		addressConditions := []string{"a.user_id = u.id", "a.deleted_at IS NULL"}
+		if filter.Country != "" {
+			addressConditions = append(addressConditions, fmt.Sprintf("lower(a.country) = lower(%s)", arg(filter.Country)))
+		}
+		if filter.City != "" {
+			addressConditions = append(addressConditions, fmt.Sprintf("lower(a.city) = lower(%s)", arg(filter.City)))
+		}
+		conditions = append(conditions, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM addresses AS a WHERE %s)",
+			strings.Join(addressConditions, " AND "),
+		))
+	}
+	if !filter.CreatedFrom.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("u.created_at >= %s", arg(filter.CreatedFrom)))
+	}
+	if !filter.CreatedTo.IsZero() {
+		conditions = append(conditions, fmt.Sprintf("u.created_at < %s", arg(filter.CreatedTo)))
+	}
+
+	direction, comparison := "ASC", ">"
+	if query.Desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	if query.Cursor != "" {
+		cursor, err := decodeUserCursor(r.cursors, query.Cursor)
+		if err != nil || cursor.Sort != query.Sort || cursor.Desc != query.Desc {
+			return nil, serviceerror.New(serviceerror.InvalidCursor)
+		}
+		conditions = append(conditions, fmt.Sprintf(
+			"(%s, u.id) %s (%s::%s, %s)",
+			column.expression,
+			comparison,
+			arg(cursor.Value),
+			column.cast,
+			arg(cursor.ID),
+		))
+	}
+
+	// one more row than the limit tells whether there is a next page
+	rows, err := r.tx.QueryContext(
+		ctx,
+		fmt.Sprintf(
+			`SELECT u.id, u.uuid, u.first_name, u.last_name, COALESCE(u.email, ''), COALESCE(u.phone_number, ''),
+       				u.version, u.created_at, u.updated_at, u.deleted_at FROM users AS u
+					WHERE %s
+					ORDER BY %s %s, u.id %s
+					LIMIT %s`,
+			strings.Join(conditions, " AND "),
+			column.expression,
+			direction,
+			direction,
+			arg(limit+1),
+		),
+		args...,
+	)
+	if err != nil {
+		metrics.DbCall.WithLabelValues("users", "List", "Failed").Inc()
+
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), map[logger.ExtraKey]interface{}{
+			logger.SelectDBArg: args,
+		})
+		return nil, dbError(err)
+	}
+
+	defer func(rows *sql.Rows) {
+		if err = rows.Close(); err != nil {
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+		}
+	}(rows)
+
+	users := make([]*domain.User, 0, limit)
+	for rows.Next() {
+		var user domain.User
+		var deletedAt sql.NullTime
+		if err = rows.Scan(
+			&user.ID,
+			&user.UUID,
+			&user.FirstName,
+			&user.LastName,
+			&user.Email,
+			&user.PhoneNumber,
+			&user.Version,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&deletedAt,
+		); err != nil {
+			metrics.DbCall.WithLabelValues("users", "List", "Failed").Inc()
+
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+			return nil, dbError(err)
+		}
+		user.DeletedAt = deletedAt.Time
+
+		users = append(users, &user)
+	}
+
+	if err = rows.Err(); err != nil {
+		metrics.DbCall.WithLabelValues("users", "List", "Failed").Inc()
+
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+		return nil, dbError(err)
+	}
+
+	metrics.DbCall.WithLabelValues("users", "List", "Success").Inc()
+
+	page := &domain.UserPage{
+		Users: users,
+		Limit: limit,
+	}
+	if len(users) > limit {
+		page.Users = users[:limit]
+		page.HasMore = true
+
+		last := page.Users[limit-1]
+		page.NextCursor = encodeUserCursor(r.cursors, userCursor{
+			Sort:  query.Sort,
+			Desc:  query.Desc,
+			Value: column.value(last),
+			ID:    last.ID,
+		})
+	}
+
+	return page, nil
+}
+
+func encodeUserCursor(cursors *CursorSealer, cursor userCursor) string {
+	body, _ := json.Marshal(cursor)
+	return cursors.seal(body)
+}
+
+func decodeUserCursor(cursors *CursorSealer, value string) (userCursor, error) {
+	var cursor userCursor
+	body, err := cursors.open(value)
+	if err != nil {
+		return cursor, err
+	}
+
+	err = json.Unmarshal(body, &cursor)
+	return cursor, err
+}
+
+// likePrefix escapes the wildcards of the user input, the result matches the values starting with it
+func likePrefix(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(strings.ToLower(value)) + "%"
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
@@ -0,0 +1,121 @@
+package userrepository
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestUserCursorRoundTrip(t *testing.T) {
+	sealer, err := NewCursorSealer("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cursors *CursorSealer
+	}{
+		{name: "plain", cursors: nil},
+		{name: "sealed", cursors: sealer},
+	}
+
+	cursor := userCursor{Sort: "email", Desc: true, Value: "john@example.com", ID: 42}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value := encodeUserCursor(tt.cursors, cursor)
+
+			got, err := decodeUserCursor(tt.cursors, value)
+			if err != nil {
+				t.Fatalf("decodeUserCursor() error = %v", err)
+			}
+			if got != cursor {
+				t.Errorf("decodeUserCursor() = %+v, want %+v", got, cursor)
+			}
+		})
+	}
+}
+
+func TestSealedUserCursorHidesValue(t *testing.T) {
+	sealer, err := NewCursorSealer("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value := encodeUserCursor(sealer, userCursor{Sort: "email", Value: "john@example.com", ID: 42})
+	body, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(body), "john@example.com") {
+		t.Error("sealed cursor carries the email readable")
+	}
+}
+
+func TestDecodeUserCursorRejects(t *testing.T) {
+	sealer, err := NewCursorSealer("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewCursorSealer("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed := encodeUserCursor(sealer, userCursor{Sort: "email", Value: "john@example.com", ID: 42})
+	tampered, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name    string
+		cursors *CursorSealer
+		value   string
+	}{
+		{name: "not base64", cursors: nil, value: "!!!"},
+		{name: "not json", cursors: nil, value: base64.RawURLEncoding.EncodeToString([]byte("id"))},
+		{name: "plain cursor for a sealer", cursors: sealer, value: encodeUserCursor(nil, userCursor{Sort: "email"})},
+		{name: "another secret", cursors: other, value: sealed},
+		{name: "tampered", cursors: sealer, value: base64.RawURLEncoding.EncodeToString(tampered)},
+		{name: "too short", cursors: sealer, value: base64.RawURLEncoding.EncodeToString([]byte("short"))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeUserCursor(tt.cursors, tt.value); err == nil {
+				t.Error("decodeUserCursor() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestNewCursorSealerWithoutSecret(t *testing.T) {
+	sealer, err := NewCursorSealer("")
+	if err != nil || sealer != nil {
+		t.Errorf("NewCursorSealer(\"\") = %v, %v, want nil, nil", sealer, err)
+	}
+}
+
+func TestLikePrefix(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "John", want: "john%"},
+		{value: "50%", want: `50\%%`},
+		{value: "a_b", want: `a\_b%`},
+		{value: `back\slash`, want: `back\\slash%`},
+		{value: `\%_`, want: `\\\%\_%`},
+		{value: "", want: "%"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			if got := likePrefix(tt.value); got != tt.want {
+				t.Errorf("likePrefix(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
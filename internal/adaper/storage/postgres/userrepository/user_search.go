@@ -0,0 +1,136 @@
+package userrepository
+
+import (
+	"context"
+	"database/sql"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"github.com/mohsenabedy91/Sikabiz/pkg/metrics"
+	"github.com/mohsenabedy91/Sikabiz/pkg/tracing"
+	"strings"
+)
+
+const (
+	// searchSimilarity the word similarity a row needs to match, low enough for a misspelt word
+	searchSimilarity = "0.4"
+	// searchCandidates the nearest rows read from each index before they are merged
+	searchCandidates = 200
+	// addressPenalty ranks a user found by an address below one found by its name or email
+	addressPenalty = 0.1
+)
+
+// Search ranks the users by the trigram word similarity of the query to their name and email
+// or to one of their addresses, both branches read only the nearest rows of their GiST index
+func (r *UserRepository) Search(ctx context.Context, query string, limit int) ([]*domain.UserSearchResult, error) {
+	ctx, span := startSpan(ctx, "users", "Search")
+	results, err := r.search(ctx, query, limit)
+	tracing.End(span, err)
+
+	return results, err
+}
+
+func (r *UserRepository) search(ctx context.Context, query string, limit int) ([]*domain.UserSearchResult, error) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil, nil
+	}
+
+	if limit < 1 {
+		limit = domain.DefaultUserSearchLimit
+	}
+	if limit > domain.MaxUserSearchLimit {
+		limit = domain.MaxUserSearchLimit
+	}
+
+	// the threshold is local to the transaction
+	if _, err := r.tx.ExecContext(
+		ctx,
+		`SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)`,
+		searchSimilarity,
+	); err != nil {
+		metrics.DbCall.WithLabelValues("users", "Search", "Failed").Inc()
+
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+		return nil, dbError(err)
+	}
+
+	rows, err := r.tx.QueryContext(
+		ctx,
+		`WITH candidates AS (
+					(SELECT id AS user_id, $1 <<-> search_text AS distance FROM users
+						WHERE deleted_at IS NULL AND $1 <% search_text
+						ORDER BY $1 <<-> search_text
+						LIMIT $3)
+					UNION ALL
+					(SELECT user_id, ($1 <<-> search_text) + $4 AS distance FROM addresses
+						WHERE deleted_at IS NULL AND $1 <% search_text
+						ORDER BY $1 <<-> search_text
+						LIMIT $3)
+				), ranked AS (
+					SELECT user_id, min(distance) AS distance FROM candidates GROUP BY user_id
+				)
+				SELECT u.id, u.uuid, u.first_name, u.last_name, COALESCE(u.email, ''), COALESCE(u.phone_number, ''),
+				       u.version, u.created_at, u.updated_at, GREATEST(1 - r.distance, 0)
+				FROM ranked AS r
+				INNER JOIN users AS u ON u.id = r.user_id AND u.deleted_at IS NULL
+				ORDER BY r.distance, u.id
+				LIMIT $2`,
+		query,
+		limit,
+		searchCandidates,
+		addressPenalty,
+	)
+	if err != nil {
+		metrics.DbCall.WithLabelValues("users", "Search", "Failed").Inc()
+
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), map[logger.ExtraKey]interface{}{
+			logger.SelectDBArg: query,
+		})
+		return nil, dbError(err)
+	}
+
+	defer func(rows *sql.Rows) {
+		if err = rows.Close(); err != nil {
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+		}
+	}(rows)
+
+	results := make([]*domain.UserSearchResult, 0, limit)
+	for rows.Next() {
+		var user domain.User
+		var rank float64
+		if err = rows.Scan(
+			&user.ID,
+			&user.UUID,
+			&user.FirstName,
+			&user.LastName,
+			&user.Email,
+			&user.PhoneNumber,
+			&user.Version,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&rank,
+		); err != nil {
+			metrics.DbCall.WithLabelValues("users", "Search", "Failed").Inc()
+
+			r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+			return nil, dbError(err)
+		}
+
+		results = append(results, &domain.UserSearchResult{
+			User: &user,
+			Rank: rank,
+		})
+	}
+
+	if err = rows.Err(); err != nil {
+		metrics.DbCall.WithLabelValues("users", "Search", "Failed").Inc()
+
+		r.log.WithContext(ctx).Error(logger.Database, logger.DatabaseSelect, err.Error(), nil)
+		return nil, dbError(err)
+	}
+
+	metrics.DbCall.WithLabelValues("users", "Search", "Success").Inc()
+
+	return results, nil
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/joho/godotenv"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -15,11 +16,15 @@ var (
 )
 
 type Config struct {
-	App      App
-	DB       DB
-	Log      Log
-	Swagger  Swagger
-	RabbitMQ RabbitMQ
+	App       App
+	DB        DB
+	Log       Log
+	Swagger   Swagger
+	Queue     Queue
+	RabbitMQ  RabbitMQ
+	Tracing   Tracing
+	Retention Retention
+	Auth      Auth
 }
 
 type App struct {
@@ -32,6 +37,9 @@ type App struct {
 	GracefullyShutdown time.Duration
 	HTTPUrl            string
 	HTTPPort           string
+	ConsumerHTTPPort   string
+	// CursorSecret seals the listing cursors, empty hands them out readable
+	CursorSecret string
 }
 
 type Log struct {
@@ -73,10 +81,72 @@ type DB struct {
 	Username   string
 	Password   string
 	Postgres   DBPostgres
+	// ReplicaDSNs are the read replicas, the read only units of work run on them
+	ReplicaDSNs []string
+	// ReplicaMaxLag is the replication lag in seconds a replica is skipped beyond, zero disables the check
+	ReplicaMaxLag time.Duration
+	// ReplicaPrimaryWindow is how many seconds the reads of a client go to the primary after it wrote
+	ReplicaPrimaryWindow time.Duration
+}
+
+type Queue struct {
+	Driver          string
+	ConsumeTimeout  time.Duration
+	MaxRedeliveries int
+	// RedeliveryDelay is the wait before the first redelivery of a failed message in seconds, it doubles
+	// with every further delivery up to RedeliveryMaxDelay
+	RedeliveryDelay    time.Duration
+	RedeliveryMaxDelay time.Duration
+	SchedulerInterval  time.Duration
+	SchedulerBatch     int
+	// OffPeakAt is the HH:MM local time the failed imports are retried at, empty disables it
+	OffPeakAt string
+	// Codec is json, json+gzip, msgpack or msgpack+gzip, Codecs overrides it per queue
+	Codec  string
+	Codecs map[string]string
 }
 
 type RabbitMQ struct {
-	URL string
+	URL               string
+	ConfirmTimeout    time.Duration
+	PublishChannels   int
+	TopologyPath      string
+	TopologyAutoApply bool
+	// ManagementURL is the management API the topology policies are applied through
+	ManagementURL     string
+	ReconnectInterval time.Duration
+	ReconnectMax      time.Duration
+	// Buffer is empty, memory or disk, the buffer holds the produced messages while the connection is down
+	Buffer     string
+	BufferSize int
+	BufferPath string
+	// FlushTimeout bounds the flush of the buffer on close
+	FlushTimeout time.Duration
+}
+
+type Tracing struct {
+	Enable bool
+	// Exporter is stdout or otlp
+	Exporter     string
+	OTLPEndpoint string
+	// SamplePercent of the root spans are recorded, a child follows its parent
+	SamplePercent int
+}
+
+type Auth struct {
+	// GatewaySecret signs the actor header the gateway sets, empty trusts no actor header
+	GatewaySecret string
+}
+
+type Retention struct {
+	// Enable runs the purge inside the consumer every Interval seconds
+	Enable bool
+	// Days the soft deleted users and addresses are kept for
+	Days int
+	// Mode is delete or anonymize, anonymize keeps the rows without their personal data
+	Mode      string
+	BatchSize int
+	Interval  time.Duration
 }
 
 type Configuration interface {
@@ -100,6 +170,8 @@ func (r *Config) LoadConfig(envPath ...string) (Config, error) {
 	app.GracefullyShutdown = time.Duration(getIntEnv("APP_GRACEFULLY_SHUTDOWN", 5))
 	app.HTTPUrl = os.Getenv("HTTP_URL")
 	app.HTTPPort = os.Getenv("HTTP_PORT")
+	app.ConsumerHTTPPort = getStringEnv("CONSUMER_HTTP_PORT", "2536")
+	app.CursorSecret = os.Getenv("APP_CURSOR_SECRET")
 
 	var db DB
 	db.Connection = os.Getenv("DB_CONNECTION")
@@ -113,6 +185,9 @@ func (r *Config) LoadConfig(envPath ...string) (Config, error) {
 	db.Postgres.MaxIdleConnections = getIntEnv("DB_POSTGRES_MAX_IDLE_CONNECTIONS", 0)
 	db.Postgres.MaxLifetime = time.Duration(getIntEnv("DB_POSTGRES_MAX_LIFETIME", 0))
 	db.Postgres.Timezone = os.Getenv("DB_POSTGRES_TIMEZONE")
+	db.ReplicaDSNs = getListEnv("DB_REPLICA_DSNS")
+	db.ReplicaMaxLag = time.Duration(getIntEnv("DB_REPLICA_MAX_LAG", 5))
+	db.ReplicaPrimaryWindow = time.Duration(getIntEnv("DB_REPLICA_PRIMARY_WINDOW", 5))
 
 	var log Log
 	log.FilePath = os.Getenv("LOG_FILE_PATH")
@@ -131,18 +206,70 @@ func (r *Config) LoadConfig(envPath ...string) (Config, error) {
 	swagger.Username = os.Getenv("SWAGGER_USERNAME")
 	swagger.Password = os.Getenv("SWAGGER_PASSWORD")
 
+	var queue Queue
+	queue.Driver = os.Getenv("QUEUE_DRIVER")
+	// QUEUE_CONSUME_TIMEOUT is the final name, the deployments which still set the RABBITMQ_CONSUME_TIMEOUT
+	// it briefly had keep their timeout
+	queue.ConsumeTimeout = time.Duration(getIntEnv("QUEUE_CONSUME_TIMEOUT", getIntEnv("RABBITMQ_CONSUME_TIMEOUT", 30)))
+	queue.MaxRedeliveries = getIntEnv("QUEUE_MAX_REDELIVERIES", 3)
+	queue.RedeliveryDelay = time.Duration(getIntEnv("QUEUE_REDELIVERY_DELAY", 1))
+	queue.RedeliveryMaxDelay = time.Duration(getIntEnv("QUEUE_REDELIVERY_MAX_DELAY", 60))
+	queue.SchedulerInterval = time.Duration(getIntEnv("QUEUE_SCHEDULER_INTERVAL", 1))
+	queue.SchedulerBatch = getIntEnv("QUEUE_SCHEDULER_BATCH", 100)
+	queue.OffPeakAt = os.Getenv("QUEUE_OFF_PEAK_AT")
+	queue.Codec = os.Getenv("QUEUE_CODEC")
+	queue.Codecs = getMapEnv("QUEUE_CODECS")
+
 	var rabbitMQ RabbitMQ
 	rabbitMQ.URL = os.Getenv("RABBITMQ_URL")
+	rabbitMQ.ConfirmTimeout = time.Duration(getIntEnv("RABBITMQ_CONFIRM_TIMEOUT", 5))
+	rabbitMQ.PublishChannels = getIntEnv("RABBITMQ_PUBLISH_CHANNELS", 10)
+	rabbitMQ.TopologyPath = os.Getenv("RABBITMQ_TOPOLOGY_PATH")
+	rabbitMQ.TopologyAutoApply = getBoolEnv("RABBITMQ_TOPOLOGY_AUTO_APPLY", false)
+	rabbitMQ.ManagementURL = os.Getenv("RABBITMQ_MANAGEMENT_URL")
+	rabbitMQ.ReconnectInterval = time.Duration(getIntEnv("RABBITMQ_RECONNECT_INTERVAL", 1))
+	rabbitMQ.ReconnectMax = time.Duration(getIntEnv("RABBITMQ_RECONNECT_MAX", 60))
+	rabbitMQ.Buffer = os.Getenv("RABBITMQ_BUFFER")
+	rabbitMQ.BufferSize = getIntEnv("RABBITMQ_BUFFER_SIZE", 10000)
+	rabbitMQ.BufferPath = os.Getenv("RABBITMQ_BUFFER_PATH")
+	rabbitMQ.FlushTimeout = time.Duration(getIntEnv("RABBITMQ_FLUSH_TIMEOUT", 10))
+
+	var tracing Tracing
+	tracing.Enable = getBoolEnv("TRACING_ENABLE", false)
+	tracing.Exporter = os.Getenv("TRACING_EXPORTER")
+	tracing.OTLPEndpoint = os.Getenv("TRACING_OTLP_ENDPOINT")
+	tracing.SamplePercent = getIntEnv("TRACING_SAMPLE_PERCENT", 100)
+
+	var retention Retention
+	retention.Enable = getBoolEnv("RETENTION_ENABLE", false)
+	retention.Days = getIntEnv("RETENTION_DAYS", 30)
+	retention.Mode = os.Getenv("RETENTION_MODE")
+	retention.BatchSize = getIntEnv("RETENTION_BATCH_SIZE", 100)
+	retention.Interval = time.Duration(getIntEnv("RETENTION_INTERVAL", 3600))
+
+	var auth Auth
+	auth.GatewaySecret = os.Getenv("AUTH_GATEWAY_SECRET")
 
 	return Config{
-		App:      app,
-		DB:       db,
-		Log:      log,
-		Swagger:  swagger,
-		RabbitMQ: rabbitMQ,
+		App:       app,
+		DB:        db,
+		Log:       log,
+		Swagger:   swagger,
+		Queue:     queue,
+		RabbitMQ:  rabbitMQ,
+		Tracing:   tracing,
+		Retention: retention,
+		Auth:      auth,
 	}, nil
 }
 
+func getStringEnv(key string, defaultValue string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return defaultValue
+}
+
 func getBoolEnv(key string, defaultValue bool) bool {
 	val, err := strconv.ParseBool(os.Getenv(key))
 	if err != nil {
@@ -159,6 +286,29 @@ func getIntEnv(key string, defaultValue int) int {
 	return val
 }
 
+// getListEnv reads a comma separated list, the empty items are dropped
+func getListEnv(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getMapEnv reads a comma separated list of key=value pairs
+func getMapEnv(key string) map[string]string {
+	values := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && name != "" {
+			values[name] = value
+		}
+	}
+	return values
+}
+
 func (r *Config) GetConfig(envPath ...string) Config {
 	once.Do(func() {
 		var err error
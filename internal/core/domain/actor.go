@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"context"
+	"github.com/google/uuid"
+)
+
+type actorKey struct{}
+
+// Actor the principal a change is made by, a user calling the API or a job of the system
+type Actor struct {
+	// ID the users.id of the user, zero for a job or when the user is not known
+	ID   uint64
+	UUID uuid.UUID
+	// Name names the job acting on its own
+	Name string
+}
+
+func SystemActor(name string) Actor {
+	return Actor{Name: name}
+}
+
+func (r Actor) IsUser() bool {
+	return r.UUID != uuid.Nil
+}
+
+func WithActor(ctx context.Context, actor Actor) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the zero actor when the context carries none
+func ActorFromContext(ctx context.Context) Actor {
+	actor, _ := ctx.Value(actorKey{}).(Actor)
+	return actor
+}
@@ -0,0 +1,119 @@
+package domain
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+const (
+	DefaultAuditLogLimit = 20
+	MaxAuditLogLimit     = 100
+)
+
+// the entities the audit log records
+const (
+	AuditEntityUser    = "user"
+	AuditEntityAddress = "address"
+)
+
+// the actions the audit log records
+const (
+	AuditCreated   = "created"
+	AuditUpdated   = "updated"
+	AuditDeleted   = "deleted"
+	AuditRestored  = "restored"
+	AuditDestroyed = "destroyed"
+	// AuditPurged and AuditAnonymized the retention purge removed the row or its personal data
+	AuditPurged     = "purged"
+	AuditAnonymized = "anonymized"
+)
+
+type AuditChange struct {
+	Old *string `json:"old"`
+	New *string `json:"new"`
+}
+
+// AuditLog an entry of the history of a user, the entries of its addresses are part of it
+type AuditLog struct {
+	ID         uint64
+	UserUUID   uuid.UUID
+	Entity     string
+	EntityUUID uuid.UUID
+	Action     string
+	Actor      Actor
+	// Changes the changed fields by their column name
+	Changes   map[string]AuditChange
+	CreatedAt time.Time
+}
+
+type AuditLogQuery struct {
+	Limit  int
+	Cursor string
+}
+
+type AuditLogPage struct {
+	Logs       []*AuditLog
+	NextCursor string
+	HasMore    bool
+	Limit      int
+}
+
+// UserChanges the fields which differ, a nil before is a creation and a nil after a removal
+func UserChanges(before *User, after *User) map[string]AuditChange {
+	var old, current User
+	if before != nil {
+		old = *before
+	}
+	if after != nil {
+		current = *after
+	}
+
+	changes := make(map[string]AuditChange)
+	diff(changes, "first_name", old.FirstName, current.FirstName)
+	diff(changes, "last_name", old.LastName, current.LastName)
+	diff(changes, "email", nonEmpty(old.Email), nonEmpty(current.Email))
+	diff(changes, "phone_number", nonEmpty(old.PhoneNumber), nonEmpty(current.PhoneNumber))
+
+	return changes
+}
+
+// AddressChanges the fields which differ, a nil before is a creation and a nil after a removal
+func AddressChanges(before *Address, after *Address) map[string]AuditChange {
+	var old, current Address
+	if before != nil {
+		old = *before
+	}
+	if after != nil {
+		current = *after
+	}
+
+	changes := make(map[string]AuditChange)
+	diff(changes, "street", old.Street, current.Street)
+	diff(changes, "city", old.City, current.City)
+	diff(changes, "state", old.State, current.State)
+	diff(changes, "zip_code", old.ZipCode, current.ZipCode)
+	diff(changes, "country", old.Country, current.Country)
+
+	return changes
+}
+
+func diff(changes map[string]AuditChange, field string, old *string, current *string) {
+	if old == nil && current == nil {
+		return
+	}
+	if old != nil && current != nil && *old == *current {
+		return
+	}
+
+	changes[field] = AuditChange{
+		Old: old,
+		New: current,
+	}
+}
+
+func nonEmpty(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
@@ -11,11 +11,21 @@ type Modifier struct {
 	DeleteBy  uint64
 }
 
+// AnyVersion an expected version which every version matches
+const AnyVersion = 0
+
 type Base struct {
 	ID   uint64
 	UUID uuid.UUID
+	// Version grows on every write of the row
+	Version int
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt time.Time
 }
+
+// MatchVersion whether the row is still at the version the writer read
+func (r Base) MatchVersion(expected int) bool {
+	return expected == AnyVersion || expected == r.Version
+}
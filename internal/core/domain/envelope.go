@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
+
+const EnvelopeContentType = "application/json"
+
+// Envelope wraps every queue payload, so consumers are decoupled from the internal structs
+type Envelope struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	Version       int             `json:"version"`
+	Timestamp     time.Time       `json:"timestamp"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+	// Headers carries the trace context, the RabbitMQ driver moves it to the message headers
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+func NewEnvelope(eventType string, version int, payload interface{}) (*Envelope, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		ID:        uuid.NewString(),
+		Type:      eventType,
+		Version:   version,
+		Timestamp: time.Now().UTC(),
+		Payload:   body,
+	}, nil
+}
+
+func (r *Envelope) WithCorrelationID(correlationID string) *Envelope {
+	r.CorrelationID = correlationID
+	return r
+}
+
+// DecodeEnvelope parses a message body, a body produced before the envelope existed
+// is returned as version 0 with the whole body as payload. The keys are matched exactly
+// before the body is decoded, json.Unmarshal matches them case insensitively and would
+// read the "ID" of a raw domain.User into the envelope id
+func DecodeEnvelope(body []byte) (*Envelope, error) {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(body, &keys); err != nil {
+		return nil, err
+	}
+
+	_, hasType := keys["type"]
+	_, hasPayload := keys["payload"]
+	if !hasType || !hasPayload {
+		return &Envelope{
+			Payload: body,
+		}, nil
+	}
+
+	var envelope Envelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, err
+	}
+
+	return &envelope, nil
+}
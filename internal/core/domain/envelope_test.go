@@ -0,0 +1,89 @@
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecodeEnvelope(t *testing.T) {
+	firstName := "John"
+	legacyUser, err := json.Marshal(User{
+		Base:        Base{ID: 7, Version: 2},
+		FirstName:   &firstName,
+		Email:       "john@example.com",
+		PhoneNumber: "09120000000",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope, err := NewEnvelope("user.save", 1, map[string]string{"email": "john@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enveloped, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		body        []byte
+		wantType    string
+		wantVersion int
+		wantPayload string
+		wantErr     bool
+	}{
+		{
+			name:        "envelope",
+			body:        enveloped,
+			wantType:    "user.save",
+			wantVersion: 1,
+			wantPayload: `{"email":"john@example.com"}`,
+		},
+		{
+			name:        "legacy user with an upper case ID",
+			body:        legacyUser,
+			wantPayload: string(legacyUser),
+		},
+		{
+			name:        "keys in another case are not an envelope",
+			body:        []byte(`{"Type":"user.save","Payload":{}}`),
+			wantPayload: `{"Type":"user.save","Payload":{}}`,
+		},
+		{
+			name:        "type without payload",
+			body:        []byte(`{"type":"user.save","email":"john@example.com"}`),
+			wantPayload: `{"type":"user.save","email":"john@example.com"}`,
+		},
+		{
+			name:    "not an object",
+			body:    []byte(`[1,2]`),
+			wantErr: true,
+		},
+		{
+			name:    "invalid json",
+			body:    []byte(`{"type":`),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeEnvelope(tt.body)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeEnvelope() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got.Type != tt.wantType || got.Version != tt.wantVersion {
+				t.Errorf("DecodeEnvelope() = %s v%d, want %s v%d", got.Type, got.Version, tt.wantType, tt.wantVersion)
+			}
+			if string(got.Payload) != tt.wantPayload {
+				t.Errorf("DecodeEnvelope() payload = %s, want %s", got.Payload, tt.wantPayload)
+			}
+		})
+	}
+}
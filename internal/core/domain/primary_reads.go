@@ -0,0 +1,18 @@
+package domain
+
+import (
+	"context"
+)
+
+type primaryReadsKey struct{}
+
+// WithPrimaryReads the read only units of work of ctx skip the replicas, the client wrote a moment ago
+// and a replica which is a little behind would still show it the data from before its write
+func WithPrimaryReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadsKey{}, true)
+}
+
+func PrimaryReads(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryReadsKey{}).(bool)
+	return primary
+}
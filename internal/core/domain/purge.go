@@ -0,0 +1,39 @@
+package domain
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+const DefaultPurgeBatchSize = 100
+
+// the ways the purge removes the personal data of the soft deleted rows
+const (
+	PurgeDelete    = "delete"
+	PurgeAnonymize = "anonymize"
+)
+
+type PurgeOptions struct {
+	// DeletedBefore the rows soft deleted before it are purged
+	DeletedBefore time.Time
+	Mode          string
+	BatchSize     int
+	// DryRun reports the rows which would be purged and leaves them alone
+	DryRun bool
+}
+
+// PurgeCandidate a soft deleted user or address the retention period of is over
+type PurgeCandidate struct {
+	ID        uint64
+	UUID      uuid.UUID
+	UserUUID  uuid.UUID
+	Entity    string
+	DeletedAt time.Time
+}
+
+type PurgeReport struct {
+	Mode      string
+	DryRun    bool
+	Users     []PurgeCandidate
+	Addresses []PurgeCandidate
+}
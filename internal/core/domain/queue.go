@@ -0,0 +1,65 @@
+package domain
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"time"
+)
+
+type QueueStats struct {
+	Name      string
+	Messages  int
+	Consumers int
+}
+
+// QueueMessage is a message read by the queue administration without consuming it
+type QueueMessage struct {
+	ID        string
+	Type      string
+	Timestamp time.Time
+	Attempts  int
+	Body      []byte
+}
+
+// QueueFilter selects the messages the queue administration moves or exports, an empty field matches every message
+type QueueFilter struct {
+	Type     string
+	Contains string
+}
+
+func (r QueueFilter) Match(message QueueMessage) bool {
+	if r.Type != "" && message.Type != r.Type {
+		return false
+	}
+	if r.Contains != "" && !bytes.Contains(message.Body, []byte(r.Contains)) {
+		return false
+	}
+	return true
+}
+
+// UnpublishedMessage is a produced message the driver accepted but could not hand to the broker before it closed
+type UnpublishedMessage struct {
+	Queue    string
+	Envelope *Envelope
+}
+
+type ScheduleStatus string
+
+const (
+	SchedulePending    ScheduleStatus = "pending"
+	ScheduleDispatched ScheduleStatus = "dispatched"
+	ScheduleCancelled  ScheduleStatus = "cancelled"
+)
+
+// ScheduledMessage is a message kept back until its delivery time
+type ScheduledMessage struct {
+	ID           uuid.UUID
+	Queue        string
+	Envelope     *Envelope
+	DeliverAt    time.Time
+	Status       ScheduleStatus
+	Attempts     int
+	LastError    string
+	DispatchedAt *time.Time
+	CancelledAt  *time.Time
+}
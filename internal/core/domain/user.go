@@ -11,3 +11,26 @@ type User struct {
 
 	Addresses []*Address
 }
+
+// UserPatch holds the fields of a partial update, a nil field keeps its value
+type UserPatch struct {
+	FirstName   *string
+	LastName    *string
+	Email       *string
+	PhoneNumber *string
+}
+
+func (r UserPatch) Apply(user *User) {
+	if r.FirstName != nil {
+		user.FirstName = r.FirstName
+	}
+	if r.LastName != nil {
+		user.LastName = r.LastName
+	}
+	if r.Email != nil {
+		user.Email = *r.Email
+	}
+	if r.PhoneNumber != nil {
+		user.PhoneNumber = *r.PhoneNumber
+	}
+}
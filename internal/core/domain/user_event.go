@@ -0,0 +1,90 @@
+package domain
+
+import (
+	"github.com/google/uuid"
+)
+
+// the lifecycle event types double as the routing keys on the user events exchange
+const (
+	UserCreatedType    = "user.created"
+	UserUpdatedType    = "user.updated"
+	UserDeletedType    = "user.deleted"
+	AddressChangedType = "user.address_changed"
+
+	UserEventVersion = 1
+
+	// UserEventsExchange is the topic exchange the events are routed to without a topology file
+	UserEventsExchange = "user_events"
+)
+
+// UserEventTypes are routed to the subscribers only, nobody is subscribed until a consumer registers
+var UserEventTypes = []string{UserCreatedType, UserUpdatedType, UserDeletedType, AddressChangedType}
+
+type UserSnapshot struct {
+	ID          uuid.UUID         `json:"id"`
+	FirstName   *string           `json:"first_name"`
+	LastName    *string           `json:"last_name"`
+	Email       string            `json:"email"`
+	PhoneNumber string            `json:"phone_number"`
+	Addresses   []AddressSnapshot `json:"addresses"`
+}
+
+type AddressSnapshot struct {
+	ID      uuid.UUID `json:"id"`
+	Street  *string   `json:"street"`
+	City    *string   `json:"city"`
+	State   *string   `json:"state"`
+	ZipCode *string   `json:"zip_code"`
+	Country *string   `json:"country"`
+}
+
+func NewUserSnapshot(user *User) *UserSnapshot {
+	if user == nil {
+		return nil
+	}
+
+	return &UserSnapshot{
+		ID:          user.UUID,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		Email:       user.Email,
+		PhoneNumber: user.PhoneNumber,
+		Addresses:   NewAddressSnapshots(user.Addresses),
+	}
+}
+
+func NewAddressSnapshots(addresses []*Address) []AddressSnapshot {
+	snapshots := make([]AddressSnapshot, 0, len(addresses))
+	for _, address := range addresses {
+		snapshots = append(snapshots, AddressSnapshot{
+			ID:      address.UUID,
+			Street:  address.Street,
+			City:    address.City,
+			State:   address.State,
+			ZipCode: address.ZipCode,
+			Country: address.Country,
+		})
+	}
+
+	return snapshots
+}
+
+type UserCreated struct {
+	After *UserSnapshot `json:"after"`
+}
+
+type UserUpdated struct {
+	Before *UserSnapshot `json:"before"`
+	After  *UserSnapshot `json:"after"`
+}
+
+type UserDeleted struct {
+	Before *UserSnapshot `json:"before"`
+}
+
+// AddressChanged carries the whole address list of the user before and after the change
+type AddressChanged struct {
+	UserID uuid.UUID         `json:"user_id"`
+	Before []AddressSnapshot `json:"before"`
+	After  []AddressSnapshot `json:"after"`
+}
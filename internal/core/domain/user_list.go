@@ -0,0 +1,44 @@
+package domain
+
+import "time"
+
+const (
+	DefaultUserListLimit = 20
+	MaxUserListLimit     = 100
+)
+
+// the deleted status a listing filters on
+const (
+	WithoutDeleted = "without"
+	WithDeleted    = "with"
+	OnlyDeleted    = "only"
+)
+
+// UserFilter an empty field does not filter
+type UserFilter struct {
+	Email       string
+	PhoneNumber string
+	// Name matches the beginning of the first name, the last name or the full name
+	Name        string
+	Country     string
+	City        string
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	Deleted     string
+}
+
+// UserListQuery the cursor is the NextCursor of the previous page and only valid for the same sort
+type UserListQuery struct {
+	Filter UserFilter
+	Sort   string
+	Desc   bool
+	Limit  int
+	Cursor string
+}
+
+type UserPage struct {
+	Users      []*User
+	NextCursor string
+	HasMore    bool
+	Limit      int
+}
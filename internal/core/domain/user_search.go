@@ -0,0 +1,14 @@
+package domain
+
+const (
+	DefaultUserSearchLimit = 20
+	MaxUserSearchLimit     = 50
+)
+
+// UserSearchResult Rank is between 0 and 1, Highlights holds the matched fields with the matches
+// wrapped in <mark>, an address field is keyed like addresses.0.city
+type UserSearchResult struct {
+	User       *User
+	Rank       float64
+	Highlights map[string]string
+}
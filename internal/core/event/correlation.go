@@ -0,0 +1,15 @@
+package event
+
+import "context"
+
+type correlationIDKey struct{}
+
+// WithCorrelationID the id is copied into every envelope published with the returned context
+func WithCorrelationID(ctx context.Context, correlationID string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, correlationID)
+}
+
+func CorrelationID(ctx context.Context) string {
+	correlationID, _ := ctx.Value(correlationIDKey{}).(string)
+	return correlationID
+}
@@ -0,0 +1,74 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+)
+
+type Handler func(ctx context.Context, envelope *domain.Envelope) error
+
+type Upcaster func(payload json.RawMessage) (json.RawMessage, error)
+
+type handlerKey struct {
+	eventType string
+	version   int
+}
+
+// Dispatcher routes an enveloped message to the handler registered for its type and version,
+// older versions are upcasted step by step until a handler is found
+type Dispatcher struct {
+	legacyType string
+	handlers   map[handlerKey]Handler
+	upcasters  map[handlerKey]Upcaster
+}
+
+// NewDispatcher legacyType is used for messages which were published without an envelope
+func NewDispatcher(legacyType string) *Dispatcher {
+	return &Dispatcher{
+		legacyType: legacyType,
+		handlers:   make(map[handlerKey]Handler),
+		upcasters:  make(map[handlerKey]Upcaster),
+	}
+}
+
+func (r *Dispatcher) Handle(eventType string, version int, handler Handler) *Dispatcher {
+	r.handlers[handlerKey{eventType: eventType, version: version}] = handler
+	return r
+}
+
+// Upcast registers a conversion of the payload from fromVersion to fromVersion+1
+func (r *Dispatcher) Upcast(eventType string, fromVersion int, upcaster Upcaster) *Dispatcher {
+	r.upcasters[handlerKey{eventType: eventType, version: fromVersion}] = upcaster
+	return r
+}
+
+// Dispatch the envelope is decoded by the driver with the codec it was produced with
+func (r *Dispatcher) Dispatch(ctx context.Context, envelope *domain.Envelope) error {
+	if envelope.Type == "" {
+		envelope.Type = r.legacyType
+	}
+
+	for {
+		key := handlerKey{eventType: envelope.Type, version: envelope.Version}
+		if handler, ok := r.handlers[key]; ok {
+			if envelope.CorrelationID != "" {
+				ctx = WithCorrelationID(ctx, envelope.CorrelationID)
+			}
+			return handler(ctx, envelope)
+		}
+
+		upcaster, ok := r.upcasters[key]
+		if !ok {
+			return fmt.Errorf("no handler for message type %s version %d", envelope.Type, envelope.Version)
+		}
+
+		payload, err := upcaster(envelope.Payload)
+		if err != nil {
+			return err
+		}
+		envelope.Payload = payload
+		envelope.Version++
+	}
+}
@@ -0,0 +1,60 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"testing"
+)
+
+func TestDispatchUpcastsLegacyUser(t *testing.T) {
+	firstName := "John"
+	body, err := json.Marshal(domain.User{
+		Base:      domain.Base{ID: 7},
+		FirstName: &firstName,
+		Email:     "john@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope, err := domain.DecodeEnvelope(body)
+	if err != nil {
+		t.Fatalf("DecodeEnvelope() error = %v", err)
+	}
+
+	var handled *domain.Envelope
+	dispatcher := NewDispatcher(SaveUserType).
+		Upcast(SaveUserType, 0, upcastSaveUserV0).
+		Handle(SaveUserType, SaveUserVersion, func(_ context.Context, envelope *domain.Envelope) error {
+			handled = envelope
+			return nil
+		})
+
+	if err = dispatcher.Dispatch(context.Background(), envelope); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+	if handled == nil {
+		t.Fatal("handler is not called")
+	}
+	if handled.Type != SaveUserType || handled.Version != SaveUserVersion {
+		t.Errorf("handled %s v%d, want %s v%d", handled.Type, handled.Version, SaveUserType, SaveUserVersion)
+	}
+
+	var user domain.User
+	if err = json.Unmarshal(handled.Payload, &user); err != nil {
+		t.Fatalf("payload is not a user: %v", err)
+	}
+	if user.Email != "john@example.com" || user.FirstName == nil || *user.FirstName != firstName {
+		t.Errorf("payload user = %+v, want the legacy user", user)
+	}
+}
+
+func TestDispatchWithoutHandler(t *testing.T) {
+	dispatcher := NewDispatcher(SaveUserType)
+
+	envelope := &domain.Envelope{Type: "user.unknown", Version: 1}
+	if err := dispatcher.Dispatch(context.Background(), envelope); err == nil {
+		t.Error("Dispatch() error = nil, want an error for a type without handler")
+	}
+}
@@ -0,0 +1,33 @@
+package event
+
+import (
+	"github.com/mohsenabedy91/Sikabiz/internal/adaper/meesagebroker"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
+	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+)
+
+type Dependencies struct {
+	Queue *messagebroker.Queue
+	Log   logger.Logger
+	// UnitOfWork returns a new unit of work for every consumed message
+	UnitOfWork  func() port.UserUnitOfWork
+	UserService port.UserService
+}
+
+// Events lists every event of the service, a new queue is added here
+// and becomes available to the publishers and the consumer binary
+func Events(deps Dependencies) []port.Event {
+	return []port.Event{
+		NewSaveUser(deps.Queue, deps.Log, deps.UnitOfWork, deps.UserService),
+	}
+}
+
+// NewDefaultRegistry returns a registry with all the events of Events
+func NewDefaultRegistry(deps Dependencies) (*Registry, error) {
+	registry := NewRegistry()
+	if err := registry.Add(Events(deps)...); err != nil {
+		return nil, err
+	}
+
+	return registry, nil
+}
@@ -0,0 +1,69 @@
+package event
+
+import (
+	"fmt"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
+)
+
+// Registry maps event names to the events which publish and consume them,
+// every binary and test builds its own instance
+type Registry struct {
+	events map[string]port.Event
+	names  []string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		events: make(map[string]port.Event),
+	}
+}
+
+// Add fails on a name which is already registered, two events must never share a queue by accident
+func (r *Registry) Add(events ...port.Event) error {
+	for _, event := range events {
+		if _, ok := r.events[event.Name()]; ok {
+			return fmt.Errorf("event %s is already registered", event.Name())
+		}
+		r.events[event.Name()] = event
+		r.names = append(r.names, event.Name())
+	}
+
+	return nil
+}
+
+// Publisher returns the event registered under name to publish messages with
+func (r *Registry) Publisher(name string) (port.Event, error) {
+	event, ok := r.events[name]
+	if !ok {
+		return nil, fmt.Errorf("event %s is not registered", name)
+	}
+
+	return event, nil
+}
+
+// Names returns the registered names in registration order
+func (r *Registry) Names() []string {
+	return append([]string(nil), r.names...)
+}
+
+// Subscribe registers the consumers of the named events, no names subscribes to all of them
+func (r *Registry) Subscribe(names ...string) error {
+	if len(names) == 0 {
+		names = r.names
+	}
+
+	selected := make([]port.Event, 0, len(names))
+	for _, name := range names {
+		event, err := r.Publisher(name)
+		if err != nil {
+			return err
+		}
+		selected = append(selected, event)
+	}
+
+	for _, event := range selected {
+		event.Register()
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package event
+
+import (
+	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
+	"reflect"
+	"testing"
+)
+
+type fakeEvent struct {
+	port.Event
+	name       string
+	registered *[]string
+}
+
+func (r fakeEvent) Name() string {
+	return r.name
+}
+
+func (r fakeEvent) Register() {
+	*r.registered = append(*r.registered, r.name)
+}
+
+func TestRegistryAddRejectsDuplicates(t *testing.T) {
+	var registered []string
+	tests := []struct {
+		name    string
+		events  []string
+		wantErr bool
+	}{
+		{name: "distinct", events: []string{"save_user", "delete_user"}},
+		{name: "duplicate in one call", events: []string{"save_user", "save_user"}, wantErr: true},
+		{name: "empty", events: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewRegistry()
+			events := make([]port.Event, 0, len(tt.events))
+			for _, name := range tt.events {
+				events = append(events, fakeEvent{name: name, registered: &registered})
+			}
+
+			if err := registry.Add(events...); (err != nil) != tt.wantErr {
+				t.Fatalf("Add() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegistryAddRejectsDuplicateAcrossCalls(t *testing.T) {
+	var registered []string
+	registry := NewRegistry()
+	if err := registry.Add(fakeEvent{name: "save_user", registered: &registered}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := registry.Add(fakeEvent{name: "save_user", registered: &registered}); err == nil {
+		t.Fatal("Add() of a registered name did not fail")
+	}
+	if got := registry.Names(); !reflect.DeepEqual(got, []string{"save_user"}) {
+		t.Fatalf("Names() = %v", got)
+	}
+}
+
+func TestRegistrySubscribe(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "all", want: []string{"save_user", "delete_user", "notify_user"}},
+		{name: "subset", names: []string{"notify_user", "save_user"}, want: []string{"notify_user", "save_user"}},
+		{name: "unknown", names: []string{"save_user", "missing"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var registered []string
+			registry := NewRegistry()
+			for _, name := range []string{"save_user", "delete_user", "notify_user"} {
+				if err := registry.Add(fakeEvent{name: name, registered: &registered}); err != nil {
+					t.Fatalf("Add() error = %v", err)
+				}
+			}
+
+			err := registry.Subscribe(tt.names...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Subscribe() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			// an unknown name must not leave the known ones half registered
+			if !reflect.DeepEqual(registered, tt.want) {
+				t.Fatalf("registered = %v, want %v", registered, tt.want)
+			}
+		})
+	}
+}
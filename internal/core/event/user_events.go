@@ -0,0 +1,91 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/mohsenabedy91/Sikabiz/internal/adaper/meesagebroker"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"time"
+)
+
+// eventRetryDelay is how long a failed event waits in the scheduler before it is published again
+const eventRetryDelay = time.Minute
+
+// UserEvents publishes the user lifecycle events, the event type is the name the driver routes by,
+// RabbitMQ sends it to the user events topic exchange, the other drivers keep a queue per subscribed type
+type UserEvents struct {
+	queue *messagebroker.Queue
+	log   logger.Logger
+}
+
+func NewUserEvents(queue *messagebroker.Queue, log logger.Logger) *UserEvents {
+	return &UserEvents{
+		queue: queue,
+		log:   log,
+	}
+}
+
+func (r *UserEvents) UserCreated(ctx context.Context, user *domain.User) error {
+	return r.publish(ctx, domain.UserCreatedType, domain.UserCreated{
+		After: domain.NewUserSnapshot(user),
+	})
+}
+
+func (r *UserEvents) UserUpdated(ctx context.Context, before *domain.User, after *domain.User) error {
+	return r.publish(ctx, domain.UserUpdatedType, domain.UserUpdated{
+		Before: domain.NewUserSnapshot(before),
+		After:  domain.NewUserSnapshot(after),
+	})
+}
+
+func (r *UserEvents) UserDeleted(ctx context.Context, before *domain.User) error {
+	return r.publish(ctx, domain.UserDeletedType, domain.UserDeleted{
+		Before: domain.NewUserSnapshot(before),
+	})
+}
+
+func (r *UserEvents) AddressChanged(ctx context.Context, userID uuid.UUID, before []*domain.Address, after []*domain.Address) error {
+	return r.publish(ctx, domain.AddressChangedType, domain.AddressChanged{
+		UserID: userID,
+		Before: domain.NewAddressSnapshots(before),
+		After:  domain.NewAddressSnapshots(after),
+	})
+}
+
+func (r *UserEvents) publish(ctx context.Context, eventType string, payload interface{}) error {
+	envelope, err := domain.NewEnvelope(eventType, domain.UserEventVersion, payload)
+	if err != nil {
+		return err
+	}
+	envelope.WithCorrelationID(CorrelationID(ctx))
+
+	if err = r.queue.Driver.Produce(ctx, eventType, envelope, 0); err != nil {
+		extra := map[logger.ExtraKey]interface{}{
+			logger.MessageID:   envelope.ID,
+			logger.MessageType: eventType,
+		}
+		r.log.WithContext(ctx).Error(logger.Queue, logger.RabbitMQPublish, fmt.Sprintf("Error publish %s event: %v", eventType, err), extra)
+
+		return r.record(ctx, eventType, envelope, err, extra)
+	}
+
+	return nil
+}
+
+// record keeps a failed event in the scheduler, which publishes it again, the change it reports is
+// committed already, so the event is lost only when there is no scheduler or it fails too
+func (r *UserEvents) record(ctx context.Context, eventType string, envelope *domain.Envelope, err error, extra map[logger.ExtraKey]interface{}) error {
+	if r.queue.Scheduler == nil {
+		return err
+	}
+
+	if _, scheduleErr := r.queue.Scheduler.Schedule(ctx, eventType, envelope, time.Now().Add(eventRetryDelay)); scheduleErr != nil {
+		return errors.Join(err, scheduleErr)
+	}
+	r.log.WithContext(ctx).Warn(logger.Queue, logger.Scheduler, fmt.Sprintf("Recorded %s event for a retry", eventType), extra)
+
+	return nil
+}
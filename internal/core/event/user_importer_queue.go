@@ -2,50 +2,64 @@ package event
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
 	"fmt"
+	"github.com/google/uuid"
 	"github.com/mohsenabedy91/Sikabiz/internal/adaper/meesagebroker"
-	"github.com/mohsenabedy91/Sikabiz/internal/adaper/storage/postgres/userrepository"
 	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
 	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
 	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"time"
 )
 
 type SaveUser struct {
 	queue       *messagebroker.Queue
 	log         logger.Logger
-	db          *sql.DB
+	uowFactory  func() port.UserUnitOfWork
 	userService port.UserService
+	dispatcher  *Dispatcher
 }
 
-var saveUserInstance *SaveUser
-
-const DelaySaveUserSeconds int64 = 0
 const SaveUserName = "save_user_queue"
-
-func NewSaveUser(queue *messagebroker.Queue, log logger.Logger, db *sql.DB, userService port.UserService) *SaveUser {
-	if saveUserInstance == nil {
-		saveUserInstance = &SaveUser{
-			queue:       queue,
-			log:         log,
-			db:          db,
-			userService: userService,
-		}
+const SaveUserType = "user.save"
+
+// SaveUserVersion version 0 is the raw domain.User body published before the envelope existed
+const SaveUserVersion = 1
+
+// NewSaveUser uowFactory returns a new unit of work for every consumed message
+func NewSaveUser(queue *messagebroker.Queue, log logger.Logger, uowFactory func() port.UserUnitOfWork, userService port.UserService) *SaveUser {
+	saveUser := &SaveUser{
+		queue:       queue,
+		log:         log,
+		uowFactory:  uowFactory,
+		userService: userService,
 	}
+	saveUser.dispatcher = NewDispatcher(SaveUserType).
+		Upcast(SaveUserType, 0, upcastSaveUserV0).
+		Handle(SaveUserType, SaveUserVersion, saveUser.handle)
+
+	return saveUser
+}
 
-	return saveUserInstance
+// upcastSaveUserV0 the raw domain.User body is already the payload of version 1
+func upcastSaveUserV0(payload json.RawMessage) (json.RawMessage, error) {
+	return payload, nil
 }
 
 func (r *SaveUser) Name() string {
 	return SaveUserName
 }
 
-func (r *SaveUser) Publish(message interface{}) error {
-	if err := r.queue.Driver.Produce(r.Name(), message, DelaySaveUserSeconds); err != nil {
+func (r *SaveUser) Publish(ctx context.Context, message interface{}) error {
+	envelope, err := r.envelope(ctx, message)
+	if err != nil {
+		return err
+	}
+
+	if err = r.queue.Driver.Produce(ctx, r.Name(), envelope, 0); err != nil {
 		return err
 	}
-	r.queue.Log.Info(
+	r.queue.Log.WithContext(ctx).Info(
 		logger.Queue,
 		logger.RabbitMQPublish,
 		fmt.Sprintf("published successfully to queue: %s", message),
@@ -55,37 +69,80 @@ func (r *SaveUser) Publish(message interface{}) error {
 	return nil
 }
 
-func (r *SaveUser) Consume(message []byte) error {
-	extra := map[logger.ExtraKey]interface{}{
-		logger.Body: string(message),
+// PublishAt keeps the message back until deliverAt, the returned id cancels or reschedules it
+func (r *SaveUser) PublishAt(ctx context.Context, message interface{}, deliverAt time.Time) (uuid.UUID, error) {
+	if r.queue.Scheduler == nil {
+		return uuid.Nil, messagebroker.ErrSchedulerNotAvailable
 	}
-	var user domain.User
-	if err := json.Unmarshal(message, &user); err != nil {
-		r.queue.Log.Error(logger.Queue, logger.RabbitMQConsume, fmt.Sprintf("Error unmarshalling message, error: %v", err), extra)
-		return err
+
+	envelope, err := r.envelope(ctx, message)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	id, err := r.queue.Scheduler.Schedule(ctx, r.Name(), envelope, deliverAt)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	r.queue.Log.WithContext(ctx).Info(logger.Queue, logger.Scheduler, fmt.Sprintf("scheduled to queue: %s", r.Name()), map[logger.ExtraKey]interface{}{
+		logger.ScheduleID: id,
+		logger.MessageID:  envelope.ID,
+		logger.DeliverAt:  deliverAt,
+	})
+
+	return id, nil
+}
+
+func (r *SaveUser) envelope(ctx context.Context, message interface{}) (*domain.Envelope, error) {
+	if envelope, ok := message.(*domain.Envelope); ok {
+		return envelope, nil
 	}
 
-	uowFactory := func() port.UserUnitOfWork {
-		return userrepository.NewUnitOfWork(r.log, r.db)
+	envelope, err := domain.NewEnvelope(SaveUserType, SaveUserVersion, message)
+	if err != nil {
+		return nil, err
 	}
-	uow := uowFactory()
 
-	if err := uow.BeginTx(context.Background()); err != nil {
+	return envelope.WithCorrelationID(CorrelationID(ctx)), nil
+}
+
+func (r *SaveUser) Consume(ctx context.Context, envelope *domain.Envelope) error {
+	if err := r.dispatcher.Dispatch(ctx, envelope); err != nil {
+		r.queue.Log.WithContext(ctx).Error(logger.Queue, logger.RabbitMQConsume, fmt.Sprintf("Error dispatching message, error: %v", err), map[logger.ExtraKey]interface{}{
+			logger.MessageID: envelope.ID,
+			logger.Body:      string(envelope.Payload),
+		})
 		return err
 	}
 
-	if err := r.userService.Create(uow, &user); err != nil {
-		if rollbackErr := uow.Rollback(); rollbackErr != nil {
-			return rollbackErr
-		}
+	return nil
+}
+
+func (r *SaveUser) handle(ctx context.Context, envelope *domain.Envelope) error {
+	extra := map[logger.ExtraKey]interface{}{
+		logger.MessageID:      envelope.ID,
+		logger.MessageType:    envelope.Type,
+		logger.MessageVersion: envelope.Version,
+		logger.Body:           string(envelope.Payload),
+	}
+	var user domain.User
+	if err := json.Unmarshal(envelope.Payload, &user); err != nil {
+		r.queue.Log.WithContext(ctx).Error(logger.Queue, logger.RabbitMQConsume, fmt.Sprintf("Error unmarshalling message, error: %v", err), extra)
 		return err
 	}
 
-	if commitErr := uow.Commit(); commitErr != nil {
-		return commitErr
+	uow := r.uowFactory()
+
+	// the audit log records the consumed users as created by the consumer of the queue
+	ctx = domain.WithActor(ctx, domain.SystemActor(r.Name()))
+
+	if err := uow.WithTransaction(ctx, port.TxOptions{}, func(ctx context.Context) error {
+		return r.userService.Create(ctx, uow, &user)
+	}); err != nil {
+		return err
 	}
 
-	r.queue.Log.Info(logger.Database, logger.DatabaseInsert, "The message has been consumed successfully!", nil)
+	r.queue.Log.WithContext(ctx).Info(logger.Database, logger.DatabaseInsert, "The message has been consumed successfully!", extra)
 
 	return nil
 }
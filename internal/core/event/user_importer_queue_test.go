@@ -0,0 +1,128 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/mohsenabedy91/Sikabiz/internal/adaper/meesagebroker"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/config"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
+	"testing"
+	"time"
+)
+
+// fakeUnitOfWork runs the transactions of the consumer without a database
+type fakeUnitOfWork struct {
+	port.UserUnitOfWork
+}
+
+func (r fakeUnitOfWork) WithTransaction(ctx context.Context, _ port.TxOptions, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+// fakeUserService records the users the consumer creates
+type fakeUserService struct {
+	port.UserService
+	created chan createdUser
+}
+
+type createdUser struct {
+	user  *domain.User
+	actor domain.Actor
+}
+
+func (r fakeUserService) Create(ctx context.Context, _ port.UserUnitOfWork, user *domain.User) error {
+	r.created <- createdUser{user: user, actor: domain.ActorFromContext(ctx)}
+	return nil
+}
+
+// newTestImport wires the events of the service to the memory driver, like the importer and its consumer
+func newTestImport(t *testing.T) (*Registry, *messagebroker.Queue, fakeUserService) {
+	t.Helper()
+
+	codecs, err := messagebroker.NewCodecs(config.Queue{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	memory := messagebroker.NewMemory(config.Queue{MaxRedeliveries: 1, ConsumeTimeout: 5}, codecs, nopLogger{})
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_ = memory.Shutdown(ctx)
+	})
+
+	queue := &messagebroker.Queue{Log: nopLogger{}, Driver: memory}
+	userService := fakeUserService{created: make(chan createdUser, 10)}
+
+	registry, err := NewDefaultRegistry(Dependencies{
+		Queue: queue,
+		Log:   nopLogger{},
+		UnitOfWork: func() port.UserUnitOfWork {
+			return fakeUnitOfWork{}
+		},
+		UserService: userService,
+	})
+	if err != nil {
+		t.Fatalf("NewDefaultRegistry() error = %v", err)
+	}
+	if err = registry.Subscribe(); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	return registry, queue, userService
+}
+
+func waitCreated(t *testing.T, userService fakeUserService) createdUser {
+	t.Helper()
+
+	select {
+	case created := <-userService.created:
+		return created
+	case <-time.After(2 * time.Second):
+		t.Fatal("the consumer did not create the user")
+	}
+
+	return createdUser{}
+}
+
+func TestImportPublishAndConsume(t *testing.T) {
+	registry, _, userService := newTestImport(t)
+
+	publisher, err := registry.Publisher(SaveUserName)
+	if err != nil {
+		t.Fatalf("Publisher() error = %v", err)
+	}
+
+	firstName := "John"
+	if err = publisher.Publish(context.Background(), domain.User{FirstName: &firstName, Email: "john@example.com"}); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	created := waitCreated(t, userService)
+	if created.user.Email != "john@example.com" || created.user.FirstName == nil || *created.user.FirstName != firstName {
+		t.Errorf("created user = %+v, want the published user", created.user)
+	}
+	if created.actor != domain.SystemActor(SaveUserName) {
+		t.Errorf("created by %+v, want the consumer of %s", created.actor, SaveUserName)
+	}
+}
+
+func TestImportConsumesLegacyMessage(t *testing.T) {
+	_, queue, userService := newTestImport(t)
+
+	body, err := json.Marshal(domain.User{Base: domain.Base{ID: 7}, Email: "legacy@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a message published before the envelope existed has neither a type nor a version
+	if err = queue.Driver.Produce(context.Background(), SaveUserName, &domain.Envelope{Payload: body}, 0); err != nil {
+		t.Fatalf("Produce() error = %v", err)
+	}
+
+	created := waitCreated(t, userService)
+	if created.user.Email != "legacy@example.com" {
+		t.Errorf("created user = %+v, want the legacy user", created.user)
+	}
+}
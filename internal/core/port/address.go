@@ -1,7 +1,26 @@
 package port
 
-import "github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+)
 
 type AddressRepository interface {
-	Save(userID uint64, address []*domain.Address) error
+	Save(ctx context.Context, userID uint64, address []*domain.Address) error
+	ListByUserID(ctx context.Context, userID uint64) ([]*domain.Address, error)
+	ListByUserIDs(ctx context.Context, userIDs []uint64) (map[uint64][]*domain.Address, error)
+	GetByID(ctx context.Context, userID uint64, id uuid.UUID) (*domain.Address, error)
+	Update(ctx context.Context, userID uint64, address *domain.Address) error
+	SoftDelete(ctx context.Context, userID uint64, address *domain.Address) error
+}
+
+// AddressService the addresses are reached through their user, an address of another user is not found
+type AddressService interface {
+	List(ctx context.Context, uow UserUnitOfWork, userID string) ([]*domain.Address, error)
+	GetByID(ctx context.Context, uow UserUnitOfWork, userID string, id string) (*domain.Address, error)
+	Create(ctx context.Context, uow UserUnitOfWork, userID string, address *domain.Address) (*domain.Address, error)
+	// Update the writes expect the address at version, domain.AnyVersion skips the check
+	Update(ctx context.Context, uow UserUnitOfWork, userID string, id string, version int, address *domain.Address) (*domain.Address, error)
+	Delete(ctx context.Context, uow UserUnitOfWork, userID string, id string, version int) error
 }
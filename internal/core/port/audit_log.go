@@ -0,0 +1,13 @@
+package port
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+)
+
+type AuditLogRepository interface {
+	Save(ctx context.Context, logs ...*domain.AuditLog) error
+	// ListByUserUUID pages through the history of the user newest first
+	ListByUserUUID(ctx context.Context, userUUID uuid.UUID, query domain.AuditLogQuery) (*domain.AuditLogPage, error)
+}
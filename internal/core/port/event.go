@@ -1,8 +1,17 @@
 package port
 
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"time"
+)
+
 type Event interface {
 	Name() string
-	Publish(message interface{}) error
-	Consume(message []byte) error
+	Publish(ctx context.Context, message interface{}) error
+	// PublishAt delivers the message at deliverAt, the returned id is the handle of the scheduled message
+	PublishAt(ctx context.Context, message interface{}, deliverAt time.Time) (uuid.UUID, error)
+	Consume(ctx context.Context, envelope *domain.Envelope) error
 	Register()
 }
@@ -0,0 +1,24 @@
+package port
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"time"
+)
+
+// PurgeRepository removes the personal data of the soft deleted users and addresses
+type PurgeRepository interface {
+	// ListUsers the soft deleted users after the id, locked for the rest of the transaction
+	ListUsers(ctx context.Context, deletedBefore time.Time, afterID uint64, limit int) ([]domain.PurgeCandidate, error)
+	// ListAddresses the soft deleted addresses after the id, locked for the rest of the transaction
+	ListAddresses(ctx context.Context, deletedBefore time.Time, afterID uint64, limit int) ([]domain.PurgeCandidate, error)
+	// DeleteUsers removes the users with their addresses, the modifier columns referring to them are cleared
+	DeleteUsers(ctx context.Context, ids []uint64) error
+	AnonymizeUsers(ctx context.Context, ids []uint64) error
+	DeleteAddresses(ctx context.Context, ids []uint64) error
+	AnonymizeAddresses(ctx context.Context, ids []uint64) error
+	// RedactAuditLog drops the values of the audit entries of the users and of the addresses,
+	// the entries keep the fields which changed
+	RedactAuditLog(ctx context.Context, userUUIDs []uuid.UUID, addressUUIDs []uuid.UUID) error
+}
@@ -1,7 +1,47 @@
 package port
 
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"time"
+)
+
 type Driver interface {
 	Close()
-	Produce(name string, message interface{}, delaySeconds int64) error
-	RegisterConsumer(name string, callback func(message []byte) error) error
+	// Shutdown stops consuming and waits for in-flight handlers until ctx is done before closing
+	Shutdown(ctx context.Context) error
+	Produce(ctx context.Context, name string, message *domain.Envelope, delaySeconds int64) error
+	RegisterConsumer(name string, callback func(ctx context.Context, envelope *domain.Envelope) error) error
+}
+
+// QueueAdmin is implemented by the drivers which support the queue administration commands,
+// a dead-letter queue is addressed by the queue name with the ".dlq" suffix
+type QueueAdmin interface {
+	Stats(ctx context.Context, name string) (*domain.QueueStats, error)
+	// Peek returns up to limit messages matching filter and leaves them on the queue
+	Peek(ctx context.Context, name string, limit int, filter domain.QueueFilter) ([]domain.QueueMessage, error)
+	Purge(ctx context.Context, name string) (int, error)
+	// Move moves up to limit messages matching filter from one queue to the other, limit 0 moves all of them
+	Move(ctx context.Context, from string, to string, limit int, filter domain.QueueFilter) (int, error)
+}
+
+// BufferedDriver is implemented by the drivers which may accept a message before the broker has it
+type BufferedDriver interface {
+	// CloseBuffered closes the driver and returns the accepted messages which are still not published
+	CloseBuffered() []domain.UnpublishedMessage
+}
+
+// HealthChecker is implemented by the drivers which can report whether their backend is reachable
+type HealthChecker interface {
+	Health(ctx context.Context) error
+}
+
+// Scheduler delivers a message at an absolute time, the returned id is the handle to cancel
+// or reschedule the message with until it is dispatched
+type Scheduler interface {
+	Schedule(ctx context.Context, name string, message *domain.Envelope, deliverAt time.Time) (uuid.UUID, error)
+	Get(ctx context.Context, id uuid.UUID) (*domain.ScheduledMessage, error)
+	Cancel(ctx context.Context, id uuid.UUID) error
+	Reschedule(ctx context.Context, id uuid.UUID, deliverAt time.Time) error
 }
@@ -4,10 +4,39 @@ import (
 	"context"
 )
 
+type IsolationLevel int
+
+const (
+	// IsolationDefault is the default of the database, READ COMMITTED on Postgres
+	IsolationDefault IsolationLevel = iota
+	IsolationReadCommitted
+	IsolationRepeatableRead
+	IsolationSerializable
+)
+
+// DefaultTxAttempts is how many times WithTransaction runs fn when it keeps conflicting
+const DefaultTxAttempts = 3
+
+type TxOptions struct {
+	Isolation IsolationLevel
+	// ReadOnly begins the transaction like BeginReadOnlyTx, it may run on a replica
+	ReadOnly bool
+	// MaxAttempts zero uses DefaultTxAttempts
+	MaxAttempts int
+}
+
 type UnitOfWork interface {
+	// WithTransaction runs fn in a transaction begun with opts and commits it, an error or a panic of fn rolls it back.
+	// Called inside fn it runs in a savepoint of the outer transaction and ignores opts,
+	// the outermost call runs fn again when the transaction hits a serialization failure or a deadlock
+	WithTransaction(ctx context.Context, opts TxOptions, fn func(ctx context.Context) error) error
 	BeginTx(ctx context.Context) error
+	// BeginReadOnlyTx begins a READ ONLY transaction, it may run on a replica which is a little behind
+	BeginReadOnlyTx(ctx context.Context) error
 	Commit() error
 	Rollback() error
+	// AfterCommit runs fn once the transaction is committed, a rollback drops it
+	AfterCommit(fn func())
 }
 
 type UserUnitOfWork interface {
@@ -15,5 +44,7 @@ type UserUnitOfWork interface {
 
 	UserRepository() UserRepository
 	AddressRepository() AddressRepository
+	AuditLogRepository() AuditLogRepository
+	PurgeRepository() PurgeRepository
 	// Add other repositories as needed
 }
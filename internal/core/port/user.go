@@ -1,16 +1,46 @@
 package port
 
 import (
+	"context"
 	"github.com/google/uuid"
 	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
 )
 
 type UserRepository interface {
-	GetByID(id uuid.UUID) (*domain.User, error)
-	Save(user *domain.User) (uint64, error)
+	GetByID(ctx context.Context, id uuid.UUID) (*domain.User, error)
+	// GetByIDForUpdate locks the user row until the transaction ends, the writes start from it
+	GetByIDForUpdate(ctx context.Context, id uuid.UUID) (*domain.User, error)
+	GetByIDWithDeleted(ctx context.Context, id uuid.UUID) (*domain.User, error)
+	List(ctx context.Context, query domain.UserListQuery) (*domain.UserPage, error)
+	Search(ctx context.Context, query string, limit int) ([]*domain.UserSearchResult, error)
+	GetActorID(ctx context.Context, id uuid.UUID) (uint64, error)
+	Save(ctx context.Context, user *domain.User) (uint64, error)
+	Update(ctx context.Context, user *domain.User) error
+	// BumpVersion changes the ETag of a user whose addresses changed, the user is locked already
+	BumpVersion(ctx context.Context, user *domain.User) error
+	SoftDelete(ctx context.Context, user *domain.User) error
+	Restore(ctx context.Context, user *domain.User) error
+	HardDelete(ctx context.Context, user *domain.User) error
 }
 
 type UserService interface {
-	GetByID(uow UserUnitOfWork, id string) (*domain.User, error)
-	Create(uow UserUnitOfWork, user *domain.User) error
+	GetByID(ctx context.Context, uow UserUnitOfWork, id string) (*domain.User, error)
+	List(ctx context.Context, uow UserUnitOfWork, query domain.UserListQuery) (*domain.UserPage, error)
+	Search(ctx context.Context, uow UserUnitOfWork, query string, limit int) ([]*domain.UserSearchResult, error)
+	Create(ctx context.Context, uow UserUnitOfWork, user *domain.User) error
+	// Update the writes expect the user at version, domain.AnyVersion skips the check
+	Update(ctx context.Context, uow UserUnitOfWork, id string, version int, user *domain.User) (*domain.User, error)
+	PartialUpdate(ctx context.Context, uow UserUnitOfWork, id string, version int, patch domain.UserPatch) (*domain.User, error)
+	SoftDelete(ctx context.Context, uow UserUnitOfWork, id string, version int) error
+	Restore(ctx context.Context, uow UserUnitOfWork, id string, version int) (*domain.User, error)
+	HardDelete(ctx context.Context, uow UserUnitOfWork, id string, version int) error
+	History(ctx context.Context, uow UserUnitOfWork, id string, query domain.AuditLogQuery) (*domain.AuditLogPage, error)
+}
+
+// UserEventPublisher publishes the user lifecycle events for the downstream services
+type UserEventPublisher interface {
+	UserCreated(ctx context.Context, user *domain.User) error
+	UserUpdated(ctx context.Context, before *domain.User, after *domain.User) error
+	UserDeleted(ctx context.Context, before *domain.User) error
+	AddressChanged(ctx context.Context, userID uuid.UUID, before []*domain.Address, after []*domain.Address) error
 }
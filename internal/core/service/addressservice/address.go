@@ -0,0 +1,204 @@
+package addressservice
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/service/auditservice"
+	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"github.com/mohsenabedy91/Sikabiz/pkg/serviceerror"
+	"github.com/mohsenabedy91/Sikabiz/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+type AddressService struct {
+	log    logger.Logger
+	events port.UserEventPublisher
+}
+
+// New events may be nil in the binaries which do not publish the lifecycle events
+func New(log logger.Logger, events port.UserEventPublisher) *AddressService {
+	return &AddressService{
+		log:    log,
+		events: events,
+	}
+}
+
+func (r *AddressService) List(ctx context.Context, uow port.UserUnitOfWork, userUUIDStr string) (addresses []*domain.Address, err error) {
+	ctx, span := tracing.Start(ctx, "AddressService.List", attribute.String("user.uuid", userUUIDStr))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	user, err := uow.UserRepository().GetByID(ctx, uuid.MustParse(userUUIDStr))
+	if err != nil {
+		return nil, err
+	}
+
+	return uow.AddressRepository().ListByUserID(ctx, user.ID)
+}
+
+func (r *AddressService) GetByID(ctx context.Context, uow port.UserUnitOfWork, userUUIDStr string, uuidStr string) (address *domain.Address, err error) {
+	ctx, span := tracing.Start(ctx, "AddressService.GetByID", attribute.String("user.uuid", userUUIDStr), attribute.String("address.uuid", uuidStr))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	user, err := uow.UserRepository().GetByID(ctx, uuid.MustParse(userUUIDStr))
+	if err != nil {
+		return nil, err
+	}
+
+	return uow.AddressRepository().GetByID(ctx, user.ID, uuid.MustParse(uuidStr))
+}
+
+func (r *AddressService) Create(ctx context.Context, uow port.UserUnitOfWork, userUUIDStr string, address *domain.Address) (created *domain.Address, err error) {
+	ctx, span := tracing.Start(ctx, "AddressService.Create", attribute.String("user.uuid", userUUIDStr))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	err = r.change(ctx, uow, userUUIDStr, func(user *domain.User, actor domain.Actor) (*domain.AuditLog, error) {
+		address.CreatedBy = auditservice.ActorID(actor)
+		if err := uow.AddressRepository().Save(ctx, user.ID, []*domain.Address{address}); err != nil {
+			return nil, err
+		}
+
+		return auditLog(address, domain.AuditCreated, domain.AddressChanges(nil, address)), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return address, nil
+}
+
+// Update replaces the fields of the address
+func (r *AddressService) Update(ctx context.Context, uow port.UserUnitOfWork, userUUIDStr string, uuidStr string, version int, address *domain.Address) (updated *domain.Address, err error) {
+	ctx, span := tracing.Start(ctx, "AddressService.Update", attribute.String("user.uuid", userUUIDStr), attribute.String("address.uuid", uuidStr))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	address.UUID = uuid.MustParse(uuidStr)
+	err = r.change(ctx, uow, userUUIDStr, func(user *domain.User, actor domain.Actor) (*domain.AuditLog, error) {
+		before, err := findAddress(user.Addresses, address.UUID, version)
+		if err != nil {
+			return nil, err
+		}
+
+		address.Version = before.Version
+		address.UpdatedBy = actor.ID
+		if err = uow.AddressRepository().Update(ctx, user.ID, address); err != nil {
+			return nil, err
+		}
+
+		return auditLog(address, domain.AuditUpdated, domain.AddressChanges(before, address)), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return address, nil
+}
+
+func (r *AddressService) Delete(ctx context.Context, uow port.UserUnitOfWork, userUUIDStr string, uuidStr string, version int) (err error) {
+	ctx, span := tracing.Start(ctx, "AddressService.Delete", attribute.String("user.uuid", userUUIDStr), attribute.String("address.uuid", uuidStr))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	return r.change(ctx, uow, userUUIDStr, func(user *domain.User, actor domain.Actor) (*domain.AuditLog, error) {
+		before, err := findAddress(user.Addresses, uuid.MustParse(uuidStr), version)
+		if err != nil {
+			return nil, err
+		}
+
+		// the event publishes the addresses as they were before, the copy leaves them alone
+		address := *before
+		address.DeleteBy = actor.ID
+		if err = uow.AddressRepository().SoftDelete(ctx, user.ID, &address); err != nil {
+			return nil, err
+		}
+
+		return auditLog(&address, domain.AuditDeleted, nil), nil
+	})
+}
+
+// change runs fn on the user locked by GetByIDForUpdate, so concurrent changes of its addresses are serialized
+// and the published event holds the whole address list before and after the change, the user version is
+// bumped, so the ETag of the user, whose representation holds the addresses, changes too,
+// fn returns the audit entry of its change
+func (r *AddressService) change(
+	ctx context.Context,
+	uow port.UserUnitOfWork,
+	userUUIDStr string,
+	fn func(user *domain.User, actor domain.Actor) (*domain.AuditLog, error),
+) error {
+	actor, err := auditservice.Actor(ctx, uow)
+	if err != nil {
+		return err
+	}
+
+	user, err := uow.UserRepository().GetByIDForUpdate(ctx, uuid.MustParse(userUUIDStr))
+	if err != nil {
+		return err
+	}
+
+	entry, err := fn(user, actor)
+	if err != nil {
+		return err
+	}
+
+	if err = uow.UserRepository().BumpVersion(ctx, user); err != nil {
+		return err
+	}
+
+	entry.UserUUID = user.UUID
+	if err = auditservice.Record(ctx, uow, actor, entry); err != nil {
+		return err
+	}
+
+	if r.events == nil {
+		return nil
+	}
+
+	after, err := uow.AddressRepository().ListByUserID(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	uow.AfterCommit(func() {
+		if err := r.events.AddressChanged(ctx, user.UUID, user.Addresses, after); err != nil {
+			r.log.Error(logger.Queue, logger.RabbitMQPublish, fmt.Sprintf("Error publish %s event: %v", domain.AddressChangedType, err), nil)
+		}
+	})
+
+	return nil
+}
+
+func auditLog(address *domain.Address, action string, changes map[string]domain.AuditChange) *domain.AuditLog {
+	return &domain.AuditLog{
+		Entity:     domain.AuditEntityAddress,
+		EntityUUID: address.UUID,
+		Action:     action,
+		Changes:    changes,
+	}
+}
+
+// findAddress returns the address of the user at the expected version
+func findAddress(addresses []*domain.Address, id uuid.UUID, version int) (*domain.Address, error) {
+	for _, address := range addresses {
+		if address.UUID != id {
+			continue
+		}
+		if !address.MatchVersion(version) {
+			return nil, serviceerror.New(serviceerror.PreconditionFailed)
+		}
+		return address, nil
+	}
+
+	return nil, serviceerror.New(serviceerror.RecordNotFound)
+}
@@ -0,0 +1,47 @@
+package auditservice
+
+import (
+	"context"
+	"errors"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
+	"github.com/mohsenabedy91/Sikabiz/pkg/serviceerror"
+)
+
+// Actor resolves the actor of the context to the users.id the modifier columns refer to,
+// a user which does not exist any more is not authorized to change anything
+func Actor(ctx context.Context, uow port.UserUnitOfWork) (domain.Actor, error) {
+	actor := domain.ActorFromContext(ctx)
+	if !actor.IsUser() || actor.ID != 0 {
+		return actor, nil
+	}
+
+	id, err := uow.UserRepository().GetActorID(ctx, actor.UUID)
+	if err != nil {
+		var serviceErr serviceerror.Error
+		if errors.As(err, &serviceErr) && serviceErr.GetErrorMessage() == serviceerror.RecordNotFound {
+			return actor, serviceerror.New(serviceerror.Unauthorized)
+		}
+		return actor, err
+	}
+
+	actor.ID = id
+	return actor, nil
+}
+
+// Record writes the entries by the actor in the unit of work of the change
+func Record(ctx context.Context, uow port.UserUnitOfWork, actor domain.Actor, logs ...*domain.AuditLog) error {
+	for _, entry := range logs {
+		entry.Actor = actor
+	}
+
+	return uow.AuditLogRepository().Save(ctx, logs...)
+}
+
+// ActorID the value of the modifier columns, zero is written as null
+func ActorID(actor domain.Actor) *uint64 {
+	if actor.ID == 0 {
+		return nil
+	}
+	return &actor.ID
+}
@@ -0,0 +1,206 @@
+package purgeservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/service/auditservice"
+	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"github.com/mohsenabedy91/Sikabiz/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ActorName the audit log records the purged rows as removed by it
+const ActorName = "purge"
+
+// errDryRun rolls the transaction of a dry run batch back
+var errDryRun = errors.New("purge dry run")
+
+type PurgeService struct {
+	log logger.Logger
+}
+
+func New(log logger.Logger) *PurgeService {
+	return &PurgeService{
+		log: log,
+	}
+}
+
+// Purge removes the personal data of the users and addresses soft deleted before options.DeletedBefore,
+// every batch commits on its own, so an interrupted run keeps the batches it finished, a dry run rolls
+// every batch back
+func (r *PurgeService) Purge(ctx context.Context, uowFactory func() port.UserUnitOfWork, options domain.PurgeOptions) (report *domain.PurgeReport, err error) {
+	ctx, span := tracing.Start(
+		ctx,
+		"PurgeService.Purge",
+		attribute.String("purge.mode", options.Mode),
+		attribute.Bool("purge.dry_run", options.DryRun),
+	)
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	if options.Mode == "" {
+		options.Mode = domain.PurgeDelete
+	}
+	if options.Mode != domain.PurgeDelete && options.Mode != domain.PurgeAnonymize {
+		return nil, fmt.Errorf("unknown purge mode %q", options.Mode)
+	}
+	if options.BatchSize < 1 {
+		options.BatchSize = domain.DefaultPurgeBatchSize
+	}
+	if domain.ActorFromContext(ctx) == (domain.Actor{}) {
+		ctx = domain.WithActor(ctx, domain.SystemActor(ActorName))
+	}
+
+	report = &domain.PurgeReport{
+		Mode:   options.Mode,
+		DryRun: options.DryRun,
+	}
+
+	// the users go first, their addresses go with them
+	for _, entity := range []string{domain.AuditEntityUser, domain.AuditEntityAddress} {
+		var afterID uint64
+		for {
+			candidates, batchErr := r.batch(ctx, uowFactory(), entity, options, afterID)
+			if batchErr != nil {
+				return report, batchErr
+			}
+
+			if entity == domain.AuditEntityUser {
+				report.Users = append(report.Users, candidates...)
+			} else {
+				report.Addresses = append(report.Addresses, candidates...)
+			}
+
+			if len(candidates) < options.BatchSize {
+				break
+			}
+			afterID = candidates[len(candidates)-1].ID
+		}
+	}
+
+	r.log.WithContext(ctx).Info(logger.Database, logger.Purge, "The retention purge has finished", map[logger.ExtraKey]interface{}{
+		"mode":      report.Mode,
+		"dryRun":    report.DryRun,
+		"users":     len(report.Users),
+		"addresses": len(report.Addresses),
+	})
+
+	return report, nil
+}
+
+func (r *PurgeService) batch(
+	ctx context.Context,
+	uow port.UserUnitOfWork,
+	entity string,
+	options domain.PurgeOptions,
+	afterID uint64,
+) ([]domain.PurgeCandidate, error) {
+	var candidates []domain.PurgeCandidate
+	err := uow.WithTransaction(ctx, port.TxOptions{}, func(ctx context.Context) (err error) {
+		if candidates, err = r.purge(ctx, uow, entity, options, afterID); err != nil {
+			return err
+		}
+		// a dry run rolls the batch back, it only reports the candidates
+		if options.DryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		return candidates, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) > 0 {
+		r.log.WithContext(ctx).Info(logger.Database, logger.Purge, fmt.Sprintf("Purged %d %s rows", len(candidates), entity), map[logger.ExtraKey]interface{}{
+			"mode": options.Mode,
+			"ids":  candidateUUIDs(candidates),
+		})
+	}
+
+	return candidates, nil
+}
+
+func (r *PurgeService) purge(
+	ctx context.Context,
+	uow port.UserUnitOfWork,
+	entity string,
+	options domain.PurgeOptions,
+	afterID uint64,
+) ([]domain.PurgeCandidate, error) {
+	repository := uow.PurgeRepository()
+
+	list, remove := repository.ListUsers, repository.DeleteUsers
+	if entity == domain.AuditEntityAddress {
+		list, remove = repository.ListAddresses, repository.DeleteAddresses
+	}
+	if options.Mode == domain.PurgeAnonymize {
+		remove = repository.AnonymizeUsers
+		if entity == domain.AuditEntityAddress {
+			remove = repository.AnonymizeAddresses
+		}
+	}
+
+	candidates, err := list(ctx, options.DeletedBefore, afterID, options.BatchSize)
+	if err != nil || len(candidates) == 0 || options.DryRun {
+		return candidates, err
+	}
+
+	actor, err := auditservice.Actor(ctx, uow)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint64, 0, len(candidates))
+	for _, candidate := range candidates {
+		ids = append(ids, candidate.ID)
+	}
+	if err = remove(ctx, ids); err != nil {
+		return nil, err
+	}
+
+	// the history keeps which fields changed and when, the values are personal data as well
+	if entity == domain.AuditEntityUser {
+		err = repository.RedactAuditLog(ctx, candidateUUIDs(candidates), nil)
+	} else {
+		err = repository.RedactAuditLog(ctx, nil, candidateUUIDs(candidates))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	action := domain.AuditPurged
+	if options.Mode == domain.PurgeAnonymize {
+		action = domain.AuditAnonymized
+	}
+
+	logs := make([]*domain.AuditLog, 0, len(candidates))
+	for _, candidate := range candidates {
+		logs = append(logs, &domain.AuditLog{
+			UserUUID:   candidate.UserUUID,
+			Entity:     candidate.Entity,
+			EntityUUID: candidate.UUID,
+			Action:     action,
+		})
+	}
+	if err = auditservice.Record(ctx, uow, actor, logs...); err != nil {
+		return nil, err
+	}
+
+	return candidates, nil
+}
+
+func candidateUUIDs(candidates []domain.PurgeCandidate) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(candidates))
+	for _, candidate := range candidates {
+		ids = append(ids, candidate.UUID)
+	}
+	return ids
+}
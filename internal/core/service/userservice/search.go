@@ -0,0 +1,156 @@
+package userservice
+
+import (
+	"context"
+	"fmt"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
+	"github.com/mohsenabedy91/Sikabiz/pkg/tracing"
+	"html"
+	"strings"
+	"unicode"
+)
+
+// highlightSimilarity a word this similar to a search term is highlighted as a misspelling of it
+const highlightSimilarity = 0.4
+
+func (r *UserService) Search(ctx context.Context, uow port.UserUnitOfWork, query string, limit int) (results []*domain.UserSearchResult, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.Search")
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	results, err = uow.UserRepository().Search(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]uint64, 0, len(results))
+	for _, result := range results {
+		userIDs = append(userIDs, result.User.ID)
+	}
+
+	addresses, err := uow.AddressRepository().ListByUserIDs(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := words(strings.ToLower(query))
+	for _, result := range results {
+		result.User.Addresses = addresses[result.User.ID]
+		result.Highlights = highlights(result.User, terms)
+	}
+
+	return results, nil
+}
+
+// highlights returns the fields of the user with a match, the values are HTML escaped
+func highlights(user *domain.User, terms []string) map[string]string {
+	fields := map[string]*string{
+		"first_name": user.FirstName,
+		"last_name":  user.LastName,
+		"email":      &user.Email,
+	}
+	for i, address := range user.Addresses {
+		fields[fmt.Sprintf("addresses.%d.street", i)] = address.Street
+		fields[fmt.Sprintf("addresses.%d.city", i)] = address.City
+		fields[fmt.Sprintf("addresses.%d.state", i)] = address.State
+		fields[fmt.Sprintf("addresses.%d.zip_code", i)] = address.ZipCode
+		fields[fmt.Sprintf("addresses.%d.country", i)] = address.Country
+	}
+
+	result := make(map[string]string)
+	for name, value := range fields {
+		if value == nil {
+			continue
+		}
+		if marked, ok := highlight(*value, terms); ok {
+			result[name] = marked
+		}
+	}
+
+	return result
+}
+
+// highlight marks the part of a word containing a term, or the whole word when it is a misspelling of one
+func highlight(text string, terms []string) (string, bool) {
+	var builder strings.Builder
+	var matched bool
+
+	runes := []rune(text)
+	for start := 0; start < len(runes); {
+		end := start + 1
+		isWord := isWordRune(runes[start])
+		for end < len(runes) && isWordRune(runes[end]) == isWord {
+			end++
+		}
+
+		token := string(runes[start:end])
+		if isWord {
+			marked, ok := highlightWord(token, terms)
+			builder.WriteString(marked)
+			matched = matched || ok
+		} else {
+			builder.WriteString(html.EscapeString(token))
+		}
+		start = end
+	}
+
+	return builder.String(), matched
+}
+
+func highlightWord(word string, terms []string) (string, bool) {
+	lower := strings.ToLower(word)
+	for _, term := range terms {
+		// ToLower keeps the length of the usual letters, the offsets only hold when it does
+		if index := strings.Index(lower, term); index >= 0 && len(lower) == len(word) {
+			return html.EscapeString(word[:index]) +
+				"<mark>" + html.EscapeString(word[index:index+len(term)]) + "</mark>" +
+				html.EscapeString(word[index+len(term):]), true
+		}
+	}
+
+	for _, term := range terms {
+		if similarity(lower, term) >= highlightSimilarity {
+			return "<mark>" + html.EscapeString(word) + "</mark>", true
+		}
+	}
+
+	return html.EscapeString(word), false
+}
+
+// similarity compares the trigrams of two words the way pg_trgm does
+func similarity(a string, b string) float64 {
+	left, right := trigrams(a), trigrams(b)
+	if len(left) == 0 || len(right) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for trigram := range left {
+		if right[trigram] {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(left)+len(right)-shared)
+}
+
+func trigrams(word string) map[string]bool {
+	runes := []rune("  " + word + " ")
+	result := make(map[string]bool, len(runes))
+	for i := 0; i+3 <= len(runes); i++ {
+		result[string(runes[i:i+3])] = true
+	}
+	return result
+}
+
+func words(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !isWordRune(r)
+	})
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,78 @@
+package userservice
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want float64
+	}{
+		{a: "john", b: "john", want: 1},
+		{a: "johm", b: "john", want: 3.0 / 7},
+		{a: "jon", b: "john", want: 2.0 / 7},
+		{a: "smith", b: "john", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"/"+tt.b, func(t *testing.T) {
+			if got := similarity(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+				t.Fatalf("similarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+			if got := similarity(tt.b, tt.a); math.Abs(got-tt.want) > 1e-9 {
+				t.Fatalf("similarity(%q, %q) = %v, want %v", tt.b, tt.a, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHighlightWord(t *testing.T) {
+	tests := []struct {
+		name    string
+		word    string
+		terms   []string
+		want    string
+		matched bool
+	}{
+		{name: "prefix keeps the case", word: "Johnson", terms: []string{"john"}, want: "<mark>John</mark>son", matched: true},
+		{name: "infix", word: "Littlejohn", terms: []string{"john"}, want: "Little<mark>john</mark>", matched: true},
+		{name: "second term", word: "Smith", terms: []string{"john", "mit"}, want: "S<mark>mit</mark>h", matched: true},
+		{name: "misspelling marks the whole word", word: "Johm", terms: []string{"john"}, want: "<mark>Johm</mark>", matched: true},
+		{name: "below the similarity", word: "Jon", terms: []string{"john"}, want: "Jon", matched: false},
+		{name: "no terms", word: "John", terms: nil, want: "John", matched: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, matched := highlightWord(tt.word, tt.terms)
+			if got != tt.want || matched != tt.matched {
+				t.Fatalf("highlightWord(%q) = %q, %v, want %q, %v", tt.word, got, matched, tt.want, tt.matched)
+			}
+		})
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		terms   []string
+		want    string
+		matched bool
+	}{
+		{name: "email", text: "john.doe@example.com", terms: []string{"doe"}, want: "john.<mark>doe</mark>@example.com", matched: true},
+		{name: "escapes the text around a match", text: "O'Brien <john>", terms: []string{"john"}, want: "O&#39;Brien &lt;<mark>john</mark>&gt;", matched: true},
+		{name: "escapes without a match", text: "<b>Smith</b>", terms: []string{"john"}, want: "&lt;b&gt;Smith&lt;/b&gt;", matched: false},
+		{name: "several words", text: "John Johnson", terms: []string{"john"}, want: "<mark>John</mark> <mark>John</mark>son", matched: true},
+		{name: "empty", text: "", terms: []string{"john"}, want: "", matched: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, matched := highlight(tt.text, tt.terms)
+			if got != tt.want || matched != tt.matched {
+				t.Fatalf("highlight(%q) = %q, %v, want %q, %v", tt.text, got, matched, tt.want, tt.matched)
+			}
+		})
+	}
+}
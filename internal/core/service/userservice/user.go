@@ -1,39 +1,325 @@
 package userservice
 
 import (
+	"context"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/mohsenabedy91/Sikabiz/internal/core/domain"
 	"github.com/mohsenabedy91/Sikabiz/internal/core/port"
+	"github.com/mohsenabedy91/Sikabiz/internal/core/service/auditservice"
 	"github.com/mohsenabedy91/Sikabiz/pkg/logger"
+	"github.com/mohsenabedy91/Sikabiz/pkg/serviceerror"
+	"github.com/mohsenabedy91/Sikabiz/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type UserService struct {
-	log logger.Logger
+	log    logger.Logger
+	events port.UserEventPublisher
 }
 
-func New(log logger.Logger) *UserService {
+// New events may be nil in the binaries which do not publish the lifecycle events
+func New(log logger.Logger, events port.UserEventPublisher) *UserService {
 	return &UserService{
-		log: log,
+		log:    log,
+		events: events,
 	}
 }
 
-func (r *UserService) GetByID(uow port.UserUnitOfWork, uuidStr string) (user *domain.User, err error) {
-	user, err = uow.UserRepository().GetByID(uuid.MustParse(uuidStr))
+func (r *UserService) GetByID(ctx context.Context, uow port.UserUnitOfWork, uuidStr string) (user *domain.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.GetByID", attribute.String("user.uuid", uuidStr))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	user, err = uow.UserRepository().GetByID(ctx, uuid.MustParse(uuidStr))
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (r *UserService) List(ctx context.Context, uow port.UserUnitOfWork, query domain.UserListQuery) (page *domain.UserPage, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.List", attribute.String("user.sort", query.Sort))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	page, err = uow.UserRepository().List(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]uint64, 0, len(page.Users))
+	for _, user := range page.Users {
+		userIDs = append(userIDs, user.ID)
+	}
+
+	addresses, err := uow.AddressRepository().ListByUserIDs(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, user := range page.Users {
+		user.Addresses = addresses[user.ID]
+	}
+
+	return page, nil
+}
+
+func (r *UserService) Create(ctx context.Context, uow port.UserUnitOfWork, user *domain.User) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.Create")
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	actor, err := auditservice.Actor(ctx, uow)
+	if err != nil {
+		return err
+	}
+
+	user.CreatedBy = auditservice.ActorID(actor)
+	for _, address := range user.Addresses {
+		address.CreatedBy = user.CreatedBy
+	}
+
+	userID, err := uow.UserRepository().Save(ctx, user)
+	if err != nil {
+		return err
+	}
+	if err = uow.AddressRepository().Save(ctx, userID, user.Addresses); err != nil {
+		return err
+	}
+
+	logs := []*domain.AuditLog{
+		userLog(user, domain.AuditCreated, domain.UserChanges(nil, user)),
+	}
+	for _, address := range user.Addresses {
+		logs = append(logs, addressLog(user, address, domain.AuditCreated, domain.AddressChanges(nil, address)))
+	}
+	if err = auditservice.Record(ctx, uow, actor, logs...); err != nil {
+		return err
+	}
+
+	r.afterCommit(ctx, uow, domain.UserCreatedType, func(ctx context.Context) error {
+		return r.events.UserCreated(ctx, user)
+	})
+
+	return nil
+}
+
+// Update replaces the fields of the user, the addresses are managed on their own
+func (r *UserService) Update(ctx context.Context, uow port.UserUnitOfWork, uuidStr string, version int, user *domain.User) (after *domain.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.Update", attribute.String("user.uuid", uuidStr))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	return r.update(ctx, uow, uuidStr, version, func(after *domain.User) {
+		after.FirstName = user.FirstName
+		after.LastName = user.LastName
+		after.Email = user.Email
+		after.PhoneNumber = user.PhoneNumber
+	})
+}
+
+func (r *UserService) PartialUpdate(ctx context.Context, uow port.UserUnitOfWork, uuidStr string, version int, patch domain.UserPatch) (after *domain.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.PartialUpdate", attribute.String("user.uuid", uuidStr))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	return r.update(ctx, uow, uuidStr, version, patch.Apply)
+}
+
+func (r *UserService) update(ctx context.Context, uow port.UserUnitOfWork, uuidStr string, version int, change func(after *domain.User)) (*domain.User, error) {
+	actor, err := auditservice.Actor(ctx, uow)
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := uow.UserRepository().GetByIDForUpdate(ctx, uuid.MustParse(uuidStr))
+	if err != nil {
+		return nil, err
+	}
+	if !before.MatchVersion(version) {
+		return nil, serviceerror.New(serviceerror.PreconditionFailed)
+	}
+
+	after := *before
+	change(&after)
+	after.UpdatedBy = actor.ID
+	if err = uow.UserRepository().Update(ctx, &after); err != nil {
+		return nil, err
+	}
+
+	if err = auditservice.Record(ctx, uow, actor, userLog(&after, domain.AuditUpdated, domain.UserChanges(before, &after))); err != nil {
+		return nil, err
+	}
+
+	r.afterCommit(ctx, uow, domain.UserUpdatedType, func(ctx context.Context) error {
+		return r.events.UserUpdated(ctx, before, &after)
+	})
+
+	return &after, nil
+}
+
+func (r *UserService) SoftDelete(ctx context.Context, uow port.UserUnitOfWork, uuidStr string, version int) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.SoftDelete", attribute.String("user.uuid", uuidStr))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	actor, err := auditservice.Actor(ctx, uow)
 	if err != nil {
+		return err
+	}
+
+	before, err := uow.UserRepository().GetByIDForUpdate(ctx, uuid.MustParse(uuidStr))
+	if err != nil {
+		return err
+	}
+	if !before.MatchVersion(version) {
+		return serviceerror.New(serviceerror.PreconditionFailed)
+	}
+	before.DeleteBy = actor.ID
+	if err = uow.UserRepository().SoftDelete(ctx, before); err != nil {
+		return err
+	}
+
+	if err = auditservice.Record(ctx, uow, actor, userLog(before, domain.AuditDeleted, nil)); err != nil {
+		return err
+	}
+
+	r.afterCommit(ctx, uow, domain.UserDeletedType, func(ctx context.Context) error {
+		return r.events.UserDeleted(ctx, before)
+	})
+
+	return nil
+}
+
+// Restore the downstream services dropped the user when it was deleted, so it is published as created
+func (r *UserService) Restore(ctx context.Context, uow port.UserUnitOfWork, uuidStr string, version int) (user *domain.User, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.Restore", attribute.String("user.uuid", uuidStr))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	actor, err := auditservice.Actor(ctx, uow)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted, err := uow.UserRepository().GetByIDWithDeleted(ctx, uuid.MustParse(uuidStr))
+	if err != nil {
+		return nil, err
+	}
+	if !deleted.MatchVersion(version) {
+		return nil, serviceerror.New(serviceerror.PreconditionFailed)
+	}
+
+	deleted.UpdatedBy = actor.ID
+	if err = uow.UserRepository().Restore(ctx, deleted); err != nil {
+		return nil, err
+	}
+	if user, err = uow.UserRepository().GetByID(ctx, deleted.UUID); err != nil {
 		return nil, err
 	}
 
+	if err = auditservice.Record(ctx, uow, actor, userLog(user, domain.AuditRestored, nil)); err != nil {
+		return nil, err
+	}
+
+	r.afterCommit(ctx, uow, domain.UserCreatedType, func(ctx context.Context) error {
+		return r.events.UserCreated(ctx, user)
+	})
+
 	return user, nil
 }
 
-func (r *UserService) Create(uow port.UserUnitOfWork, user *domain.User) error {
-	userID, userErr := uow.UserRepository().Save(user)
-	if userErr != nil {
-		return userErr
+// HardDelete also removes a soft deleted user, its deletion was published already
+func (r *UserService) HardDelete(ctx context.Context, uow port.UserUnitOfWork, uuidStr string, version int) (err error) {
+	ctx, span := tracing.Start(ctx, "UserService.HardDelete", attribute.String("user.uuid", uuidStr))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	actor, err := auditservice.Actor(ctx, uow)
+	if err != nil {
+		return err
+	}
+
+	before, err := uow.UserRepository().GetByIDWithDeleted(ctx, uuid.MustParse(uuidStr))
+	if err != nil {
+		return err
+	}
+	if !before.MatchVersion(version) {
+		return serviceerror.New(serviceerror.PreconditionFailed)
+	}
+	if err = uow.UserRepository().HardDelete(ctx, before); err != nil {
+		return err
+	}
+
+	// the entries keep the last values, the rows are gone
+	logs := []*domain.AuditLog{
+		userLog(before, domain.AuditDestroyed, domain.UserChanges(before, nil)),
 	}
-	if err := uow.AddressRepository().Save(userID, user.Addresses); err != nil {
+	for _, address := range before.Addresses {
+		logs = append(logs, addressLog(before, address, domain.AuditDestroyed, domain.AddressChanges(address, nil)))
+	}
+	if err = auditservice.Record(ctx, uow, actor, logs...); err != nil {
 		return err
 	}
 
+	if before.DeletedAt.IsZero() {
+		r.afterCommit(ctx, uow, domain.UserDeletedType, func(ctx context.Context) error {
+			return r.events.UserDeleted(ctx, before)
+		})
+	}
+
 	return nil
 }
+
+// History the changes of the user and its addresses newest first, the history of a deleted user is kept
+func (r *UserService) History(ctx context.Context, uow port.UserUnitOfWork, uuidStr string, query domain.AuditLogQuery) (page *domain.AuditLogPage, err error) {
+	ctx, span := tracing.Start(ctx, "UserService.History", attribute.String("user.uuid", uuidStr))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	return uow.AuditLogRepository().ListByUserUUID(ctx, uuid.MustParse(uuidStr), query)
+}
+
+func userLog(user *domain.User, action string, changes map[string]domain.AuditChange) *domain.AuditLog {
+	return &domain.AuditLog{
+		UserUUID:   user.UUID,
+		Entity:     domain.AuditEntityUser,
+		EntityUUID: user.UUID,
+		Action:     action,
+		Changes:    changes,
+	}
+}
+
+func addressLog(user *domain.User, address *domain.Address, action string, changes map[string]domain.AuditChange) *domain.AuditLog {
+	return &domain.AuditLog{
+		UserUUID:   user.UUID,
+		Entity:     domain.AuditEntityAddress,
+		EntityUUID: address.UUID,
+		Action:     action,
+		Changes:    changes,
+	}
+}
+
+// afterCommit publishes the event once the change is committed, a failed publish is logged
+// and does not undo the change
+func (r *UserService) afterCommit(ctx context.Context, uow port.UserUnitOfWork, eventType string, publish func(ctx context.Context) error) {
+	if r.events == nil {
+		return
+	}
+
+	uow.AfterCommit(func() {
+		if err := publish(ctx); err != nil {
+			r.log.WithContext(ctx).Error(logger.Queue, logger.RabbitMQPublish, fmt.Sprintf("Error publish %s event: %v", eventType, err), nil)
+		}
+	})
+}
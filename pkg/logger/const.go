@@ -58,6 +58,8 @@ const (
 	DatabaseBeginTransaction SubCategory = "DatabaseBeginTransaction"
 	DatabaseCommit           SubCategory = "DatabaseCommit"
 	DatabaseRollback         SubCategory = "DatabaseRollback"
+	DatabaseSavepoint        SubCategory = "DatabaseSavepoint"
+	DatabaseRetry            SubCategory = "DatabaseRetry"
 	DatabasePrepare          SubCategory = "DatabasePrepare"
 	MigrationUp              SubCategory = "MigrationUp"
 	MigrationDown            SubCategory = "MigrationDown"
@@ -107,6 +109,17 @@ const (
 	RabbitMQPublish          SubCategory = "RabbitMQPublish"
 	RabbitMQConsume          SubCategory = "RabbitMQConsume"
 	RabbitMQRegisterConsumer SubCategory = "RabbitMQRegisterConsumer"
+	RabbitMQBuffer           SubCategory = "RabbitMQBuffer"
+
+	Postgres        SubCategory = "Postgres"
+	PostgresProduce SubCategory = "PostgresProduce"
+	PostgresConsume SubCategory = "PostgresConsume"
+
+	Scheduler SubCategory = "Scheduler"
+
+	Purge SubCategory = "Purge"
+
+	Tracing SubCategory = "Tracing"
 
 	MinioCreateBucket SubCategory = "MinioCreateBucket"
 	MinioUpload       SubCategory = "MinioUpload"
@@ -139,5 +152,14 @@ const (
 	CacheKey    ExtraKey = "CacheKey"
 	CacheSetArg ExtraKey = "CacheSetArg"
 
-	QueueName ExtraKey = "QueueName"
+	QueueName      ExtraKey = "QueueName"
+	MessageID      ExtraKey = "MessageID"
+	MessageType    ExtraKey = "MessageType"
+	MessageVersion ExtraKey = "MessageVersion"
+	MessageCount   ExtraKey = "MessageCount"
+	ScheduleID     ExtraKey = "ScheduleID"
+	DeliverAt      ExtraKey = "DeliverAt"
+
+	TraceID ExtraKey = "TraceID"
+	SpanID  ExtraKey = "SpanID"
 )
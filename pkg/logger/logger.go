@@ -1,7 +1,9 @@
 package logger
 
 import (
+	"context"
 	"fmt"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
@@ -26,6 +28,7 @@ type Logger interface {
 	ErrorF(template string, args ...interface{})
 	Fatal(category Category, subCategory SubCategory, message string, extra map[ExtraKey]interface{})
 	FatalF(template string, args ...interface{})
+	WithContext(ctx context.Context) Logger
 }
 
 type ZapLogger struct {
@@ -155,3 +158,19 @@ func (r *ZapLogger) Fatal(cat Category, sub SubCategory, msg string, extra map[E
 func (r *ZapLogger) FatalF(template string, args ...interface{}) {
 	r.logger.Fatalf(template, args...)
 }
+
+// WithContext adds the trace and span IDs of the span in ctx, so a log line can be found from its trace
+func (r *ZapLogger) WithContext(ctx context.Context) Logger {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return r
+	}
+
+	return &ZapLogger{
+		config: r.config,
+		logger: r.logger.With(
+			string(TraceID), spanContext.TraceID().String(),
+			string(SpanID), spanContext.SpanID().String(),
+		),
+	}
+}
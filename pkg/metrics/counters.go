@@ -8,3 +8,25 @@ var DbCall = prometheus.NewCounterVec(
 		Help: "Number of database calls",
 	}, []string{"type_name", "operation_name", "status"},
 )
+
+const (
+	QueueConsumed     = "consumed"
+	QueueAcked        = "acked"
+	QueueNacked       = "nacked"
+	QueueRetried      = "retried"
+	QueueDeadLettered = "dead_lettered"
+)
+
+var QueueMessage = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "queue_messages_total",
+		Help: "Number of queue messages by outcome",
+	}, []string{"queue", "outcome"},
+)
+
+var QueueReconnect = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "queue_reconnects_total",
+		Help: "Number of reconnects to the queue backend",
+	}, []string{"driver"},
+)
@@ -8,3 +8,10 @@ var HttpDuration = prometheus.NewHistogramVec(
 		Help:    "Duration of HTTP requests",
 		Buckets: []float64{1, 2, 5, 10, 50, 100, 200, 500, 1000, 2000, 5000, 10000},
 	}, []string{"path", "method", "status_code"})
+
+var QueueHandlerDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "queue_handler_duration",
+		Help:    "Duration of queue message handlers",
+		Buckets: []float64{1, 2, 5, 10, 50, 100, 200, 500, 1000, 2000, 5000, 10000},
+	}, []string{"queue", "status"})
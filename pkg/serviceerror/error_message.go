@@ -13,14 +13,21 @@ var (
 	NoRowsEffected     ErrorMessage = "errors.noRowsEffected"
 	FailedSendEmail    ErrorMessage = "errors.failedSendEmail"
 
+	// Concurrency
+	VersionConflict      ErrorMessage = "errors.versionConflict"
+	PreconditionFailed   ErrorMessage = "errors.preconditionFailed"
+	PreconditionRequired ErrorMessage = "errors.preconditionRequired"
+	TransactionConflict  ErrorMessage = "errors.transactionConflict"
+
 	// User
-	UserIsBanned      ErrorMessage = "errors.userIsBanned"
-	UserInActive      ErrorMessage = "errors.userInActive"
-	UserUnVerified    ErrorMessage = "errors.userUnVerified"
-	EmailRegistered   ErrorMessage = "errors.emailRegistered"
-	CredentialInvalid ErrorMessage = "errors.credentialInvalid"
-	UserLogout        ErrorMessage = "errors.userLogout"
-	PasswordIsNull    ErrorMessage = "errors.passwordIsNull"
+	UserIsBanned          ErrorMessage = "errors.userIsBanned"
+	UserInActive          ErrorMessage = "errors.userInActive"
+	UserUnVerified        ErrorMessage = "errors.userUnVerified"
+	EmailRegistered       ErrorMessage = "errors.emailRegistered"
+	PhoneNumberRegistered ErrorMessage = "errors.phoneNumberRegistered"
+	CredentialInvalid     ErrorMessage = "errors.credentialInvalid"
+	UserLogout            ErrorMessage = "errors.userLogout"
+	PasswordIsNull        ErrorMessage = "errors.passwordIsNull"
 
 	// OTP
 	InvalidOTP ErrorMessage = "errors.invalidOTP"
@@ -34,6 +41,7 @@ var (
 
 	// Validation
 	InvalidRequestBody ErrorMessage = "errors.invalidRequestBody"
+	InvalidCursor      ErrorMessage = "errors.invalidCursor"
 
 	// Role
 	RoleExisted ErrorMessage = "errors.roleExisted"